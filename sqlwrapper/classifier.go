@@ -0,0 +1,265 @@
+package sqlwrapper
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// ErrorClassifier 将驱动返回的错误归类为 ErrorKind，无法归类时返回 Unclassified。
+//
+// 内置的 ErrorClassifier 不依赖驱动的包，而是读取驱动错误类型中的错误码字段（如 MySQL 的 Number、
+// Postgresql 的 Code 或 SQLState 方法）或者错误信息中的错误码（如 Oracle 的 ORA-00001）。
+// 使用其他驱动时可以用 RegisterErrorClassifier 注册，或者用 WithErrorClassifier 指定。
+type ErrorClassifier interface {
+	Classify(err error) ErrorKind
+}
+
+// ErrorClassifierFunc 将函数转换为 ErrorClassifier。
+type ErrorClassifierFunc func(err error) ErrorKind
+
+func (f ErrorClassifierFunc) Classify(err error) ErrorKind { return f(err) }
+
+var (
+	mysqlClassifier      = ErrorClassifierFunc(classifyMySQL)
+	sqliteClassifier     = ErrorClassifierFunc(classifySQLite)
+	postgresqlClassifier = ErrorClassifierFunc(classifyPostgresql)
+	sqlserverClassifier  = ErrorClassifierFunc(classifySQLServer)
+	oracleClassifier     = ErrorClassifierFunc(classifyOracle)
+	defaultClassifier    = ErrorClassifierFunc(classifyCommon)
+)
+
+var (
+	classifierMap = map[string]ErrorClassifier{
+		"sqlite":    sqliteClassifier,
+		"sqlite3":   sqliteClassifier,
+		"mysql":     mysqlClassifier,
+		"oracle":    oracleClassifier,
+		"oci8":      oracleClassifier,
+		"pgx":       postgresqlClassifier,
+		"postgres":  postgresqlClassifier,
+		"mssql":     sqlserverClassifier,
+		"sqlserver": sqlserverClassifier,
+	}
+)
+
+func GetErrorClassifier(driver string) ErrorClassifier {
+	if c, ok := classifierMap[driver]; ok {
+		return c
+	}
+	return defaultClassifier
+}
+
+// RegisterErrorClassifier 为 driver 注册 ErrorClassifier，与 RegisterDialect 一样不能重复注册内置的 driver。
+func RegisterErrorClassifier(driver string, c ErrorClassifier) error {
+	if _, ok := classifierMap[driver]; ok {
+		return ErrClassifierAlreadyRegistered
+	}
+	classifierMap[driver] = c
+	return nil
+}
+
+// classify 使用 db 的 ErrorClassifier 判断错误类型，无法归类时判断是否为超时。
+func (db *Database) classify(err error) ErrorKind {
+	if k := db.classifier.Classify(err); k != Unclassified {
+		return k
+	}
+	return classifyCommon(err)
+}
+
+// kindOf 返回 err 的 ErrorKind，err 不是 *OpError（如 run 直接返回了驱动的错误）时使用 db 的 ErrorClassifier 判断。
+func (db *Database) kindOf(err error) ErrorKind {
+	var e *OpError
+	if errors.As(err, &e) {
+		return e.Kind
+	}
+	return db.classify(err)
+}
+
+// newError 包装驱动返回的错误。err 已经是 *OpError（如扫描时出错）时只补充 SQL 语句。
+func (db *Database) newError(op, query string, err error) error {
+	db.stats.errors.Add(1)
+	if e, ok := err.(*OpError); ok {
+		if len(e.SQL) == 0 {
+			e.SQL = query
+		}
+		return e
+	}
+	return &OpError{Op: op, SQL: query, Kind: db.classify(err), Err: err}
+}
+
+// classifyCommon 判断与数据库无关的超时错误：context 超时和实现了 Timeout() bool 的网络错误。
+func classifyCommon(err error) ErrorKind {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return Timeout
+	}
+	var t interface{ Timeout() bool }
+	if errors.As(err, &t) && t.Timeout() {
+		return Timeout
+	}
+	return Unclassified
+}
+
+// errorField 返回错误链中第一个含有名为 name 的字段的错误的该字段，如 *mysql.MySQLError 的 Number。
+func errorField(err error, name string) (reflect.Value, bool) {
+	for ; err != nil; err = errors.Unwrap(err) {
+		v := reflect.ValueOf(err)
+		for v.Kind() == reflect.Ptr && !v.IsNil() {
+			v = v.Elem()
+		}
+		if v.Kind() != reflect.Struct {
+			continue
+		}
+		if f := v.FieldByName(name); f.IsValid() {
+			return f, true
+		}
+	}
+	return reflect.Value{}, false
+}
+
+// errorNumber 返回错误链中名为 name 的整数字段。
+func errorNumber(err error, name string) (int64, bool) {
+	f, ok := errorField(err, name)
+	if !ok {
+		return 0, false
+	}
+	switch f.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return f.Int(), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return int64(f.Uint()), true
+	}
+	return 0, false
+}
+
+var mysqlErrorNumber = regexp.MustCompile(`^Error (\d+)`)
+
+// classifyMySQL 根据 github.com/go-sql-driver/mysql 的 MySQLError.Number 判断错误类型。
+func classifyMySQL(err error) ErrorKind {
+	n, ok := errorNumber(err, "Number")
+	if !ok {
+		m := mysqlErrorNumber.FindStringSubmatch(err.Error())
+		if m == nil {
+			return Unclassified
+		}
+		n, _ = strconv.ParseInt(m[1], 10, 64)
+	}
+	switch n {
+	case 1062, 1586:
+		return UniqueViolation
+	case 1216, 1217, 1451, 1452:
+		return ForeignKeyViolation
+	case 1048, 1364:
+		return NotNullViolation
+	case 1213:
+		return Deadlock
+	case 1205, 3024, 3572:
+		// lock wait timeout, max_execution_time exceeded, NOWAIT
+		return Timeout
+	}
+	return Unclassified
+}
+
+// classifyPostgresql 根据 SQLSTATE 判断错误类型，pgx 的 PgError 有 SQLState 方法，lib/pq 的 Error 有 Code 字段。
+func classifyPostgresql(err error) ErrorKind {
+	return classifySQLState(err)
+}
+
+func classifySQLState(err error) ErrorKind {
+	code := ""
+	var s interface{ SQLState() string }
+	if errors.As(err, &s) {
+		code = s.SQLState()
+	} else if f, ok := errorField(err, "Code"); ok && f.Kind() == reflect.String {
+		code = f.String()
+	}
+	switch code {
+	case "23505":
+		return UniqueViolation
+	case "23503":
+		return ForeignKeyViolation
+	case "23502":
+		return NotNullViolation
+	case "40P01":
+		return Deadlock
+	case "40001":
+		return SerializationFailure
+	case "57014", "55P03":
+		// query_canceled (statement_timeout), lock_not_available
+		return Timeout
+	}
+	return Unclassified
+}
+
+// classifySQLite 根据错误信息判断错误类型，github.com/mattn/go-sqlite3 和 modernc.org/sqlite 的错误信息相同。
+func classifySQLite(err error) ErrorKind {
+	msg := err.Error()
+	switch {
+	case strings.Contains(msg, "UNIQUE constraint failed"):
+		return UniqueViolation
+	case strings.Contains(msg, "FOREIGN KEY constraint failed"):
+		return ForeignKeyViolation
+	case strings.Contains(msg, "NOT NULL constraint failed"):
+		return NotNullViolation
+	case strings.Contains(msg, "database is locked"), strings.Contains(msg, "database table is locked"):
+		// SQLITE_BUSY after busy_timeout
+		return Timeout
+	}
+	return Unclassified
+}
+
+// classifySQLServer 根据 github.com/microsoft/go-mssqldb 的 Error.Number 判断错误类型。
+func classifySQLServer(err error) ErrorKind {
+	n, ok := errorNumber(err, "Number")
+	if !ok {
+		return Unclassified
+	}
+	switch n {
+	case 2601, 2627:
+		return UniqueViolation
+	case 547:
+		// also raised by check constraints
+		if strings.Contains(err.Error(), "FOREIGN KEY") || strings.Contains(err.Error(), "REFERENCE") {
+			return ForeignKeyViolation
+		}
+	case 515:
+		return NotNullViolation
+	case 1205:
+		return Deadlock
+	case 3960:
+		// snapshot isolation update conflict
+		return SerializationFailure
+	case 1222:
+		return Timeout
+	}
+	return Unclassified
+}
+
+var oracleErrorCode = regexp.MustCompile(`ORA-(\d{5})`)
+
+// classifyOracle 根据错误信息中的 ORA 错误码判断错误类型，适用于所有 Oracle 驱动。
+func classifyOracle(err error) ErrorKind {
+	m := oracleErrorCode.FindStringSubmatch(err.Error())
+	if m == nil {
+		return Unclassified
+	}
+	switch m[1] {
+	case "00001":
+		return UniqueViolation
+	case "02291", "02292":
+		return ForeignKeyViolation
+	case "01400", "01407":
+		return NotNullViolation
+	case "00060":
+		return Deadlock
+	case "08177":
+		return SerializationFailure
+	case "00054", "01013", "30006":
+		// resource busy (NOWAIT), user requested cancel, resource busy (WAIT timeout)
+		return Timeout
+	}
+	return Unclassified
+}
@@ -0,0 +1,5 @@
+//go:build oracle
+
+package main
+
+import _ "github.com/sijms/go-ora/v2"
@@ -0,0 +1,5 @@
+//go:build sqlserver
+
+package main
+
+import _ "github.com/microsoft/go-mssqldb"
@@ -0,0 +1,59 @@
+// sqlwrapper-gen 连接数据库，根据已有的表生成 entity 结构体。
+//
+// 驱动通过 build tag 引入（mysql, pgx, postgres, sqlite, sqlserver, oracle），如：
+//
+//	go install -tags mysql github.com/FlyingOnion/pkg/sqlwrapper/cmd/sqlwrapper-gen@latest
+//	sqlwrapper-gen -driver mysql -dsn 'user:pass@tcp(127.0.0.1:3306)/db' -pkg model -tables emp,dept -o model/entity.go
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/FlyingOnion/pkg/sqlwrapper"
+	"github.com/FlyingOnion/pkg/sqlwrapper/gen"
+)
+
+func main() {
+	var (
+		driver = flag.String("driver", "", "database driver name, e.g. mysql, pgx, sqlite, sqlserver, oracle")
+		dsn    = flag.String("dsn", "", "data source name")
+		pkg    = flag.String("pkg", "entity", "package name of the generated file")
+		tables = flag.String("tables", "", "comma separated table names, all tables if empty")
+		output = flag.String("o", "", "output file, stdout if empty")
+	)
+	flag.Parse()
+	if len(*driver) == 0 || len(*dsn) == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	if err := run(*driver, *dsn, *pkg, *tables, *output); err != nil {
+		fmt.Fprintln(os.Stderr, "sqlwrapper-gen:", err)
+		os.Exit(1)
+	}
+}
+
+func run(driver, dsn, pkg, tables, output string) error {
+	db, err := sqlwrapper.NewDatabase(driver, dsn)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	options := []gen.Option{gen.WithPackage(pkg)}
+	if len(tables) > 0 {
+		options = append(options, gen.WithTables(strings.Split(tables, ",")...))
+	}
+	buf := &bytes.Buffer{}
+	if err = gen.Generate(db, buf, options...); err != nil {
+		return err
+	}
+	if len(output) == 0 {
+		_, err = os.Stdout.Write(buf.Bytes())
+		return err
+	}
+	return os.WriteFile(output, buf.Bytes(), 0o644)
+}
@@ -0,0 +1,39 @@
+// sqlwrapper-mapper 为结构体生成 sqlwrapper.Mapper 的实现，使查询和写入读写字段时不使用反射。
+//
+// 在 entity 所在的包中添加：
+//
+//	//go:generate go run github.com/FlyingOnion/pkg/sqlwrapper/cmd/sqlwrapper-mapper -type Employee,Dept
+//
+// 结构体的字段变化后需要重新执行 go generate。
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/FlyingOnion/pkg/sqlwrapper/gen"
+)
+
+func main() {
+	var (
+		types  = flag.String("type", "", "comma separated struct names")
+		dir    = flag.String("dir", ".", "package directory")
+		output = flag.String("o", "sqlwrapper_mapper.go", "output file, relative to dir")
+	)
+	flag.Parse()
+	if len(*types) == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	src, err := gen.Mappers(*dir, strings.Split(*types, ",")...)
+	if err == nil {
+		err = os.WriteFile(filepath.Join(*dir, *output), src, 0o644)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "sqlwrapper-mapper:", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,130 @@
+package sqlwrapper
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"time"
+)
+
+// Codec 将字段编码后写入列中，或者将列的值解码到字段中。用于结构体、map、切片等不能直接写入数据库的字段。
+//
+//	type User struct {
+//	    ID       int64
+//	    Settings map[string]string `db:"settings,json"`
+//	    Tags     []string          `db:",gob"`
+//	    Profile  *Profile          `db:",codec:msgpack"` // 使用 WithCodec("msgpack", ...) 注册的 Codec
+//	}
+//
+// 内置的 Codec 有 json 和 gob。字段为 nil 时写入 NULL，读取到 NULL 时按 Strategy 处理。
+type Codec interface {
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+
+	// Binary 返回编码结果是否为二进制数据。为 false 时以字符串写入数据库（如 MySQL 的 JSON 列不接受二进制字符串）。
+	Binary() bool
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Marshal(v interface{}) ([]byte, error)      { return json.Marshal(v) }
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error { return json.Unmarshal(data, v) }
+func (jsonCodec) Binary() bool                               { return false }
+
+type gobCodec struct{}
+
+func (gobCodec) Marshal(v interface{}) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	err := gob.NewEncoder(buf).Encode(v)
+	return buf.Bytes(), err
+}
+
+func (gobCodec) Unmarshal(data []byte, v interface{}) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
+
+func (gobCodec) Binary() bool { return true }
+
+var (
+	JSON Codec = jsonCodec{}
+	Gob  Codec = gobCodec{}
+
+	builtinCodecs = map[string]Codec{
+		"json": JSON,
+		"gob":  Gob,
+	}
+)
+
+// codec 返回名为 name 的 Codec，先查找 WithCodec 注册的，再查找内置的。
+func (db *Database) codec(name string) (Codec, error) {
+	if c, ok := db.codecs[name]; ok {
+		return c, nil
+	}
+	return builtinCodec(name)
+}
+
+func builtinCodec(name string) (Codec, error) {
+	if c, ok := builtinCodecs[name]; ok {
+		return c, nil
+	}
+	return nil, fmt.Errorf(f6, ErrCodecNotRegistered, name)
+}
+
+// encodeField 在字段指定了 Codec 时编码字段的值，指定了 unix 时将时间转换为 unix 时间戳，否则原样返回。
+func (db *Database) encodeField(fm *fieldMeta, value interface{}, zero bool) (interface{}, error) {
+	if fm.tag.unix {
+		switch t := value.(type) {
+		case time.Time:
+			return unixValue(t, db.unix), nil
+		case *time.Time:
+			return unixValue(*t, db.unix), nil
+		}
+	}
+	if len(fm.tag.codec) == 0 {
+		return value, nil
+	}
+	c, err := db.codec(fm.tag.codec)
+	if err != nil {
+		return nil, err
+	}
+	return encodeValue(c, value, zero, fm)
+}
+
+// encodeValue 使用 codec 编码字段的值。nil 的指针、map、切片和接口编码为 NULL。
+func encodeValue(c Codec, value interface{}, zero bool, fm *fieldMeta) (interface{}, error) {
+	if zero {
+		switch fm.typ.Kind() {
+		case reflect.Ptr, reflect.Map, reflect.Slice, reflect.Interface:
+			return nil, nil
+		}
+	}
+	data, err := c.Marshal(value)
+	if err != nil {
+		return nil, fmt.Errorf(f7, fm.column, err)
+	}
+	if c.Binary() {
+		return data, nil
+	}
+	return string(data), nil
+}
+
+// decodeValue 使用 codec 将列的值解码到 dptr 中。解码前会先将目标变量设为〇值，避免与原值合并。
+func decodeValue(c Codec, dptr, src interface{}, column string) error {
+	var data []byte
+	switch s := src.(type) {
+	case []byte:
+		data = s
+	case string:
+		data = []byte(s)
+	default:
+		return fmt.Errorf(f1, ErrUnsupportedConversion, src, reflect.TypeOf(dptr).Elem().String())
+	}
+	dv := reflect.ValueOf(dptr).Elem()
+	dv.Set(reflect.Zero(dv.Type()))
+	if err := c.Unmarshal(data, dptr); err != nil {
+		return fmt.Errorf(f7, column, err)
+	}
+	return nil
+}
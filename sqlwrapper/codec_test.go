@@ -0,0 +1,86 @@
+package sqlwrapper
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type upperCodec struct{}
+
+func (upperCodec) Marshal(v interface{}) ([]byte, error) {
+	return []byte(strings.ToUpper(strings.Join(v.([]string), ","))), nil
+}
+
+func (upperCodec) Unmarshal(data []byte, v interface{}) error {
+	*(v.(*[]string)) = strings.Split(strings.ToLower(string(data)), ",")
+	return nil
+}
+
+func (upperCodec) Binary() bool { return false }
+
+type codecUser struct {
+	ID       int64
+	Settings map[string]string `db:"settings,json"`
+	Tags     []string          `db:",codec:upper"`
+	Blob     []int             `db:",gob"`
+}
+
+func (codecUser) TableName() string { return "codec_user" }
+func (codecUser) PkColumn() string  { return "id" }
+
+func TestCodec(t *testing.T) {
+	db := testDatabase("mysql")
+	db.codecs = map[string]Codec{"upper": upperCodec{}}
+	sm, err := db.RegisterType(&codecUser{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	settings, tags, blob := sm.columnFieldMap["settings"], sm.columnFieldMap["tags"], sm.columnFieldMap["blob"]
+	if settings.tag.codec != "json" || tags.tag.codec != "upper" || blob.tag.codec != "gob" {
+		t.Fatalf("unexpected codecs %q %q %q", settings.tag.codec, tags.tag.codec, blob.tag.codec)
+	}
+
+	u := &codecUser{Settings: map[string]string{"theme": "dark"}, Tags: []string{"a", "b"}, Blob: []int{1, 2}}
+	m := mapperOf(u)
+	for _, c := range []struct {
+		fm   *fieldMeta
+		want interface{}
+	}{
+		{settings, `{"theme":"dark"}`},
+		{tags, "A,B"},
+	} {
+		value, zero := writeValue(m, c.fm.index)
+		got, err := db.encodeField(c.fm, value, zero)
+		if err != nil || got != c.want {
+			t.Errorf("encode %s: got %v (%v), want %v", c.fm.column, got, err, c.want)
+		}
+	}
+	encoded, err := db.encodeField(blob, u.Blob, false)
+	if _, ok := encoded.([]byte); !ok || err != nil {
+		t.Fatalf("gob should encode to []byte, got %T (%v)", encoded, err)
+	}
+	if got, _ := db.encodeField(settings, map[string]string(nil), true); got != nil {
+		t.Errorf("nil map should be written as NULL, got %v", got)
+	}
+	if _, err = (&Database{}).encodeField(tags, u.Tags, false); err == nil {
+		t.Error("expect error for unregistered codec")
+	}
+
+	var decoded codecUser
+	decoded.Settings = map[string]string{"old": "value"}
+	dm := mapperOf(&decoded)
+	if err = decodeValue(JSON, dm.FieldPtr(settings.index), []byte(`{"theme":"dark"}`), "settings"); err != nil {
+		t.Fatal(err)
+	}
+	if err = decodeValue(Gob, dm.FieldPtr(blob.index), encoded, "blob"); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(decoded.Settings, u.Settings) || !reflect.DeepEqual(decoded.Blob, u.Blob) {
+		t.Errorf("got %+v, want %+v", decoded, u)
+	}
+
+	if got := columnType("pgx", settings); got != "jsonb" {
+		t.Errorf("json column type: got %s", got)
+	}
+}
@@ -5,17 +5,55 @@ import (
 	"fmt"
 	"reflect"
 	"strconv"
+	"strings"
 	"time"
 )
 
+// Strategy 是数据库中的值为 NULL 时对目标变量的处理方式。可以用 WithStrategyOnNull 设置默认值，
+// 用字段 tag 中的 onnull 选项单独设置某个字段。
 type Strategy uint8
 
 const (
+	// DoNothing 保留目标变量的原值。
 	DoNothing Strategy = iota
+	// SetZero 将目标变量设为〇值。
 	SetZero
+	// Continue 交给 ValueConverter 处理（内置的 Vcie 会返回错误）。
 	Continue
+	// Error 在目标变量不能为 NULL（不是指针、切片、map、interface{}）时返回 ErrUnexpectedNull，
+	// 错误信息中包含表名和列名；能为 NULL 时设为 nil。
+	Error
+	// SetNil 将指针、切片、map 和 interface{} 设为 nil，其他类型保留原值。
+	// 非 NULL 时指针字段总是指向新分配的值，不会修改原指针指向的值。
+	SetNil
 )
 
+// parseStrategy 解析 tag 中的 Strategy 名称，不区分大小写。
+func parseStrategy(name string) (Strategy, bool) {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "donothing":
+		return DoNothing, true
+	case "setzero":
+		return SetZero, true
+	case "continue":
+		return Continue, true
+	case "error":
+		return Error, true
+	case "setnil":
+		return SetNil, true
+	}
+	return DoNothing, false
+}
+
+// nillable 判断 reflect.Kind 为 k 的变量能否为 nil。
+func nillable(k reflect.Kind) bool {
+	switch k {
+	case reflect.Ptr, reflect.Slice, reflect.Map, reflect.Interface:
+		return true
+	}
+	return false
+}
+
 type ValueConverter interface {
 	TimeFormat() string
 	ConvertString(dptr interface{}, src string) error
@@ -30,11 +68,38 @@ const (
 	DefaultTimeFormat = "2006-01-02 15:04:05"
 )
 
-type vcie struct{}
+// vcie 是内置的 ValueConverter。〇值使用 DefaultTimeLayouts、time.Local 和以秒为单位的 unix 时间戳，
+// NewDatabase 根据 WithLocation、WithTimeLayouts 和 WithUnixTime 设置这些字段。
+type vcie struct {
+	// loc 是数据库中没有时区的时间所在的时区，nil 代表 time.Local
+	loc     *time.Location
+	layouts []string
+	// unix 是整数列转换为时间时 unix 时间戳的单位，0 代表秒
+	unix time.Duration
+}
 
 var Vcie = vcie{}
 
-func (vcie) TimeFormat() string { return DefaultTimeFormat }
+// TimeFormat 返回将时间格式化为字符串时使用的格式，即第一个可接受的格式。
+func (c vcie) TimeFormat() string { return c.timeLayouts()[0] }
+
+func (c vcie) timeLayouts() []string {
+	if len(c.layouts) == 0 {
+		return DefaultTimeLayouts
+	}
+	return c.layouts
+}
+
+func (c vcie) location() *time.Location {
+	if c.loc == nil {
+		return time.Local
+	}
+	return c.loc
+}
+
+func (c vcie) parseTime(src string) (time.Time, error) {
+	return parseTime(src, c.timeLayouts(), c.location())
+}
 
 func (c vcie) ConvertString(dptr interface{}, src string) error {
 	switch d := dptr.(type) {
@@ -69,8 +134,14 @@ func (c vcie) ConvertString(dptr interface{}, src string) error {
 		}
 		d.Valid = true
 		d.Float64 = f64
+	case *time.Time:
+		t, err := c.parseTime(src)
+		if err != nil {
+			return fmt.Errorf(f2, src, src, reflect.TypeOf(dptr).Elem().String(), err)
+		}
+		*d = t
 	case *sql.NullTime:
-		t, err := time.ParseInLocation(c.TimeFormat(), src, time.Local)
+		t, err := c.parseTime(src)
 		if err != nil {
 			return fmt.Errorf(f2, src, src, reflect.TypeOf(dptr).Elem().String(), err)
 		}
@@ -93,9 +164,9 @@ reflectConversion:
 
 	switch dtype.Kind() {
 	case reflect.Ptr:
-		d := dv.Interface()
-		if d != nil {
-			return c.ConvertString(d, src)
+		// dv.Interface() of a nil pointer is a non-nil interface
+		if !dv.IsNil() {
+			return c.ConvertString(dv.Interface(), src)
 		}
 		ev := reflect.New(dtype.Elem())
 		err := c.ConvertString(ev.Interface(), src)
@@ -134,7 +205,7 @@ reflectConversion:
 	}
 	return nil
 unsupported:
-	return fmt.Errorf(f1, src, dtype.String())
+	return fmt.Errorf(f1, ErrUnsupportedConversion, src, dtype.String())
 }
 
 func (c vcie) ConvertInt64(dptr interface{}, src int64) error {
@@ -168,6 +239,12 @@ func (c vcie) ConvertInt64(dptr interface{}, src int64) error {
 	case *sql.NullString:
 		d.Valid = true
 		d.String = strconv.FormatInt(src, 10)
+	case *time.Time:
+		// unix timestamp
+		*d = unixTime(src, c.unix, c.location())
+	case *sql.NullTime:
+		d.Valid = true
+		d.Time = unixTime(src, c.unix, c.location())
 	default:
 		goto reflectConversion
 	}
@@ -179,9 +256,9 @@ reflectConversion:
 
 	switch dtype.Kind() {
 	case reflect.Ptr:
-		d := dv.Interface()
-		if d != nil {
-			return c.ConvertInt64(d, src)
+		// dv.Interface() of a nil pointer is a non-nil interface
+		if !dv.IsNil() {
+			return c.ConvertInt64(dv.Interface(), src)
 		}
 		ev := reflect.New(dtype.Elem())
 		err := c.ConvertInt64(ev.Interface(), src)
@@ -196,13 +273,13 @@ reflectConversion:
 	case reflect.Int8, reflect.Int16, reflect.Int32:
 		bound := int64(1<<dtype.Bits() - 1)
 		if src|bound != bound {
-			return fmt.Errorf(f2, src, src, dtype.String(), "value out of range")
+			return fmt.Errorf(f2, src, src, dtype.String(), ErrOutOfRange)
 		}
 		dv.SetInt(src)
 	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32:
 		bound := int64(1<<dtype.Bits() - 1)
 		if src|bound != bound {
-			return fmt.Errorf(f2, src, src, dtype.String(), "value out of range")
+			return fmt.Errorf(f2, src, src, dtype.String(), ErrOutOfRange)
 		}
 		dv.SetUint(uint64(src))
 	case reflect.Float32, reflect.Float64:
@@ -214,13 +291,13 @@ reflectConversion:
 			dv.SetBool(src == 1)
 			return nil
 		}
-		return fmt.Errorf(f2, src, src, dtype.String(), "only 0 and 1 could convert to bool")
+		return fmt.Errorf(f2, src, src, dtype.String(), ErrNotBool)
 	default:
 		goto unsupported
 	}
 	return nil
 unsupported:
-	return fmt.Errorf(f1, src, dtype.String())
+	return fmt.Errorf(f1, ErrUnsupportedConversion, src, dtype.String())
 }
 
 func (c vcie) ConvertTime(dptr interface{}, src time.Time, format string) error {
@@ -251,9 +328,9 @@ reflectConversion:
 
 	switch dtype.Kind() {
 	case reflect.Ptr:
-		d := dv.Interface()
-		if d != nil {
-			return c.ConvertTime(d, src, format)
+		// dv.Interface() of a nil pointer is a non-nil interface
+		if !dv.IsNil() {
+			return c.ConvertTime(dv.Interface(), src, format)
 		}
 		ev := reflect.New(dtype.Elem())
 		err := c.ConvertTime(ev.Interface(), src, format)
@@ -268,7 +345,7 @@ reflectConversion:
 	}
 	return nil
 unsupported:
-	return fmt.Errorf(f1, src, dtype.String())
+	return fmt.Errorf(f1, ErrUnsupportedConversion, src, dtype.String())
 }
 
 func (c vcie) ConvertBool(dptr interface{}, src bool) error {
@@ -299,9 +376,9 @@ reflectConversion:
 
 	switch dtype.Kind() {
 	case reflect.Ptr:
-		d := dv.Interface()
-		if d != nil {
-			return c.ConvertBool(d, src)
+		// dv.Interface() of a nil pointer is a non-nil interface
+		if !dv.IsNil() {
+			return c.ConvertBool(dv.Interface(), src)
 		}
 		ev := reflect.New(dtype.Elem())
 		err := c.ConvertBool(ev.Interface(), src)
@@ -318,7 +395,7 @@ reflectConversion:
 	}
 	return nil
 unsupported:
-	return fmt.Errorf(f1, src, dtype.String())
+	return fmt.Errorf(f1, ErrUnsupportedConversion, src, dtype.String())
 }
 
 func (c vcie) ConvertBytes(dptr interface{}, src []byte) error {
@@ -344,9 +421,9 @@ reflectConversion:
 
 	switch dtype.Kind() {
 	case reflect.Ptr:
-		d := dv.Interface()
-		if d != nil {
-			return c.ConvertBytes(d, src)
+		// dv.Interface() of a nil pointer is a non-nil interface
+		if !dv.IsNil() {
+			return c.ConvertBytes(dv.Interface(), src)
 		}
 		ev := reflect.New(dtype.Elem())
 		err := c.ConvertBytes(ev.Interface(), src)
@@ -365,7 +442,7 @@ stringConversion:
 		return nil
 	}
 	// unsupported
-	return fmt.Errorf(f1, src, dtype.String())
+	return fmt.Errorf(f1, ErrUnsupportedConversion, src, dtype.String())
 }
 
 func (c vcie) ConvertFloat64(dptr interface{}, src float64) error {
@@ -410,9 +487,9 @@ reflectConversion:
 
 	switch dtype.Kind() {
 	case reflect.Ptr:
-		d := dv.Interface()
-		if d != nil {
-			return c.ConvertFloat64(d, src)
+		// dv.Interface() of a nil pointer is a non-nil interface
+		if !dv.IsNil() {
+			return c.ConvertFloat64(dv.Interface(), src)
 		}
 		ev := reflect.New(dtype.Elem())
 		err := c.ConvertFloat64(ev.Interface(), src)
@@ -443,7 +520,7 @@ reflectConversion:
 	}
 	return nil
 unsupported:
-	return fmt.Errorf(f1, src, dtype.String())
+	return fmt.Errorf(f1, ErrUnsupportedConversion, src, dtype.String())
 }
 
 func convertValue(
@@ -454,6 +531,24 @@ func convertValue(
 	if dptr == nil {
 		return ErrNilPointer
 	}
+	if w, ok := dptr.(nullWrapper); ok {
+		if src == nil {
+			w.setNull()
+			return nil
+		}
+		err := convertValue(w.valuePtr(), src, converter, onNull)
+		w.setValid(err == nil)
+		return err
+	}
+	if ok, err := scanWithScanner(dptr, src); ok {
+		return err
+	}
+	if onNull == SetNil && src != nil {
+		// allocate a new value instead of modifying the old one
+		if dv := reflect.ValueOf(dptr).Elem(); dv.Kind() == reflect.Ptr {
+			dv.Set(reflect.Zero(dv.Type()))
+		}
+	}
 
 	switch s := src.(type) {
 	case nil:
@@ -464,6 +559,16 @@ func convertValue(
 			dv := reflect.ValueOf(dptr).Elem()
 			dv.Set(reflect.Zero(dv.Type()))
 			return nil
+		case Error, SetNil:
+			dv := reflect.ValueOf(dptr).Elem()
+			if nillable(dv.Kind()) {
+				dv.Set(reflect.Zero(dv.Type()))
+				return nil
+			}
+			if onNull == Error {
+				return ErrUnexpectedNull
+			}
+			return nil
 		default:
 		}
 	case int64:
@@ -479,7 +584,37 @@ func convertValue(
 	case bool:
 		return converter.ConvertBool(dptr, s)
 	}
-	return fmt.Errorf(f3, src)
+	return fmt.Errorf(f3, ErrUnsupportedSource, src)
+}
+
+// scanWithScanner 在 dptr 实现了 sql.Scanner 时调用 Scan（包括 NULL 值），返回是否已处理。
+// dptr 是指向 sql.Scanner 指针的指针时，非 NULL 值会在需要时分配新的值再调用 Scan，NULL 值仍按 Strategy 处理。
+//
+// sql.Null* 类型仍然使用 ValueConverter，以便按 TimeFormat 等规则转换。
+func scanWithScanner(dptr, src interface{}) (bool, error) {
+	if s, ok := dptr.(sql.Scanner); ok {
+		if _, isNull := nullTypes[reflect.TypeOf(dptr).Elem()]; isNull {
+			return false, nil
+		}
+		return true, s.Scan(src)
+	}
+	t := reflect.TypeOf(dptr).Elem()
+	if src == nil || t.Kind() != reflect.Ptr || !t.Implements(scannerType) {
+		return false, nil
+	}
+	if _, isNull := nullTypes[t.Elem()]; isNull {
+		return false, nil
+	}
+	v := reflect.ValueOf(dptr).Elem()
+	p := v
+	if v.IsNil() {
+		p = reflect.New(t.Elem())
+	}
+	if err := p.Interface().(sql.Scanner).Scan(src); err != nil {
+		return true, err
+	}
+	v.Set(p)
+	return true, nil
 }
 
 func cloneBytes(b []byte) []byte {
@@ -0,0 +1,135 @@
+package sqlwrapper
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// upper 的 Scan 和 Value 方法的接收者都是指针。
+type upper string
+
+func (u *upper) Scan(src interface{}) error {
+	switch s := src.(type) {
+	case nil:
+		*u = "NULL"
+	case string:
+		*u = upper(strings.ToUpper(s))
+	case []byte:
+		*u = upper(strings.ToUpper(string(s)))
+	default:
+		return fmt.Errorf("cannot scan %T into upper", src)
+	}
+	return nil
+}
+
+func (u *upper) Value() (driver.Value, error) { return strings.ToLower(string(*u)), nil }
+
+func TestConvertScanner(t *testing.T) {
+	var u upper
+	if err := convertValue(&u, []byte("abc"), Vcie, DoNothing); err != nil || u != "ABC" {
+		t.Errorf("got %q, %v", u, err)
+	}
+	if err := convertValue(&u, nil, Vcie, DoNothing); err != nil || u != "NULL" {
+		t.Errorf("Scan should receive NULL, got %q, %v", u, err)
+	}
+	if err := convertValue(&u, int64(1), Vcie, DoNothing); err == nil {
+		t.Error("expect error from Scan")
+	}
+
+	var p *upper
+	if err := convertValue(&p, nil, Vcie, DoNothing); err != nil || p != nil {
+		t.Errorf("NULL into nil pointer should follow the strategy, got %v, %v", p, err)
+	}
+	if err := convertValue(&p, "def", Vcie, DoNothing); err != nil || p == nil || *p != "DEF" {
+		t.Errorf("got %v, %v", p, err)
+	}
+
+	// sql.Null* still use the ValueConverter, so that times are parsed with TimeFormat
+	var nt sql.NullTime
+	if err := convertValue(&nt, "2022-05-01 08:00:00", Vcie, DoNothing); err != nil || !nt.Valid {
+		t.Errorf("got %v, %v", nt, err)
+	}
+}
+
+func TestWriteValue(t *testing.T) {
+	type entity struct {
+		Name upper
+		Note sql.NullString
+		Age  int
+	}
+	e := &entity{Name: "ABC", Note: sql.NullString{String: "x", Valid: true}, Age: 3}
+	m := mapperOf(e)
+	if v, _ := writeValue(m, 0); v != &e.Name {
+		t.Errorf("expect pointer Valuer, got %T", v)
+	}
+	if v, _ := writeValue(m, 1); v != e.Note {
+		t.Errorf("expect value Valuer, got %T", v)
+	}
+	if v, _ := writeValue(m, 2); v != 3 {
+		t.Errorf("expect plain value, got %T", v)
+	}
+}
+
+func TestStrategies(t *testing.T) {
+	s, p := "old", new(int)
+	*p = 1
+	old := p
+	if err := convertValue(&s, nil, Vcie, SetNil); err != nil || s != "old" {
+		t.Errorf("SetNil should keep non-nillable values, got %q, %v", s, err)
+	}
+	if err := convertValue(&p, int64(2), Vcie, SetNil); err != nil || p == old || *p != 2 || *old != 1 {
+		t.Errorf("SetNil should allocate a new value, got %v, %v", p, err)
+	}
+	if err := convertValue(&p, nil, Vcie, Error); err != nil || p != nil {
+		t.Errorf("Error should set nillable values to nil, got %v, %v", p, err)
+	}
+	if err := convertValue(&s, nil, Vcie, Error); !errors.Is(err, ErrUnexpectedNull) {
+		t.Errorf("expect ErrUnexpectedNull, got %v", err)
+	}
+
+	_, opts, err := parseTag(`remark,onnull:SetZero`)
+	if err != nil || !opts.hasOnNull || opts.onNull != SetZero {
+		t.Errorf("unexpected tag options %+v", opts)
+	}
+}
+
+type nullUser struct {
+	ID     int64
+	Remark string `db:",onnull:error"`
+}
+
+func (nullUser) TableName() string { return "user" }
+func (nullUser) PkColumn() string  { return "id" }
+
+func TestUnexpectedNull(t *testing.T) {
+	var users []nullUser
+	err := openFakeDatabase(t, "").QueryMultiple(&users)
+	if !errors.Is(err, ErrUnexpectedNull) || !strings.Contains(err.Error(), "table 'user' column 'remark'") {
+		t.Errorf("unexpected error %v", err)
+	}
+}
+
+func TestNull(t *testing.T) {
+	var n Null[int32]
+	if err := convertValue(&n, "12", Vcie, DoNothing); err != nil || !n.Valid || n.V != 12 {
+		t.Errorf("got %+v, %v", n, err)
+	}
+	if err := n.Scan(nil); err != nil || n.Valid || n.V != 0 {
+		t.Errorf("got %+v, %v", n, err)
+	}
+	if v, err := NewNull(int32(3)).Value(); err != nil || v != int64(3) {
+		t.Errorf("got %v (%T), %v", v, v, err)
+	}
+	if v, _ := n.Value(); v != nil {
+		t.Errorf("invalid Null should be written as NULL, got %v", v)
+	}
+	if vt, nullable := valueType(reflect.TypeOf(Null[time.Time]{})); vt != timeType || !nullable {
+		t.Errorf("unexpected value type %v", vt)
+	}
+}
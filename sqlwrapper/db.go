@@ -3,21 +3,37 @@ package sqlwrapper
 import (
 	"context"
 	"database/sql"
-	"fmt"
 	"reflect"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 type Database struct {
 	driver  string
 	dialect Dialect
 	origin  *sql.DB
+	// replicas 是只读从库，没有配置时为 nil，见 WithReplicas
+	replicas *replicaSet
 
 	onNull Strategy
 	vc     ValueConverter
+	codecs map[string]Codec
+
+	classifier ErrorClassifier
+	retry      RetryPolicy
+
+	// loc, precision 和 unix 用于写入时间，见 WithLocation、WithTimePrecision 和 WithUnixTime
+	loc       *time.Location
+	precision time.Duration
+	unix      time.Duration
 
 	ctxpool sync.Pool
 
+	stats counters
+	// unhealthy 为 true 时主库最近一次健康检查失败，见 WithHealthCheck
+	unhealthy atomic.Bool
+
 	// ctx is the base of all operations
 	ctx    context.Context
 	cancel context.CancelFunc
@@ -25,33 +41,74 @@ type Database struct {
 
 func NewDatabase(driver, dsn string, options ...OptionDB) (*Database, error) {
 	o := &optionDB{
-		ping:   true,
-		onNull: DoNothing,
-		vc:     Vcie,
+		ping:         true,
+		onNull:       DoNothing,
+		vc:           Vcie,
+		replicaCheck: DefaultReplicaHealthCheck,
 	}
 	for _, opt := range options {
 		opt(o)
 	}
+	if c, ok := o.vc.(vcie); ok {
+		// time options only apply to the builtin converter
+		c.loc, c.layouts, c.unix = o.loc, o.layouts, o.unix
+		o.vc = c
+	}
 
-	db, err := sql.Open(driver, dsn)
-	if err != nil {
+	var (
+		db  *sql.DB
+		err error
+	)
+	if o.connector != nil {
+		db = sql.OpenDB(o.connector)
+	} else if db, err = sql.Open(driver, dsn); err != nil {
 		return nil, err
 	}
 
+	for _, f := range o.pool {
+		f(db)
+	}
+
 	if o.ping {
-		if err = db.Ping(); err != nil {
+		if err = pingRetry(db, &o.pingRetry, o.pingTimeout); err != nil {
 			db.Close()
 			return nil, err
 		}
 	}
-	dialect := GetDialect(driver)
+	var replicas *replicaSet
+	if len(o.replicas) > 0 {
+		replicas, err = openReplicas(driver, o)
+		if err != nil {
+			db.Close()
+			return nil, err
+		}
+	}
+	dialect := o.dialect
+	if dialect == nil {
+		dialect = GetDialect(driver)
+	}
+	classifier := o.classifier
+	if classifier == nil {
+		classifier = GetErrorClassifier(driver)
+	}
 	ctx, cancel := context.WithCancel(context.Background())
 	d := &Database{
 		driver:  driver,
 		dialect: dialect,
 		origin:  db,
-		onNull:  o.onNull,
-		vc:      o.vc,
+
+		replicas: replicas,
+
+		onNull: o.onNull,
+		vc:     o.vc,
+		codecs: o.codecs,
+
+		classifier: classifier,
+		retry:      o.retry,
+
+		loc:       o.loc,
+		precision: o.precision,
+		unix:      o.unix,
 		ctxpool: sync.Pool{
 			New: func() interface{} {
 				return NewContext(driver, dialect)
@@ -60,11 +117,18 @@ func NewDatabase(driver, dsn string, options ...OptionDB) (*Database, error) {
 		ctx:    ctx,
 		cancel: cancel,
 	}
+	if replicas != nil && o.replicaCheck > 0 {
+		go replicas.watch(ctx, o.replicaCheck)
+	}
+	if o.healthCheck > 0 {
+		go d.watchHealth(o.healthCheck, o.onHealthChange)
+	}
 	return d, nil
 }
 
 func (db *Database) Close() (err error) {
 	db.cancel()
+	db.replicas.close()
 	err = db.origin.Close()
 	return
 }
@@ -73,6 +137,8 @@ func (db *Database) Origin() *sql.DB { return db.origin }
 
 func (db *Database) Driver() string { return db.driver }
 
+func (db *Database) Dialect() Dialect { return db.dialect }
+
 func (db *Database) newContext() *SqlCtx {
 	if ctx, ok := db.ctxpool.Get().(*SqlCtx); ok {
 		ctx.Reset()
@@ -86,219 +152,19 @@ func (db *Database) recycleContext(ctx *SqlCtx) {
 	db.ctxpool.Put(ctx)
 }
 
-func (db *Database) Insert(e IEntity, options ...OptionExec) (err error) {
-	sm, err := db.RegisterType(e)
-	if err != nil {
-		return
-	}
-
-	o := &optExec{
-		columns: sm.columns,
-	}
-	for _, opt := range options {
-		opt.applyToOptionExec(o)
-	}
-
-	ctx := db.newContext()
-	defer db.recycleContext(ctx)
-	nColumns := len(o.columns)
-	args := make([]interface{}, 0, nColumns)
-	v := reflect.ValueOf(e)
-	if v.Kind() == reflect.Ptr {
-		v = v.Elem()
-	}
-
-	// 构建 sql 语句
-	ctx.WriteString("insert into ").
-		WriteQuotedString(e.TableName()).
-		WriteQuotedString(" (")
-	for _, column := range o.columns {
-		fm, ok := sm.columnFieldMap[column]
-		if !ok {
-			// 没找到该列，说明调用时传入的 WithColumns 中列名可能写错了。
-			return fmt.Errorf(f5, column)
-		}
-		field := v.Field(fm.index)
-		if field.IsZero() && !o.includingZeros {
-			// 没有指定 includingZeros 时跳过默认〇值的字段
-			continue
-		}
-		if len(args) > 0 {
-			ctx.WriteString(", ")
-		}
-		ctx.WriteQuotedString(column)
-		args = append(args, field.Interface())
-	}
-
-	ctx.WriteString(") values (")
-	for i, a := range args {
-		if i > 0 {
-			ctx.WriteString(", ")
-		}
-		ctx.NextPlaceholder(a)
-	}
-	ctx.WriteByte(')')
-
-	// 传入的是个结构体而非指针，返回了 id 也无法赋值。
-	// 直接执行后结束。
-	if reflect.TypeOf(e).Kind() != reflect.Ptr {
-		_, err = db.RawExec(ctx.QueryString(), ctx.args...)
-		return
-	}
-
-	pkIndex := sm.pkStructIndex
-	if pkIndex == -1 || !v.Field(pkIndex).IsZero() {
-		// 没有主键字段，或者有主键字段但不为〇值，则不进行 ID 赋值，执行后直接返回。
-		_, err = db.RawExec(ctx.QueryString(), ctx.args...)
-		return
-	}
-
-	var newId interface{}
-	// 使用 interface{} 类型的原因有二。
-	// 1. 使用 query returning 方式返回时 Scan 赋值不会报错；
-	// 2. 需要考虑主键是字符串的情况。
-	switch db.driver {
-	case "pgx", "postgres":
-		ctx.WriteString(" returning ").WriteQuotedString(e.PkColumn())
-		err = db.RawQuery(ctx.QueryString(), SingleRowScanner(&newId), ctx.args...)
-		if err != nil {
-			return
-		}
-		// t := reflect.TypeOf(newId)
-		// log.LogInfo(t.Kind(), t.String())
-
-		// 不能确定 returning 的 newId 一定是 int 类型（有可能是 string），需要判断。
-		// 除了 string/uuid -> int 不行以外其他都可以转。
-		if !reflect.TypeOf(newId).ConvertibleTo(sm.pkType) {
-			return
-		}
-	case "mssql", "sqlserver":
-		if sm.pkType.Kind() == reflect.String {
-			// sqlserver 没有办法返回字符串类型主键，直接执行后返回。
-			_, err = db.RawExec(ctx.QueryString(), ctx.args...)
-			return
-		}
-		ctx.WriteString("; select last_id = convert(bigint, SCOPE_IDENTITY())")
-		err = db.RawQuery(ctx.QueryString(), SingleRowScanner(&newId), ctx.args...)
-		if err != nil {
-			return
-		}
-	default:
-		var result sql.Result
-		result, err = db.RawExec(ctx.QueryString(), ctx.args...)
-		if err != nil {
-			return
-		}
-		var err1 error
-		// 获取新记录的 ID。
-		newId, err1 = result.LastInsertId()
-		if err1 != nil {
-			// 不支持 LastInsertId 方法，直接返回。
-			// TODO: write a warning log
-			return
-		}
-	}
-
-	pkField := v.Field(pkIndex)
-	pkField.Set(reflect.ValueOf(newId).Convert(sm.pkType))
-	return
+// Insert 将 e 插入数据库。e 是指针且主键为〇值时，将新记录的 ID 赋值给主键字段。
+func (db *Database) Insert(e IEntity, options ...OptionExec) error {
+	return insert(db, db, e, options)
 }
 
+// Query 查询一条记录赋值给 entity，没有找到记录时 found 为 false。在事务外不能使用 ForUpdate 和 ForShare 锁定记录。
 func (db *Database) Query(entity interface{}, options ...OptionQuerySingle) (found bool, err error) {
-	if reflect.TypeOf(entity).Kind() != reflect.Ptr {
-		err = ErrNotPointer
-		return
-	}
-	sm, err := db.RegisterType(entity)
-	if err != nil {
-		return
-	}
-
-	table := ""
-	if e, ok := entity.(IEntity); ok {
-		table = e.TableName()
-	}
-
-	q := &optQuerySingle{
-		optQuery: optQuery{
-			selectColumns: sm.columns,
-			table: optTable{
-				table: optSingleTable{table},
-			},
-			limit: 1,
-		},
-	}
-	for _, opt := range options {
-		opt.applyToOptionQuerySingle(q)
-	}
-
-	ctx := db.newContext()
-	defer db.recycleContext(ctx)
-	err = q.optQuery.AppendToSqlCtx(ctx)
-	if err != nil {
-		return
-	}
-	// fmt.Println(ctx.QueryString(), ctx.args)
-	err = db.RawQuery(ctx.QueryString(),
-		StructScanner(entity, sm, db.vc, db.onNull, q.unused, &found),
-		ctx.args...)
-	return
+	return query(db, db, entity, options)
 }
 
-func (db *Database) QueryMultiple(es interface{}, options ...OptionQueryMultiple) (err error) {
-	t := reflect.TypeOf(es)
-	if t.Kind() != reflect.Ptr {
-		return ErrNotPointer
-	}
-	t = t.Elem()
-	if t.Kind() != reflect.Slice {
-		return ErrElemNotSlice
-	}
-	t = t.Elem()
-
-	isPointer := t.Kind() == reflect.Ptr
-	if isPointer {
-		t = t.Elem()
-	}
-
-	if t.Kind() != reflect.Struct {
-		return ErrElemNotStruct
-	}
-
-	entity := reflect.New(t).Interface()
-	sm, err := db.RegisterType(entity)
-	if err != nil {
-		return
-	}
-
-	table := ""
-	if e, ok := entity.(IEntity); ok {
-		table = e.TableName()
-	}
-
-	q := &optQueryMultiple{
-		optQuery: optQuery{
-			selectColumns: sm.columns,
-			table: optTable{
-				table: optSingleTable{table},
-			},
-		},
-	}
-	for _, opt := range options {
-		opt.applyToOptionQueryMultiple(q)
-	}
-
-	ctx := db.newContext()
-	defer db.recycleContext(ctx)
-	err = q.optQuery.AppendToSqlCtx(ctx)
-	if err != nil {
-		return
-	}
-	// fmt.Println(ctx.QueryString(), ctx.args)
-	err = db.RawQuery(ctx.QueryString(),
-		SliceScanner(es, sm, db.vc, db.onNull, t, isPointer),
-		ctx.args...)
-	return
+// QueryMultiple 查询多条记录赋值给 es，es 是结构体或结构体指针切片的指针。在事务外不能使用 ForUpdate 和 ForShare 锁定记录。
+func (db *Database) QueryMultiple(es interface{}, options ...OptionQueryMultiple) error {
+	return queryMultiple(db, db, es, options)
 }
 
 func (db *Database) UpdateMap(ctx context.Context, updates map[string]interface{}) error {
@@ -311,115 +177,106 @@ func (db *Database) BatchUpdate(ctx context.Context, es []interface{}) error {
 	return nil
 }
 
-// Update 更新 e 对应的数据库中的记录。e 的主键字段必须非空。
+// Update 更新 e 对应的数据库中的记录。e 的主键字段必须非空。没有需要更新的列（例如全部是被跳过的〇值字段）时不执行语句。
 //
 // 只想保存，不想管是插入还是更新的话，可以使用通用方法 Save。
-func (db *Database) Update(e IEntity, options ...OptionExec) (err error) {
-	sm, err := db.RegisterType(e)
-	if err != nil {
-		return
-	}
+func (db *Database) Update(e IEntity, options ...OptionExec) error {
+	return update(db, db, e, options)
+}
 
-	v := reflect.ValueOf(e)
-	if v.Type().Kind() == reflect.Ptr {
-		v = v.Elem()
-	}
+// Save 将 e 保存到数据库中。当 e 主键字段为空时插入，非空时更新。
+func (db *Database) Save(e IEntity, options ...OptionExec) error {
+	return save(db, db, e, options)
+}
 
-	pkField := v.Field(sm.pkStructIndex)
-	if pkField.IsZero() {
-		err = fmt.Errorf(f4, e.PkColumn())
-		return
-	}
+// InsertSelect 将查询结果插入 table 的 columns 列中（insert into ... select ...）。
+// columns 为空时不指定列名。
+//
+// options 与 SubQuery 相同，需要使用 Select 和 From 指定查询的列和表。
+//
+//	db.InsertSelect("emp_archive", []string{"id", "fullname"},
+//	  Select("id", "fullname"),
+//	  From(Table("emp")),
+//	  Where("age > ?", 60),
+//	)
+func (db *Database) InsertSelect(table string, columns []string, options ...OptionQueryMultiple) error {
+	return insertSelect(db, db, table, columns, options)
+}
 
-	o := &optExec{columns: sm.columns}
-	for _, opt := range options {
-		opt.applyToOptionExec(o)
-	}
+// Delete 删除 table 中满足条件的记录。与 DeleteWhere 相同。
+func (db *Database) Delete(table string, options ...OptionDelete) error {
+	return db.DeleteWhere(table, options...)
+}
 
-	nColumns := len(o.columns)
-	if nColumns == 0 {
-		// do nothing and return
-		return
-	}
-	ctx := db.newContext()
-	defer db.recycleContext(ctx)
+// UpdateWhere 更新 table 中满足条件的记录，可选参数有 Set、From、Where、Limit 和 Returning。
+//
+//	db.UpdateWhere("article",
+//	  Set("views = views + ?", 1),
+//	  Set("updated_at = ?", time.Now()),
+//	  Where("id = ?", 1),
+//	)
+func (db *Database) UpdateWhere(table string, options ...OptionUpdate) error {
+	return updateWhere(db, db, table, options)
+}
 
-	ctx.WriteString("update ").
-		WriteQuotedString(e.TableName()).
-		WriteString(" set ")
+// DeleteWhere 删除 table 中满足条件的记录，可选参数有 From、Where、Limit 和 Returning。
+//
+//	db.DeleteWhere("emp",
+//	  From(Table("dept")),
+//	  Where("emp.dept_id = dept.id and dept.closed = ?", true),
+//	)
+func (db *Database) DeleteWhere(table string, options ...OptionDelete) error {
+	return deleteWhere(db, db, table, options)
+}
 
-	for _, column := range o.columns {
-		if column == e.PkColumn() {
-			// 主键字段放 where 子句里面，set 里面不用填
-			continue
-		}
-		fm, ok := sm.columnFieldMap[column]
-		if !ok {
-			// 没找到该列，说明调用时传入的 WithColumns 中列名可能写错了。
-			return fmt.Errorf(f5, column)
-		}
-		field := v.Field(fm.index)
-		if field.IsZero() && !o.includingZeros {
-			// 没有指定 includingZeros 时跳过默认〇值的字段
-			continue
-		}
-		if len(ctx.args) > 0 {
-			ctx.WriteString(", ")
+// returningScanner 根据 Returning 的 dest 返回对应的 RowsScanner，并在未指定列时补全 columns。
+// 没有使用 Returning 时返回 nil。
+func (db *Database) returningScanner(r *optReturning) (RowsScanner, error) {
+	if r.dest == nil {
+		return nil, nil
+	}
+	if s, ok := r.dest.(RowsScanner); ok {
+		if len(r.columns) == 0 {
+			r.columns = []string{"*"}
 		}
-		ctx.WriteQuotedString(column).
-			WriteString(" = ").
-			NextPlaceholder(field.Interface())
+		return s, nil
 	}
-
-	ctx.WriteString(" where ").
-		WriteQuotedString(e.PkColumn()).
-		WriteString(" = ").
-		NextPlaceholder(pkField.Interface())
-
-	_, err = db.RawExec(ctx.QueryString(), ctx.args...)
-	return
-}
-
-// Save 将 e 保存到数据库中。当 e 主键字段为空时插入，非空时更新。
-func (db *Database) Save(e IEntity, options ...OptionExec) error {
-	sm, err := db.RegisterType(e)
-	if err != nil {
-		return err
+	t := reflect.TypeOf(r.dest)
+	if t.Kind() != reflect.Ptr {
+		return nil, ErrNotPointer
 	}
-	v := reflect.ValueOf(e)
-	if v.Type().Kind() == reflect.Ptr {
-		v = v.Elem()
+	t = t.Elem()
+	if t.Kind() != reflect.Slice {
+		return nil, ErrElemNotSlice
 	}
-
-	pkField := v.Field(sm.pkStructIndex)
-	if pkField.IsZero() {
-		return db.Insert(e, options...)
+	t = t.Elem()
+	isPointer := t.Kind() == reflect.Ptr
+	if isPointer {
+		t = t.Elem()
 	}
-	return db.Update(e, options...)
-}
-
-func (db *Database) Delete(table string, options ...OptionDelete) (err error) {
-	d := &optDelete{}
-	for _, opt := range options {
-		opt.applyToOptionDelete(d)
+	if t.Kind() != reflect.Struct {
+		return nil, ErrElemNotStruct
 	}
-	ctx := db.newContext()
-	defer db.recycleContext(ctx)
-
-	ctx.WriteString("delete from ").
-		WriteQuotedString(table)
-	err = ctx.where(d.whereClause, d.whereArgs...)
+	sm, err := db.RegisterType(reflect.New(t).Interface())
 	if err != nil {
-		return
+		return nil, err
 	}
-
-	_, err = db.RawExec(ctx.QueryString(), ctx.args...)
-	return
+	if len(r.columns) == 0 {
+		r.columns = sm.columns
+	}
+	return sliceScanner(r.dest, sm, db.scanOptions(), t), nil
 }
 
 // RawExec 封装了 (*sql.DB).ExecContext 方法，直接返回了 sql.Result 和 error。
+//
+// 出错时返回 *OpError，Kind 由 ErrorClassifier 判断。
 func (db *Database) RawExec(query string, args ...interface{}) (sql.Result, error) {
-	return db.origin.ExecContext(db.ctx, query, args...)
+	db.stats.execs.Add(1)
+	result, err := db.origin.ExecContext(db.ctx, query, db.normalizeArgs(args)...)
+	if err != nil {
+		return nil, db.newError(opExec, query, err)
+	}
+	return result, nil
 }
 
 // RawQuery 封装了 (*sql.DB).QueryContext 方法。
@@ -427,14 +284,42 @@ func (db *Database) RawExec(query string, args ...interface{}) (sql.Result, erro
 // 对 sql.Rows 的操作封装到 RowsScanner。若结果只有一行，可以使用 SingleRowScanner。
 //
 // 结果有多行时建议使用 ScanFn，详见 RowsScanner 注释。
-func (db *Database) RawQuery(query string, s RowsScanner, args ...interface{}) (err error) {
+//
+// 总是在主库执行，即使配置了从库，因为 query 可能是带有 returning 的写入语句。只读的查询可以用 db.Replica().RawQuery 在从库执行。
+func (db *Database) RawQuery(query string, s RowsScanner, args ...interface{}) error {
+	return db.rawQuery(nil, query, s, args)
+}
+
+// rawQuery 在从库 r 执行查询，r 为 nil 时在主库执行。
+// 从库连接失败时将其移除，改用其他从库，最后使用主库。
+func (db *Database) rawQuery(r *replica, query string, s RowsScanner, args []interface{}) error {
+	db.stats.queries.Add(1)
+	args = db.normalizeArgs(args)
+	for r != nil {
+		rows, err := r.query(db.ctx, query, args)
+		if err == nil {
+			return db.scanRows(rows, query, s)
+		}
+		if !connectionError(err) {
+			return db.newError(opQuery, query, err)
+		}
+		// r is ejected by now
+		r = db.replicas.pick()
+	}
 	rows, err := db.origin.QueryContext(db.ctx, query, args...)
 	if err != nil {
-		return err
+		return db.newError(opQuery, query, err)
 	}
-	err = s.ScanFrom(rows)
+	return db.scanRows(rows, query, s)
+}
+
+func (db *Database) scanRows(rows *sql.Rows, query string, s RowsScanner) error {
+	err := s.ScanFrom(rows)
 	rows.Close()
-	return
+	if err != nil {
+		return db.newError(opQuery, query, err)
+	}
+	return nil
 }
 
 func (db *Database) Quote(s string) string { return db.dialect.Quote(s) }
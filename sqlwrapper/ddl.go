@@ -0,0 +1,443 @@
+package sqlwrapper
+
+import (
+	"database/sql"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type OptionDDL interface {
+	applyToOptionDDL(opt *optDDL)
+}
+
+type optDDL struct {
+	ifExists bool
+}
+
+type optIfExists struct{}
+
+func (optIfExists) applyToOptionDDL(o *optDDL) { o.ifExists = true }
+
+// IfNotExists 使 CreateTable 和 CreateIndex 在表或索引已存在时不报错。
+//
+// MySQL 不支持 create index if not exists，该选项对 MySQL 的索引无效。
+func IfNotExists() OptionDDL { return optIfExists{} }
+
+// IfExists 使 DropTable 在表不存在时不报错。
+func IfExists() OptionDDL { return optIfExists{} }
+
+// sqlTypes 是 Go 类型对应的各数据库列类型。
+type sqlTypes struct {
+	boolean, int8, int16, int32, int64         string
+	uint8, uint16, uint32, uint64              string
+	float32, float64, varchar, bytes, datetime string
+	json                                       string
+}
+
+var (
+	mysqlTypes = sqlTypes{
+		"boolean", "tinyint", "smallint", "int", "bigint",
+		"tinyint unsigned", "smallint unsigned", "int unsigned", "bigint unsigned",
+		"float", "double", "varchar", "blob", "datetime",
+		"json",
+	}
+	postgresqlTypes = sqlTypes{
+		"boolean", "smallint", "smallint", "integer", "bigint",
+		"smallint", "integer", "bigint", "numeric(20)",
+		"real", "double precision", "varchar", "bytea", "timestamp",
+		"jsonb",
+	}
+	sqliteTypes = sqlTypes{
+		"boolean", "integer", "integer", "integer", "integer",
+		"integer", "integer", "integer", "integer",
+		"real", "real", "varchar", "blob", "datetime",
+		"text",
+	}
+	sqlserverTypes = sqlTypes{
+		"bit", "smallint", "smallint", "int", "bigint",
+		"tinyint", "int", "bigint", "numeric(20)",
+		"real", "float", "nvarchar", "varbinary(max)", "datetime2",
+		"nvarchar(max)",
+	}
+	oracleTypes = sqlTypes{
+		"number(1)", "number(3)", "number(5)", "number(10)", "number(19)",
+		"number(3)", "number(5)", "number(10)", "number(20)",
+		"binary_float", "binary_double", "varchar2", "blob", "timestamp",
+		"clob",
+	}
+	defaultTypes = sqlTypes{
+		"boolean", "smallint", "smallint", "integer", "bigint",
+		"smallint", "integer", "bigint", "numeric(20)",
+		"real", "double precision", "varchar", "blob", "timestamp",
+		"text",
+	}
+)
+
+func typesOf(driver string) *sqlTypes {
+	switch driver {
+	case "mysql":
+		return &mysqlTypes
+	case "pgx", "postgres":
+		return &postgresqlTypes
+	case "sqlite", "sqlite3":
+		return &sqliteTypes
+	case "mssql", "sqlserver":
+		return &sqlserverTypes
+	case "oci8", "oracle":
+		return &oracleTypes
+	}
+	return &defaultTypes
+}
+
+var (
+	timeType  = reflect.TypeOf(time.Time{})
+	bytesType = reflect.TypeOf([]byte(nil))
+
+	// nullTypes 是 sql.Null* 类型对应的值类型
+	nullTypes = map[reflect.Type]reflect.Type{
+		reflect.TypeOf(sql.NullString{}):  reflect.TypeOf(""),
+		reflect.TypeOf(sql.NullInt64{}):   reflect.TypeOf(int64(0)),
+		reflect.TypeOf(sql.NullInt32{}):   reflect.TypeOf(int32(0)),
+		reflect.TypeOf(sql.NullInt16{}):   reflect.TypeOf(int16(0)),
+		reflect.TypeOf(sql.NullByte{}):    reflect.TypeOf(uint8(0)),
+		reflect.TypeOf(sql.NullFloat64{}): reflect.TypeOf(float64(0)),
+		reflect.TypeOf(sql.NullBool{}):    reflect.TypeOf(false),
+		reflect.TypeOf(sql.NullTime{}):    timeType,
+	}
+)
+
+// valueType 返回字段的值类型（去掉指针、sql.Null* 和 Null[T] 包装），以及该字段是否可以为 NULL。
+func valueType(t reflect.Type) (reflect.Type, bool) {
+	nullable := false
+	for t.Kind() == reflect.Ptr {
+		t, nullable = t.Elem(), true
+	}
+	if vt, ok := nullTypes[t]; ok {
+		return vt, true
+	}
+	if vt, ok := nullValueType(t); ok {
+		return vt, true
+	}
+	return t, nullable
+}
+
+// columnType 返回字段在 driver 中的列类型。不支持的类型返回空字符串。
+func columnType(driver string, fm *fieldMeta) string {
+	if len(fm.tag.sqlType) > 0 {
+		return fm.tag.sqlType
+	}
+	types := typesOf(driver)
+	if fm.tag.unix {
+		return types.int64
+	}
+	switch fm.tag.codec {
+	case "":
+	case "json":
+		return types.json
+	default:
+		// the encoding of other codecs is unknown here, binary columns accept both text and binary data
+		return types.bytes
+	}
+	t, _ := valueType(fm.typ)
+	switch t {
+	case timeType:
+		return types.datetime
+	case bytesType:
+		return types.bytes
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		return types.boolean
+	case reflect.Int8:
+		return types.int8
+	case reflect.Int16:
+		return types.int16
+	case reflect.Int32:
+		return types.int32
+	case reflect.Int64:
+		return types.int64
+	case reflect.Int:
+		if t.Bits() == 32 {
+			return types.int32
+		}
+		return types.int64
+	case reflect.Uint8:
+		return types.uint8
+	case reflect.Uint16:
+		return types.uint16
+	case reflect.Uint32:
+		return types.uint32
+	case reflect.Uint64:
+		return types.uint64
+	case reflect.Uint:
+		if t.Bits() == 32 {
+			return types.uint32
+		}
+		return types.uint64
+	case reflect.Float32:
+		return types.float32
+	case reflect.Float64:
+		return types.float64
+	case reflect.String:
+		size := fm.tag.size
+		if size <= 0 {
+			size = 255
+		}
+		return types.varchar + "(" + strconv.Itoa(size) + ")"
+	}
+	return ""
+}
+
+// CreateTableSQL 返回根据 e 的结构体字段生成的建表语句，以及 tag 中声明的索引的建索引语句。
+//
+// 整数类型的主键会设为自增列（MySQL auto_increment，SQLite autoincrement，SQL Server identity，
+// Postgresql 和 Oracle generated by default as identity）。tag 选项详见 tagOptions。
+func (db *Database) CreateTableSQL(e IEntity, options ...OptionDDL) ([]string, error) {
+	sm, err := db.RegisterType(e)
+	if err != nil {
+		return nil, err
+	}
+	o := &optDDL{}
+	for _, opt := range options {
+		opt.applyToOptionDDL(o)
+	}
+
+	ctx := db.newContext()
+	defer db.recycleContext(ctx)
+	table := e.TableName()
+	ctx.WriteString("create table ")
+	if o.ifExists && db.supportsIfNotExists() {
+		ctx.WriteString("if not exists ")
+	}
+	ctx.WriteQuotedString(table).WriteString(" (")
+	for i, column := range sm.columns {
+		if i > 0 {
+			ctx.WriteString(", ")
+		}
+		err = db.columnDefinition(ctx, sm.columnFieldMap[column], column == e.PkColumn())
+		if err != nil {
+			return nil, err
+		}
+	}
+	ctx.WriteByte(')')
+
+	stmt := ctx.QueryString()
+	if o.ifExists && !db.supportsIfNotExists() {
+		stmt = db.ifNotExists(stmt, "U", table)
+	}
+	return append([]string{stmt}, db.createIndexSQL(table, sm, o)...), nil
+}
+
+// CreateTable 执行 CreateTableSQL 生成的语句。
+//
+//	db.CreateTable(&Employee{}, IfNotExists())
+func (db *Database) CreateTable(e IEntity, options ...OptionDDL) error {
+	stmts, err := db.CreateTableSQL(e, options...)
+	if err != nil {
+		return err
+	}
+	return db.execAll(stmts)
+}
+
+// CreateIndexSQL 返回 e 的 tag 中声明的所有索引的建索引语句。
+func (db *Database) CreateIndexSQL(e IEntity, options ...OptionDDL) ([]string, error) {
+	sm, err := db.RegisterType(e)
+	if err != nil {
+		return nil, err
+	}
+	o := &optDDL{}
+	for _, opt := range options {
+		opt.applyToOptionDDL(o)
+	}
+	return db.createIndexSQL(e.TableName(), sm, o), nil
+}
+
+// CreateIndex 执行 CreateIndexSQL 生成的语句。
+func (db *Database) CreateIndex(e IEntity, options ...OptionDDL) error {
+	stmts, err := db.CreateIndexSQL(e, options...)
+	if err != nil {
+		return err
+	}
+	return db.execAll(stmts)
+}
+
+// DropTableSQL 返回 e 对应的表的删表语句。
+func (db *Database) DropTableSQL(e IEntity, options ...OptionDDL) string {
+	o := &optDDL{}
+	for _, opt := range options {
+		opt.applyToOptionDDL(o)
+	}
+	table := e.TableName()
+	switch db.driver {
+	case "oci8", "oracle":
+		stmt := "drop table " + db.Quote(table)
+		if o.ifExists {
+			// ORA-00942: table or view does not exist
+			return "begin execute immediate '" + stmt + "'; " +
+				"exception when others then if sqlcode != -942 then raise; end if; end;"
+		}
+		return stmt
+	}
+	if o.ifExists {
+		return "drop table if exists " + db.Quote(table)
+	}
+	return "drop table " + db.Quote(table)
+}
+
+// DropTable 执行 DropTableSQL 生成的语句。
+func (db *Database) DropTable(e IEntity, options ...OptionDDL) error {
+	_, err := db.RawExec(db.DropTableSQL(e, options...))
+	return err
+}
+
+func (db *Database) execAll(stmts []string) error {
+	for _, stmt := range stmts {
+		if _, err := db.RawExec(stmt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (db *Database) columnDefinition(ctx *SqlCtx, fm *fieldMeta, isPk bool) error {
+	typ := columnType(db.driver, fm)
+	if len(typ) == 0 {
+		return ErrUnsupportedColumnType
+	}
+	ctx.WriteQuotedString(fm.column).WriteByte(' ')
+
+	t, nullable := valueType(fm.typ)
+	if isPk {
+		autoIncrement := false
+		switch t.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+			reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			autoIncrement = true
+		}
+		if !autoIncrement {
+			ctx.WriteString(typ).WriteString(" not null primary key")
+			return nil
+		}
+		switch db.driver {
+		case "mysql":
+			ctx.WriteString(typ).WriteString(" not null auto_increment primary key")
+		case "sqlite", "sqlite3":
+			// only "integer primary key" is an alias of rowid
+			ctx.WriteString("integer primary key autoincrement")
+		case "mssql", "sqlserver":
+			ctx.WriteString(typ).WriteString(" identity(1,1) primary key")
+		default:
+			ctx.WriteString(typ).WriteString(" generated by default as identity primary key")
+		}
+		return nil
+	}
+
+	ctx.WriteString(typ)
+	if fm.tag.hasDefault {
+		ctx.WriteString(" default ").WriteString(fm.tag.defaultValue)
+	}
+	switch {
+	case fm.tag.nullable > 0:
+		ctx.WriteString(" null")
+	case fm.tag.nullable < 0, !nullable:
+		ctx.WriteString(" not null")
+	}
+	if fm.tag.unique {
+		ctx.WriteString(" unique")
+	}
+	return nil
+}
+
+// entityIndex 是 tag 中声明的索引。
+type entityIndex struct {
+	name    string
+	unique  bool
+	columns []string
+}
+
+// entityIndexes 按 tag 中声明的顺序返回索引，同名索引的列按字段顺序组成联合索引。
+func entityIndexes(table string, sm *structMeta) []*entityIndex {
+	indexes := []*entityIndex{}
+	byName := map[string]*entityIndex{}
+	for _, column := range sm.columns {
+		for _, it := range sm.columnFieldMap[column].tag.indexes {
+			name := it.name
+			if len(name) == 0 {
+				name = "idx_" + strings.ReplaceAll(table, ".", "_") + "_" + column
+			}
+			idx, ok := byName[name]
+			if !ok {
+				idx = &entityIndex{name: name, unique: it.unique}
+				byName[name] = idx
+				indexes = append(indexes, idx)
+			}
+			idx.columns = append(idx.columns, column)
+		}
+	}
+	return indexes
+}
+
+func (db *Database) createIndexSQL(table string, sm *structMeta, o *optDDL) []string {
+	indexes := entityIndexes(table, sm)
+	stmts := make([]string, 0, len(indexes))
+	for _, idx := range indexes {
+		stmts = append(stmts, db.indexSQL(table, idx, o.ifExists))
+	}
+	return stmts
+}
+
+func (db *Database) indexSQL(table string, idx *entityIndex, ifNotExists bool) string {
+	ctx := db.newContext()
+	defer db.recycleContext(ctx)
+	ctx.WriteString("create ")
+	if idx.unique {
+		ctx.WriteString("unique ")
+	}
+	ctx.WriteString("index ")
+	if ifNotExists && db.supportsIfNotExists() && db.driver != "mysql" {
+		ctx.WriteString("if not exists ")
+	}
+	ctx.WriteQuotedString(idx.name).WriteString(" on ").WriteQuotedString(table).WriteString(" (")
+	for i, column := range idx.columns {
+		if i > 0 {
+			ctx.WriteString(", ")
+		}
+		ctx.WriteQuotedString(column)
+	}
+	ctx.WriteByte(')')
+	if ifNotExists && !db.supportsIfNotExists() {
+		return db.ifNotExists(ctx.QueryString(), "I", idx.name+" on "+table)
+	}
+	return ctx.QueryString()
+}
+
+// supportsIfNotExists 判断 driver 是否支持 create ... if not exists。
+func (db *Database) supportsIfNotExists() bool {
+	switch db.driver {
+	case "mssql", "sqlserver", "oci8", "oracle":
+		return false
+	}
+	return true
+}
+
+// ifNotExists 为不支持 if not exists 的数据库包装建表、建索引语句。
+//
+// kind 为 "U" 时 name 是表名，为 "I" 时 name 是 "<index> on <table>"。
+func (db *Database) ifNotExists(stmt, kind, name string) string {
+	switch db.driver {
+	case "mssql", "sqlserver":
+		if kind == "U" {
+			return "if object_id(N'" + name + "', N'U') is null " + stmt
+		}
+		index, table, _ := strings.Cut(name, " on ")
+		return "if not exists (select * from sys.indexes where name = N'" + index +
+			"' and object_id = object_id(N'" + table + "')) " + stmt
+	case "oci8", "oracle":
+		// ORA-00955: name is already used by an existing object
+		return "begin execute immediate '" + strings.ReplaceAll(stmt, "'", "''") + "'; " +
+			"exception when others then if sqlcode != -955 then raise; end if; end;"
+	}
+	return stmt
+}
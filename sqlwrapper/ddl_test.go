@@ -0,0 +1,157 @@
+package sqlwrapper
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+type ddlUser struct {
+	ID        int64
+	Name      string         `db:"name,size:64,index:idx_user_name_age"`
+	Age       int32          `db:"age,default:0,index:idx_user_name_age"`
+	Email     *string        `db:"email,unique"`
+	Nickname  sql.NullString `db:"nickname,uniqueindex"`
+	Score     float64        `db:"score,type:decimal(10,2),null"`
+	CreatedAt time.Time      `db:"created_at"`
+	Ignored   int            `db:"-"`
+}
+
+func (ddlUser) TableName() string { return "users" }
+func (ddlUser) PkColumn() string  { return "id" }
+
+func testDatabase(driver string) *Database {
+	return &Database{driver: driver, dialect: GetDialect(driver), onNull: DoNothing, vc: Vcie}
+}
+
+func TestCreateTableSQL(t *testing.T) {
+	tests := []struct {
+		driver  string
+		options []OptionDDL
+		want    []string
+	}{
+		{"mysql", nil, []string{
+			"create table `users` (`id` bigint not null auto_increment primary key, `name` varchar(64) not null, `age` int default 0 not null, `email` varchar(255) unique, `nickname` varchar(255), `score` decimal(10,2) null, `created_at` datetime not null)",
+			"create index `idx_user_name_age` on `users` (`name`, `age`)",
+			"create unique index `idx_users_nickname` on `users` (`nickname`)",
+		}},
+		{"pgx", []OptionDDL{IfNotExists()}, []string{
+			`create table if not exists "users" ("id" bigint generated by default as identity primary key, "name" varchar(64) not null, "age" integer default 0 not null, "email" varchar(255) unique, "nickname" varchar(255), "score" decimal(10,2) null, "created_at" timestamp not null)`,
+			`create index if not exists "idx_user_name_age" on "users" ("name", "age")`,
+			`create unique index if not exists "idx_users_nickname" on "users" ("nickname")`,
+		}},
+		{"sqlserver", []OptionDDL{IfNotExists()}, []string{
+			"if object_id(N'users', N'U') is null create table [users] ([id] bigint identity(1,1) primary key, [name] nvarchar(64) not null, [age] int default 0 not null, [email] nvarchar(255) unique, [nickname] nvarchar(255), [score] decimal(10,2) null, [created_at] datetime2 not null)",
+			"if not exists (select * from sys.indexes where name = N'idx_user_name_age' and object_id = object_id(N'users')) create index [idx_user_name_age] on [users] ([name], [age])",
+			"if not exists (select * from sys.indexes where name = N'idx_users_nickname' and object_id = object_id(N'users')) create unique index [idx_users_nickname] on [users] ([nickname])",
+		}},
+	}
+	for _, test := range tests {
+		stmts, err := testDatabase(test.driver).CreateTableSQL(ddlUser{}, test.options...)
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", test.driver, err)
+			continue
+		}
+		if len(stmts) != len(test.want) {
+			t.Errorf("%s: got %d statements %q, want %d", test.driver, len(stmts), stmts, len(test.want))
+			continue
+		}
+		for i := range stmts {
+			if stmts[i] != test.want[i] {
+				t.Errorf("%s: got\n%s\nwant\n%s", test.driver, stmts[i], test.want[i])
+			}
+		}
+	}
+}
+
+func TestDropTableSQL(t *testing.T) {
+	if got, want := testDatabase("pgx").DropTableSQL(ddlUser{}, IfExists()), `drop table if exists "users"`; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+	if got, want := testDatabase("oracle").DropTableSQL(ddlUser{}), `drop table "users"`; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
+
+func TestSplitTag(t *testing.T) {
+	column, opts, err := parseTag("score,type:decimal(10,2),default:'a,b',notnull")
+	if err != nil || column != "score" || opts.sqlType != "decimal(10,2)" || opts.defaultValue != "'a,b'" || opts.nullable != -1 {
+		t.Errorf("unexpected result %q %+v", column, opts)
+	}
+}
+
+var invalidTags = []string{"size:abc", "size:-1", "onnull:zero"}
+
+type invalidTagUser struct {
+	ID   int64
+	Name string `db:",onnull:zero"`
+}
+
+func TestInvalidTagOption(t *testing.T) {
+	for _, tag := range invalidTags {
+		if _, _, err := parseTag("name," + tag); !errors.Is(err, ErrInvalidTagOption) {
+			t.Errorf("parseTag(%q): got %v, want %v", tag, err, ErrInvalidTagOption)
+		}
+	}
+	_, err := testDatabase("mysql").RegisterType(invalidTagUser{})
+	if !errors.Is(err, ErrInvalidTagOption) || !strings.Contains(err.Error(), "invalidTagUser.Name") {
+		t.Errorf("got %v, want %v of invalidTagUser.Name", err, ErrInvalidTagOption)
+	}
+}
+
+func TestDiffTable(t *testing.T) {
+	db := testDatabase("pgx")
+	sm, err := db.RegisterType(ddlUser{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d, err := db.diffTable(ddlUser{}, sm, &TableInfo{Name: "users"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(d.Statements) != 3 || len(d.Manual) != 0 {
+		t.Errorf("missing table: unexpected diff %+v", d)
+	}
+
+	d, err = db.diffTable(ddlUser{}, sm, &TableInfo{
+		Name:   "users",
+		Exists: true,
+		Columns: []ColumnInfo{
+			{Name: "id", Type: "bigint"},
+			{Name: "name", Type: "character varying", Nullable: true},
+			{Name: "email", Type: "character varying", Nullable: true},
+			{Name: "nickname", Type: "character varying", Nullable: true},
+			{Name: "legacy", Type: "integer", Nullable: true},
+		},
+		Indexes: []string{"users_pkey", "idx_users_nickname"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantStatements := []string{
+		`alter table "users" add column "age" integer default 0 not null`,
+		`alter table "users" add column "score" decimal(10,2) null`,
+		`create index "idx_user_name_age" on "users" ("name", "age")`,
+	}
+	wantManual := []string{
+		`alter table "users" alter column "name" set not null`,
+		`alter table "users" add column "created_at" timestamp not null`,
+		`alter table "users" drop column "legacy"`,
+	}
+	if len(d.Statements) != len(wantStatements) || len(d.Manual) != len(wantManual) {
+		t.Fatalf("unexpected diff %q %q", d.Statements, d.Manual)
+	}
+	for i := range wantStatements {
+		if d.Statements[i] != wantStatements[i] {
+			t.Errorf("got\n%s\nwant\n%s", d.Statements[i], wantStatements[i])
+		}
+	}
+	for i := range wantManual {
+		if d.Manual[i] != wantManual[i] {
+			t.Errorf("got\n%s\nwant\n%s", d.Manual[i], wantManual[i])
+		}
+	}
+}
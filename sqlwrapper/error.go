@@ -1,6 +1,11 @@
 package sqlwrapper
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+)
 
 // errors and error format texts
 
@@ -13,16 +18,184 @@ var (
 	ErrNotEnoughArgs = errors.New("not enough arguments")
 	ErrTooManyArgs   = errors.New("too many arguments")
 
-	ErrInvalidJoinCondType      = errors.New(`invalid join condition type (should be either "on" or "using")`)
-	ErrDialectAlreadyRegistered = errors.New("dialect has already been registered")
+	ErrNoSourceTable         = errors.New("no source table in insert select (use From option)")
+	ErrEmptySet              = errors.New("no set clause in update")
+	ErrFromNotSupported      = errors.New("from tables in update or delete are not supported by the driver")
+	ErrLimitNotSupported     = errors.New("limit in update or delete is not supported by the driver")
+	ErrMultiTableLimit       = errors.New("limit in multi-table update or delete is not supported by the driver")
+	ErrReturningNotSupported = errors.New("returning is not supported by the driver")
+
+	ErrUnsupportedColumnType = errors.New("unsupported field type for column definition (use type tag option)")
+
+	ErrNoShards            = errors.New("no shards")
+	ErrNotShardedEntity    = errors.New("entity is not sharded (should implement IShardedEntity)")
+	ErrNoShardKey          = errors.New("shard key is required (use ShardKey option)")
+	ErrUnsupportedShardKey = errors.New("unsupported shard key type")
+	ErrShardOutOfRange     = errors.New("shard out of range")
+	ErrCrossShardTx        = errors.New("cross-shard transactions are not supported")
+	ErrCrossShardQuery     = errors.New("group by, set operations, distinct and aggregates are not supported across shards (use ShardKey option)")
+	ErrCrossShardOrderBy   = errors.New("order by column with limit across shards must be a non-nullable number, time or bool")
+
+	ErrLockOutsideTx        = errors.New("row locking options can only be used in a transaction")
+	ErrPanicInTx            = errors.New("panic in transaction")
+	ErrLockNotSupported     = errors.New("row locking mode is not supported by the driver")
+	ErrLockWithSetOperation = errors.New("row locking options cannot be used with union, intersect or except")
+
+	ErrUnexpectedNull = errors.New("unexpected NULL value")
+
+	ErrUnsupportedConversion = errors.New("unsupported conversion")
+	ErrUnsupportedSource     = errors.New("unsupported source type")
+	ErrOutOfRange            = errors.New("value out of range")
+	ErrNotBool               = errors.New("only 0 and 1 could convert to bool")
+	ErrZeroPrimaryKey        = errors.New("primary key should not be empty or zero value")
+	ErrNoFieldForColumn      = errors.New("cannot find any fields related to column")
+	ErrCodecNotRegistered    = errors.New("codec is not registered")
+	ErrInvalidTagOption      = errors.New("invalid db tag option")
+
+	ErrInvalidJoinCondType         = errors.New(`invalid join condition type (should be either "on" or "using")`)
+	ErrDialectAlreadyRegistered    = errors.New("dialect has already been registered")
+	ErrClassifierAlreadyRegistered = errors.New("error classifier has already been registered")
+)
+
+const (
+	f1 = "%w from type %T into type %s"
+	f2 = "converting value type %T (%v) to type %s: %w"
+	f3 = "%w: %T"
+	f4 = "%w (column '%s')"
+	f5 = "%w '%s'"
+	f6 = "%w: '%s'"
+	f7 = "codec of column '%s': %w"
+	f8 = "%w (field type %s is not nullable)"
+	f9 = "transaction failed after %d attempts: %w"
+	fa = "shard %d: %w"
+	fb = "%w (shard %d)"
+	fc = "ping failed after %d attempts: %w"
+	fd = "%w (field %s.%s)"
+
+	fx1 = "%w: %v"
+	fx2 = "%w: %w"
 )
 
+// ErrorKind 是与数据库无关的错误类型，由 ErrorClassifier 根据驱动返回的错误判断。
+type ErrorKind uint8
+
 const (
-	f1 = "unsupported conversion from type %T into type %s"
-	f2 = "converting value type %T (%v) to type %s: %s"
-	f3 = "unsupported source type: %T"
-	f4 = "primary key field '%s' should not be empty or zero value"
-	f5 = "cannot find any fields related to column '%s'"
+	// Unclassified 代表无法归类的错误，或者不是数据库返回的错误。
+	Unclassified ErrorKind = iota
+	// UniqueViolation 违反唯一约束或主键约束。
+	UniqueViolation
+	// ForeignKeyViolation 违反外键约束。
+	ForeignKeyViolation
+	// NotNullViolation 向 NOT NULL 的列写入 NULL。
+	NotNullViolation
+	// Deadlock 事务因死锁被数据库回滚。
+	Deadlock
+	// SerializationFailure 事务因并发修改无法串行化，通常可以重试。
+	SerializationFailure
+	// Timeout 语句超时、等待锁超时或 context 超时。
+	Timeout
+)
+
+var errorKindNames = [...]string{
+	Unclassified:         "unclassified",
+	UniqueViolation:      "unique violation",
+	ForeignKeyViolation:  "foreign key violation",
+	NotNullViolation:     "not null violation",
+	Deadlock:             "deadlock",
+	SerializationFailure: "serialization failure",
+	Timeout:              "timeout",
+}
+
+func (k ErrorKind) String() string {
+	if int(k) < len(errorKindNames) {
+		return errorKindNames[k]
+	}
+	return "unknown error kind"
+}
+
+// OpError 是 sqlwrapper 的方法返回的错误，记录出错的操作、表、列和 SQL 语句，原始错误用 errors.Is 和 errors.As 获取。
+//
+//	var e *sqlwrapper.OpError
+//	if errors.As(err, &e) && e.Kind == sqlwrapper.UniqueViolation {
+//	    // ...
+//	}
+type OpError struct {
+	// Op 是出错的操作，如 insert、update、query、scan、exec、begin、commit。
+	Op string
+	// Table 是操作的表，不确定时为空。
+	Table string
+	// Column 是出错的列，只有扫描和转换某一列时出错才有。
+	Column string
+	// SQL 是执行的 SQL 语句，不包括参数。
+	SQL string
+	// Kind 是错误的类型，见 ErrorKind。
+	Kind ErrorKind
+	Err  error
+}
+
+func (e *OpError) Error() string {
+	b := &strings.Builder{}
+	b.WriteString(e.Op)
+	if len(e.Table) > 0 {
+		fmt.Fprintf(b, " table '%s'", e.Table)
+	}
+	if len(e.Column) > 0 {
+		fmt.Fprintf(b, " column '%s'", e.Column)
+	}
+	b.WriteString(": ")
+	b.WriteString(e.Err.Error())
+	return b.String()
+}
 
-	fx1 = "fail to create transaction: %s"
+func (e *OpError) Unwrap() error { return e.Err }
+
+// KindOf 返回 err 的 ErrorKind。err 不是 *OpError 时只能判断 context 超时。
+func KindOf(err error) ErrorKind {
+	var e *OpError
+	if errors.As(err, &e) {
+		return e.Kind
+	}
+	return classifyCommon(err)
+}
+
+const (
+	opExec  = "exec"
+	opQuery = "query"
 )
+
+// wrapError 为 *err 补充操作和表名。*err 不是 *OpError 时包装为 *OpError；
+// 是 RawExec 或 RawQuery 返回的 *OpError 时，将 exec 或 query 替换为 op。
+func wrapError(err *error, op, table string) {
+	if *err == nil {
+		return
+	}
+	e, ok := (*err).(*OpError)
+	if !ok {
+		*err = &OpError{Op: op, Table: table, Kind: classifyCommon(*err), Err: *err}
+		return
+	}
+	if e.Op == opExec || e.Op == opQuery {
+		e.Op = op
+	}
+	if len(e.Table) == 0 {
+		e.Table = table
+	}
+}
+
+// tableOf 返回 entity 或 entity 切片的指针对应的表名，用于错误信息，不是 IEntity 时返回空字符串。
+func tableOf(v interface{}) string {
+	t := reflect.TypeOf(v)
+	for t != nil && (t.Kind() == reflect.Ptr || t.Kind() == reflect.Slice) {
+		t = t.Elem()
+	}
+	if t == nil || t.Kind() != reflect.Struct {
+		return ""
+	}
+	if e, ok := reflect.Zero(t).Interface().(IEntity); ok {
+		return e.TableName()
+	}
+	if e, ok := reflect.New(t).Interface().(IEntity); ok {
+		return e.TableName()
+	}
+	return ""
+}
@@ -0,0 +1,100 @@
+package sqlwrapper
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+// 模拟各驱动的错误类型
+type (
+	mysqlError struct {
+		Number  uint16
+		Message string
+	}
+	pgxError   struct{ code string }
+	pqError    struct{ Code pqErrorCode }
+	mssqlError struct {
+		Number  int32
+		Message string
+	}
+	pqErrorCode string
+)
+
+func (e *mysqlError) Error() string { return fmt.Sprintf("Error %d: %s", e.Number, e.Message) }
+func (e *pgxError) Error() string   { return "pgx error " + e.code }
+func (e *pgxError) SQLState() string {
+	return e.code
+}
+func (e *pqError) Error() string    { return "pq: " + string(e.Code) }
+func (e *mssqlError) Error() string { return "mssql: " + e.Message }
+
+func TestClassifier(t *testing.T) {
+	for _, c := range []struct {
+		driver string
+		err    error
+		kind   ErrorKind
+	}{
+		{"mysql", &mysqlError{1062, "Duplicate entry '1' for key 'PRIMARY'"}, UniqueViolation},
+		{"mysql", fmt.Errorf("wrapped: %w", &mysqlError{1452, "Cannot add or update a child row"}), ForeignKeyViolation},
+		{"mysql", &mysqlError{1213, "Deadlock found"}, Deadlock},
+		{"mysql", errors.New("Error 1048 (23000): Column 'name' cannot be null"), NotNullViolation},
+		{"pgx", &pgxError{"40001"}, SerializationFailure},
+		{"pgx", &pgxError{"40P01"}, Deadlock},
+		{"postgres", &pqError{"23505"}, UniqueViolation},
+		{"postgres", &pqError{"23502"}, NotNullViolation},
+		{"sqlite", errors.New("UNIQUE constraint failed: user.name"), UniqueViolation},
+		{"sqlite3", errors.New("FOREIGN KEY constraint failed"), ForeignKeyViolation},
+		{"sqlite", errors.New("database is locked (5) (SQLITE_BUSY)"), Timeout},
+		{"sqlserver", &mssqlError{2627, "Violation of PRIMARY KEY constraint"}, UniqueViolation},
+		{"sqlserver", &mssqlError{547, "The INSERT statement conflicted with the FOREIGN KEY constraint"}, ForeignKeyViolation},
+		{"sqlserver", &mssqlError{547, "The INSERT statement conflicted with the CHECK constraint"}, Unclassified},
+		{"mssql", &mssqlError{3960, "Snapshot isolation transaction aborted"}, SerializationFailure},
+		{"oracle", errors.New("ORA-00001: unique constraint (ORM.SYS_C008) violated"), UniqueViolation},
+		{"oci8", errors.New("ORA-08177: can't serialize access for this transaction"), SerializationFailure},
+		{"oracle", errors.New("ORA-01400: cannot insert NULL"), NotNullViolation},
+		{"unknown", fmt.Errorf("exec: %w", context.DeadlineExceeded), Timeout},
+		{"mysql", &pgxError{"23505"}, Unclassified},
+	} {
+		if got := GetErrorClassifier(c.driver).Classify(c.err); got != c.kind {
+			t.Errorf("%s %v: got %v, want %v", c.driver, c.err, got, c.kind)
+		}
+	}
+	if got := KindOf(fmt.Errorf("exec: %w", context.DeadlineExceeded)); got != Timeout {
+		t.Errorf("got %v, want timeout", got)
+	}
+}
+
+func TestOpError(t *testing.T) {
+	f := newFakeDB(t)
+	db := f.open(t, "", WithErrorClassifier(mysqlClassifier))
+	cause := &mysqlError{1062, "Duplicate entry '1' for key 'PRIMARY'"}
+	f.setExecError(cause)
+
+	err := db.Save(&scanUser{ID: 1, Name: "foo"})
+	var e *OpError
+	if !errors.As(err, &e) {
+		t.Fatalf("expect *OpError, got %T %v", err, err)
+	}
+	if e.Op != "update" || e.Table != "user" || e.Kind != UniqueViolation || e.SQL != "update user set name = ? where id = ?" {
+		t.Errorf("got %+v", e)
+	}
+	var me *mysqlError
+	if !errors.As(err, &me) || me != cause || KindOf(err) != UniqueViolation {
+		t.Errorf("cause is not wrapped: %v", err)
+	}
+	if want := "update table 'user': " + cause.Error(); err.Error() != want {
+		t.Errorf("got %q, want %q", err.Error(), want)
+	}
+
+	_, err = db.RawExec("delete from user")
+	if !errors.As(err, &e) || e.Op != "exec" || e.Table != "" || e.SQL != "delete from user" {
+		t.Errorf("got %+v", err)
+	}
+
+	err = db.Update(&scanUser{Name: "foo"})
+	if !errors.Is(err, ErrZeroPrimaryKey) || !errors.As(err, &e) || e.Op != "update" || e.Kind != Unclassified {
+		t.Errorf("got %v", err)
+	}
+}
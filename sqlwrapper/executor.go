@@ -0,0 +1,483 @@
+package sqlwrapper
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"reflect"
+)
+
+// Executor 是 *Database 和 *Tx 共同实现的接口。仓储层的代码接受 Executor 时，既可以在事务外执行，也可以在事务中执行。
+//
+//	func CreateUser(x Executor, u *User) error {
+//	    return x.Insert(u)
+//	}
+//
+//	CreateUser(db, &u)
+//	db.RunTx(func(tx *Tx) (bool, error) {
+//	    return true, CreateUser(tx, &u)
+//	})
+//
+// 需要加入调用者的事务而又不想逐层传递 Executor 时，可以通过 context 传递事务，见 Database.Executor。
+type Executor interface {
+	Insert(e IEntity, options ...OptionExec) error
+	Update(e IEntity, options ...OptionExec) error
+	Save(e IEntity, options ...OptionExec) error
+	Query(entity interface{}, options ...OptionQuerySingle) (found bool, err error)
+	QueryMultiple(es interface{}, options ...OptionQueryMultiple) error
+	InsertSelect(table string, columns []string, options ...OptionQueryMultiple) error
+	UpdateWhere(table string, options ...OptionUpdate) error
+	DeleteWhere(table string, options ...OptionDelete) error
+	Delete(table string, options ...OptionDelete) error
+	RawExec(query string, args ...interface{}) (sql.Result, error)
+	RawQuery(query string, s RowsScanner, args ...interface{}) error
+}
+
+var (
+	_ Executor = (*Database)(nil)
+	_ Executor = (*Tx)(nil)
+)
+
+type txKey struct{}
+
+// ContextWithTx 返回携带 tx 的 context。RunTxContext 中可以直接使用 tx.Context()。
+func ContextWithTx(ctx context.Context, tx *Tx) context.Context {
+	return context.WithValue(ctx, txKey{}, tx)
+}
+
+// TxFromContext 返回 ctx 携带的事务，没有时返回 nil 和 false。
+func TxFromContext(ctx context.Context) (*Tx, bool) {
+	tx, ok := ctx.Value(txKey{}).(*Tx)
+	return tx, ok && tx != nil
+}
+
+// Executor 返回 ctx 携带的 db 的事务，没有时返回 db 本身。仓储层的代码因此可以透明地加入调用者的事务。
+//
+//	func (r *UserRepo) Create(ctx context.Context, u *User) error {
+//	    return r.db.Executor(ctx).Insert(u)
+//	}
+//
+//	db.RunTxContext(ctx, func(tx *Tx) (bool, error) {
+//	    ctx := tx.Context()
+//	    if err := users.Create(ctx, &u); err != nil {
+//	        return false, err
+//	    }
+//	    return true, orders.Create(ctx, &o)
+//	}, nil)
+//
+// 其他 Database 的事务被忽略。
+func (db *Database) Executor(ctx context.Context) Executor {
+	if tx, ok := TxFromContext(ctx); ok && tx.db == db {
+		return tx
+	}
+	return db
+}
+
+// rawQuery 使用 x 执行查询。x 是 *Database 且 primary 为 false 时可以在从库执行，见 WithReplicas；
+// 写入语句（如 returning）的 primary 必须为 true。
+func rawQuery(x Executor, primary bool, query string, s RowsScanner, args []interface{}) error {
+	if db, ok := x.(*Database); ok {
+		var r *replica
+		if !primary {
+			r = db.replicas.pick()
+		}
+		return db.rawQuery(r, query, s, args)
+	}
+	return x.RawQuery(query, s, args...)
+}
+
+// insert 实现了 Insert，语句由 x 执行。
+func insert(db *Database, x Executor, e IEntity, options []OptionExec) (err error) {
+	defer wrapError(&err, "insert", tableOf(e))
+	sm, err := db.RegisterType(e)
+	if err != nil {
+		return
+	}
+
+	o := &optExec{
+		columns: sm.columns,
+	}
+	for _, opt := range options {
+		opt.applyToOptionExec(o)
+	}
+
+	ctx := db.newContext()
+	defer db.recycleContext(ctx)
+	nColumns := len(o.columns)
+	args := make([]interface{}, 0, nColumns)
+	m := mapperOf(e)
+
+	table := e.TableName()
+	if len(o.table) > 0 {
+		table = o.table
+	}
+
+	// 构建 sql 语句
+	ctx.WriteString("insert into ").
+		WriteQuotedString(table).
+		WriteString(" (")
+	for _, column := range o.columns {
+		fm, ok := sm.columnFieldMap[column]
+		if !ok {
+			// 没找到该列，说明调用时传入的 WithColumns 中列名可能写错了。
+			return fmt.Errorf(f5, ErrNoFieldForColumn, column)
+		}
+		value, zero := writeValue(m, fm.index)
+		write, null := o.writeMode(fm, zero)
+		if !write {
+			// 默认跳过〇值字段，见 optExec.writeMode
+			continue
+		}
+		if null {
+			value = nil
+		} else if value, err = db.encodeField(fm, value, zero); err != nil {
+			return
+		}
+		if len(args) > 0 {
+			ctx.WriteString(", ")
+		}
+		ctx.WriteQuotedString(column)
+		args = append(args, value)
+	}
+
+	ctx.WriteString(") values (")
+	for i, a := range args {
+		if i > 0 {
+			ctx.WriteString(", ")
+		}
+		ctx.NextPlaceholder(a)
+	}
+	ctx.WriteByte(')')
+
+	// 传入的是个结构体而非指针，返回了 id 也无法赋值。
+	// 直接执行后结束。
+	if reflect.TypeOf(e).Kind() != reflect.Ptr {
+		_, err = x.RawExec(ctx.QueryString(), ctx.args...)
+		return
+	}
+
+	pkIndex := sm.pkStructIndex
+	if pkIndex == -1 || !zeroField(m, pkIndex) {
+		// 没有主键字段，或者有主键字段但不为〇值，则不进行 ID 赋值，执行后直接返回。
+		_, err = x.RawExec(ctx.QueryString(), ctx.args...)
+		return
+	}
+
+	var newId interface{}
+	// 使用 interface{} 类型的原因有二。
+	// 1. 使用 query returning 方式返回时 Scan 赋值不会报错；
+	// 2. 需要考虑主键是字符串的情况。
+	switch db.driver {
+	case "pgx", "postgres":
+		ctx.WriteString(" returning ").WriteQuotedString(e.PkColumn())
+		err = rawQuery(x, true, ctx.QueryString(), SingleRowScanner(&newId), ctx.args)
+		if err != nil {
+			return
+		}
+		// t := reflect.TypeOf(newId)
+		// log.LogInfo(t.Kind(), t.String())
+
+		// 不能确定 returning 的 newId 一定是 int 类型（有可能是 string），需要判断。
+		// 除了 string/uuid -> int 不行以外其他都可以转。
+		if !reflect.TypeOf(newId).ConvertibleTo(sm.pkType) {
+			return
+		}
+	case "mssql", "sqlserver":
+		if sm.pkType.Kind() == reflect.String {
+			// sqlserver 没有办法返回字符串类型主键，直接执行后返回。
+			_, err = x.RawExec(ctx.QueryString(), ctx.args...)
+			return
+		}
+		ctx.WriteString("; select last_id = convert(bigint, SCOPE_IDENTITY())")
+		err = rawQuery(x, true, ctx.QueryString(), SingleRowScanner(&newId), ctx.args)
+		if err != nil {
+			return
+		}
+	default:
+		var result sql.Result
+		result, err = x.RawExec(ctx.QueryString(), ctx.args...)
+		if err != nil {
+			return
+		}
+		var err1 error
+		// 获取新记录的 ID。
+		newId, err1 = result.LastInsertId()
+		if err1 != nil {
+			// 不支持 LastInsertId 方法，直接返回。
+			// TODO: write a warning log
+			return
+		}
+	}
+
+	pkField := reflect.ValueOf(m.FieldPtr(pkIndex)).Elem()
+	pkField.Set(reflect.ValueOf(newId).Convert(sm.pkType))
+	return
+}
+
+// query 实现了 Query，语句由 x 执行。
+func query(db *Database, x Executor, entity interface{}, options []OptionQuerySingle) (found bool, err error) {
+	defer wrapError(&err, "query", tableOf(entity))
+	if reflect.TypeOf(entity).Kind() != reflect.Ptr {
+		err = ErrNotPointer
+		return
+	}
+	sm, err := db.RegisterType(entity)
+	if err != nil {
+		return
+	}
+
+	table := ""
+	if e, ok := entity.(IEntity); ok {
+		table = e.TableName()
+	}
+
+	q := &optQuerySingle{
+		optQuery: optQuery{
+			selectColumns: sm.columns,
+			table: optTable{
+				table: optSingleTable{table},
+			},
+			limit: 1,
+		},
+	}
+	for _, opt := range options {
+		opt.applyToOptionQuerySingle(q)
+	}
+	if !inTx(x) && len(q.lock.strength) > 0 {
+		err = ErrLockOutsideTx
+		return
+	}
+
+	ctx := db.newContext()
+	defer db.recycleContext(ctx)
+	err = q.optQuery.AppendToSqlCtx(ctx)
+	if err != nil {
+		return
+	}
+	// fmt.Println(ctx.QueryString(), ctx.args)
+	err = rawQuery(x, q.primary, ctx.QueryString(),
+		structScanner(entity, sm, db.scanOptions(), q.unused, &found),
+		ctx.args)
+	return
+}
+
+// queryMultiple 实现了 QueryMultiple，语句由 x 执行。
+func queryMultiple(db *Database, x Executor, es interface{}, options []OptionQueryMultiple) (err error) {
+	defer wrapError(&err, "query", tableOf(es))
+	t := reflect.TypeOf(es)
+	if t.Kind() != reflect.Ptr {
+		return ErrNotPointer
+	}
+	t = t.Elem()
+	if t.Kind() != reflect.Slice {
+		return ErrElemNotSlice
+	}
+	t = t.Elem()
+
+	isPointer := t.Kind() == reflect.Ptr
+	if isPointer {
+		t = t.Elem()
+	}
+
+	if t.Kind() != reflect.Struct {
+		return ErrElemNotStruct
+	}
+
+	entity := reflect.New(t).Interface()
+	sm, err := db.RegisterType(entity)
+	if err != nil {
+		return
+	}
+
+	table := ""
+	if e, ok := entity.(IEntity); ok {
+		table = e.TableName()
+	}
+
+	q := &optQueryMultiple{
+		optQuery: optQuery{
+			selectColumns: sm.columns,
+			table: optTable{
+				table: optSingleTable{table},
+			},
+		},
+	}
+	for _, opt := range options {
+		opt.applyToOptionQueryMultiple(q)
+	}
+	if !inTx(x) && len(q.lock.strength) > 0 {
+		return ErrLockOutsideTx
+	}
+
+	ctx := db.newContext()
+	defer db.recycleContext(ctx)
+	err = q.optQuery.AppendToSqlCtx(ctx)
+	if err != nil {
+		return
+	}
+	// fmt.Println(ctx.QueryString(), ctx.args)
+	err = rawQuery(x, q.primary, ctx.QueryString(),
+		sliceScanner(es, sm, db.scanOptions(), t),
+		ctx.args)
+	return
+}
+
+// update 实现了 Update，语句由 x 执行。
+func update(db *Database, x Executor, e IEntity, options []OptionExec) (err error) {
+	defer wrapError(&err, "update", tableOf(e))
+	sm, err := db.RegisterType(e)
+	if err != nil {
+		return
+	}
+
+	m := mapperOf(e)
+
+	pkValue, pkZero := writeValue(m, sm.pkStructIndex)
+	if pkZero {
+		err = fmt.Errorf(f4, ErrZeroPrimaryKey, e.PkColumn())
+		return
+	}
+
+	o := &optExec{columns: sm.columns}
+	for _, opt := range options {
+		opt.applyToOptionExec(o)
+	}
+
+	nColumns := len(o.columns)
+	if nColumns == 0 {
+		// do nothing and return
+		return
+	}
+	ctx := db.newContext()
+	defer db.recycleContext(ctx)
+
+	table := e.TableName()
+	if len(o.table) > 0 {
+		table = o.table
+	}
+	ctx.WriteString("update ").
+		WriteQuotedString(table).
+		WriteString(" set ")
+
+	for _, column := range o.columns {
+		if column == e.PkColumn() {
+			// 主键字段放 where 子句里面，set 里面不用填
+			continue
+		}
+		fm, ok := sm.columnFieldMap[column]
+		if !ok {
+			// 没找到该列，说明调用时传入的 WithColumns 中列名可能写错了。
+			return fmt.Errorf(f5, ErrNoFieldForColumn, column)
+		}
+		value, zero := writeValue(m, fm.index)
+		write, null := o.writeMode(fm, zero)
+		if !write {
+			// 默认跳过〇值字段，见 optExec.writeMode
+			continue
+		}
+		if null {
+			value = nil
+		} else if value, err = db.encodeField(fm, value, zero); err != nil {
+			return
+		}
+		if len(ctx.args) > 0 {
+			ctx.WriteString(", ")
+		}
+		ctx.WriteQuotedString(column).
+			WriteString(" = ").
+			NextPlaceholder(value)
+	}
+	if len(ctx.args) == 0 {
+		// every column is skipped, nothing to update
+		return
+	}
+
+	ctx.WriteString(" where ").
+		WriteQuotedString(e.PkColumn()).
+		WriteString(" = ").
+		NextPlaceholder(pkValue)
+
+	_, err = x.RawExec(ctx.QueryString(), ctx.args...)
+	return
+}
+
+// save 实现了 Save，语句由 x 执行。
+func save(db *Database, x Executor, e IEntity, options []OptionExec) (err error) {
+	defer wrapError(&err, "save", tableOf(e))
+	sm, err := db.RegisterType(e)
+	if err != nil {
+		return err
+	}
+	if zeroField(mapperOf(e), sm.pkStructIndex) {
+		return insert(db, x, e, options)
+	}
+	return update(db, x, e, options)
+}
+
+// insertSelect 实现了 InsertSelect，语句由 x 执行。
+func insertSelect(db *Database, x Executor, table string, columns []string, options []OptionQueryMultiple) (err error) {
+	defer wrapError(&err, "insert", table)
+	q := &optQueryMultiple{}
+	for _, opt := range options {
+		opt.applyToOptionQueryMultiple(q)
+	}
+	if q.table.table == nil {
+		return ErrNoSourceTable
+	}
+	ctx := db.newContext()
+	defer db.recycleContext(ctx)
+	err = ctx.insertSelect(table, columns, q.optQuery)
+	if err != nil {
+		return
+	}
+	_, err = x.RawExec(ctx.QueryString(), ctx.args...)
+	return
+}
+
+// updateWhere 实现了 UpdateWhere，语句由 x 执行。
+func updateWhere(db *Database, x Executor, table string, options []OptionUpdate) (err error) {
+	defer wrapError(&err, "update", table)
+	o := &optUpdate{}
+	for _, opt := range options {
+		opt.applyToOptionUpdate(o)
+	}
+	scanner, err := db.returningScanner(&o.returning)
+	if err != nil {
+		return
+	}
+	ctx := db.newContext()
+	defer db.recycleContext(ctx)
+	err = ctx.update(table, o)
+	if err != nil {
+		return
+	}
+	if scanner != nil {
+		return rawQuery(x, true, ctx.QueryString(), scanner, ctx.args)
+	}
+	_, err = x.RawExec(ctx.QueryString(), ctx.args...)
+	return
+}
+
+// deleteWhere 实现了 DeleteWhere，语句由 x 执行。
+func deleteWhere(db *Database, x Executor, table string, options []OptionDelete) (err error) {
+	defer wrapError(&err, "delete", table)
+	o := &optDelete{}
+	for _, opt := range options {
+		opt.applyToOptionDelete(o)
+	}
+	scanner, err := db.returningScanner(&o.returning)
+	if err != nil {
+		return
+	}
+	ctx := db.newContext()
+	defer db.recycleContext(ctx)
+	err = ctx.delete(table, o)
+	if err != nil {
+		return
+	}
+	if scanner != nil {
+		return rawQuery(x, true, ctx.QueryString(), scanner, ctx.args)
+	}
+	_, err = x.RawExec(ctx.QueryString(), ctx.args...)
+	return
+}
@@ -0,0 +1,85 @@
+package sqlwrapper
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// userRepo 是仓储层的例子，通过 db.Executor 加入 ctx 携带的事务。
+type userRepo struct{ db *Database }
+
+func (r userRepo) Create(ctx context.Context, u *writeUser) error {
+	return r.db.Executor(ctx).Insert(u)
+}
+
+func TestExecutor(t *testing.T) {
+	f := newFakeDB(t)
+	db := f.open(t, "")
+	other := openFakeDatabase(t, "")
+	repo := userRepo{db}
+	ctx := context.Background()
+	if got := db.Executor(ctx); got != Executor(db) {
+		t.Errorf("got %T, want *Database", got)
+	}
+
+	step, err := db.RunTxContext(ctx, func(tx *Tx) (bool, error) {
+		ctx := tx.Context()
+		if got := db.Executor(ctx); got != Executor(tx) {
+			t.Errorf("got %T, want the ambient *Tx", got)
+		}
+		if got := other.Executor(ctx); got != Executor(other) {
+			t.Errorf("transaction of another database should be ignored, got %T", got)
+		}
+		if err := repo.Create(ctx, &writeUser{Name: "a"}); err != nil {
+			return false, err
+		}
+		// joins tx in a savepoint instead of beginning a new transaction
+		_, err := db.RunTxContext(ctx, func(nested *Tx) (bool, error) {
+			if nested != tx {
+				t.Error("nested RunTxContext should join the ambient transaction")
+			}
+			return false, repo.Create(ctx, &writeUser{Name: "b"})
+		}, nil)
+		return true, err
+	}, nil)
+	if commits, rollbacks := f.takeTxCounts(); step != StepEnd || err != nil || commits != 1 || rollbacks != 0 {
+		t.Fatalf("got %v %v, %d commits, %d rollbacks", step, err, commits, rollbacks)
+	}
+	got := []string{}
+	for _, e := range f.takeExecs() {
+		got = append(got, e.query)
+	}
+	want := []string{
+		"insert into user (name, active, note) values (?, ?, ?)",
+		"savepoint sp_1",
+		"insert into user (name, active, note) values (?, ?, ?)",
+		"rollback to savepoint sp_1",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	if _, err = db.Query(&scanUser{}, ForUpdate()); !errors.Is(err, ErrLockOutsideTx) {
+		t.Errorf("got %v, want %v", err, ErrLockOutsideTx)
+	}
+	db.RunTx(func(tx *Tx) (bool, error) {
+		if _, err := tx.Query(&scanUser{}, ForUpdate()); err != nil {
+			t.Error(err)
+		}
+		return false, nil
+	})
+}
+
+func TestInsertQuoted(t *testing.T) {
+	f := newFakeDB(t)
+	db := f.open(t, "", WithDialect(GetDialect("mysql")))
+	if err := db.Insert(&writeUser{Name: "a"}); err != nil {
+		t.Fatal(err)
+	}
+	want := "insert into `user` (`name`, `active`, `note`) values (?, ?, ?)"
+	if got := f.lastExec(t).query; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,13 @@
+package gen
+
+import "errors"
+
+// errors and error format texts
+
+var (
+	ErrTableNotExist = errors.New("table does not exist")
+)
+
+const (
+	f1 = "%w: '%s'"
+)
@@ -0,0 +1,235 @@
+// Package gen 根据数据库中已有的表生成 entity 结构体。
+//
+// 生成的结构体实现 sqlwrapper.IEntity；字段名经过 dialect 的 ColumnNameConverter 转换后
+// 与列名相同时不写 db tag，否则写上 db tag。
+package gen
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"io"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/FlyingOnion/pkg/sqlwrapper"
+)
+
+type generator struct {
+	pkg    string
+	tables []string
+}
+
+type Option func(g *generator)
+
+// WithPackage 指定生成代码的包名，默认为 entity。
+func WithPackage(name string) Option {
+	return func(g *generator) { g.pkg = name }
+}
+
+// WithTables 指定要生成的表，默认为当前 schema 中的所有表。
+func WithTables(tables ...string) Option {
+	return func(g *generator) { g.tables = append(g.tables, tables...) }
+}
+
+// Generate 读取 db 中的表结构，并将生成的 Go 代码写入 w。
+func Generate(db *sqlwrapper.Database, w io.Writer, options ...Option) error {
+	g := &generator{pkg: "entity"}
+	for _, opt := range options {
+		opt(g)
+	}
+	tables := g.tables
+	if len(tables) == 0 {
+		var err error
+		if tables, err = db.Tables(); err != nil {
+			return err
+		}
+		sort.Strings(tables)
+	}
+	infos := make([]*sqlwrapper.TableInfo, 0, len(tables))
+	for _, table := range tables {
+		info, err := db.TableInfo(table)
+		if err != nil {
+			return err
+		}
+		if !info.Exists {
+			return fmt.Errorf(f1, ErrTableNotExist, table)
+		}
+		infos = append(infos, info)
+	}
+	src, err := Source(g.pkg, db.Driver(), db.Dialect(), infos...)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(src)
+	return err
+}
+
+// Source 根据表结构生成格式化后的 Go 代码。
+// driver 用于判断列类型对应的 Go 类型（如 integer 在 sqlite 中是 64 位），通常为 db.Driver()；
+// converter 用于判断字段名能否转换回列名，通常为 db.Dialect()。
+func Source(pkg, driver string, converter sqlwrapper.ColumnNameConverter, tables ...*sqlwrapper.TableInfo) ([]byte, error) {
+	body := &bytes.Buffer{}
+	imports := map[string]bool{}
+	for _, info := range tables {
+		writeEntity(body, driver, converter, info, imports)
+	}
+
+	src := &bytes.Buffer{}
+	src.WriteString("// Code generated by sqlwrapper-gen. DO NOT EDIT.\n\n")
+	fmt.Fprintf(src, "package %s\n\n", pkg)
+	if len(imports) > 0 {
+		paths := make([]string, 0, len(imports))
+		for p := range imports {
+			paths = append(paths, p)
+		}
+		sort.Strings(paths)
+		src.WriteString("import (\n")
+		for _, p := range paths {
+			fmt.Fprintf(src, "\t%q\n", p)
+		}
+		src.WriteString(")\n\n")
+	}
+	src.Write(body.Bytes())
+	return format.Source(src.Bytes())
+}
+
+func writeEntity(w *bytes.Buffer, driver string, converter sqlwrapper.ColumnNameConverter, info *sqlwrapper.TableInfo, imports map[string]bool) {
+	name := goName(info.Name)
+	fmt.Fprintf(w, "// %s is mapped to table %s.\n", name, info.Name)
+	fmt.Fprintf(w, "type %s struct {\n", name)
+	used := map[string]int{}
+	for _, c := range info.Columns {
+		field := goName(c.Name)
+		if n := used[field]; n > 0 {
+			// two columns have the same go name, e.g. user_id and userId
+			used[field]++
+			field = fmt.Sprintf("%s%d", field, n+1)
+		} else {
+			used[field] = 1
+		}
+		typ, imp := goType(driver, c.Type)
+		if len(imp) > 0 {
+			imports[imp] = true
+		}
+		if c.Nullable {
+			typ = "*" + typ
+		}
+		fmt.Fprintf(w, "\t%s %s", field, typ)
+		if converter.Convert(field) != c.Name {
+			fmt.Fprintf(w, " `db:%q`", c.Name)
+		}
+		fmt.Fprintf(w, " // %s\n", c.Type)
+	}
+	w.WriteString("}\n\n")
+
+	pk := ""
+	if len(info.PrimaryKey) > 0 {
+		pk = info.PrimaryKey[0]
+	}
+	fmt.Fprintf(w, "func (%s) TableName() string { return %q }\n", name, info.Name)
+	if len(info.PrimaryKey) > 1 {
+		// composite primary keys are not supported by IEntity
+		fmt.Fprintf(w, "\n// PkColumn returns the first column of the composite primary key (%s).\n", strings.Join(info.PrimaryKey, ", "))
+	}
+	fmt.Fprintf(w, "func (%s) PkColumn() string { return %q }\n\n", name, pk)
+}
+
+// commonInitialisms 来自 golint，字段名中的这些单词全部大写。
+var commonInitialisms = map[string]bool{
+	"ACL": true, "API": true, "ASCII": true, "CPU": true, "CSS": true, "DNS": true,
+	"EOF": true, "GUID": true, "HTML": true, "HTTP": true, "HTTPS": true, "ID": true,
+	"IP": true, "JSON": true, "LHS": true, "QPS": true, "RAM": true, "RHS": true,
+	"RPC": true, "SLA": true, "SMTP": true, "SQL": true, "SSH": true, "TCP": true,
+	"TLS": true, "TTL": true, "UDP": true, "UI": true, "UID": true, "UUID": true,
+	"URI": true, "URL": true, "UTF8": true, "VM": true, "XML": true, "XMPP": true,
+	"XSRF": true, "XSS": true,
+}
+
+// goName 将表名或列名转换为导出的 Go 标识符，如 user_id -> UserID，USER_NAME -> UserName，
+// createdAt -> CreatedAt。
+func goName(s string) string {
+	words := strings.FieldsFunc(s, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	b := &strings.Builder{}
+	for _, word := range words {
+		if strings.ToUpper(word) == word {
+			// USER_NAME in oracle
+			word = strings.ToLower(word)
+		}
+		if upper := strings.ToUpper(word); commonInitialisms[upper] {
+			b.WriteString(upper)
+			continue
+		}
+		r := []rune(word)
+		r[0] = unicode.ToUpper(r[0])
+		b.WriteString(string(r))
+	}
+	name := b.String()
+	if len(name) == 0 || !unicode.IsLetter([]rune(name)[0]) {
+		name = "X" + name
+	}
+	return name
+}
+
+// goType 根据 driver 中列的 SQL 类型返回 Go 类型及需要导入的包。未知的类型使用 string。
+func goType(driver, sqlType string) (typ, imp string) {
+	t := strings.ToLower(strings.TrimSpace(sqlType))
+	unsigned := strings.Contains(t, "unsigned")
+	base, params, _ := strings.Cut(t, "(")
+	base = strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(base), "unsigned"))
+	u := ""
+	if unsigned {
+		u = "u"
+	}
+	switch base {
+	case "tinyint":
+		if strings.HasPrefix(params, "1)") {
+			// tinyint(1) is used as bool in mysql
+			return "bool", ""
+		}
+		return u + "int8", ""
+	case "smallint", "int2", "smallserial":
+		return u + "int16", ""
+	case "int", "int4", "mediumint", "serial", "integer":
+		switch driver {
+		case "sqlite", "sqlite3":
+			// integer columns are 64-bit in sqlite
+			return u + "int64", ""
+		case "oracle", "oci8":
+			if base == "integer" {
+				// INTEGER is NUMBER(38) in oracle
+				return "int64", ""
+			}
+		}
+		return u + "int32", ""
+	case "bigint", "int8", "bigserial":
+		return u + "int64", ""
+	case "bool", "boolean", "bit":
+		return "bool", ""
+	case "real", "float4":
+		return "float32", ""
+	case "float", "float8", "double", "double precision", "binary_float", "binary_double", "money", "smallmoney":
+		return "float64", ""
+	case "number":
+		// oracle NUMBER(p,0)
+		if strings.HasSuffix(params, ",0)") {
+			return "int64", ""
+		}
+		return "float64", ""
+	case "decimal", "numeric":
+		return "float64", ""
+	case "date", "datetime", "datetime2", "smalldatetime", "datetimeoffset", "time", "timestamp", "timestamptz",
+		"timestamp with time zone", "timestamp without time zone", "time with time zone", "time without time zone":
+		return "time.Time", "time"
+	case "blob", "tinyblob", "mediumblob", "longblob", "binary", "varbinary", "bytea", "raw", "long raw", "image":
+		return "[]byte", ""
+	}
+	if strings.HasPrefix(base, "timestamp") {
+		// oracle TIMESTAMP(6) WITH TIME ZONE
+		return "time.Time", "time"
+	}
+	return "string", ""
+}
@@ -0,0 +1,94 @@
+package gen
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/FlyingOnion/pkg/sqlwrapper"
+)
+
+func TestGoName(t *testing.T) {
+	for in, want := range map[string]string{
+		"user_id":    "UserID",
+		"USER_NAME":  "UserName",
+		"createdAt":  "CreatedAt",
+		"api_url":    "APIURL",
+		"1st_choice": "X1stChoice",
+	} {
+		if got := goName(in); got != want {
+			t.Errorf("goName(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestSource(t *testing.T) {
+	info := &sqlwrapper.TableInfo{
+		Name:   "user_accounts",
+		Exists: true,
+		Columns: []sqlwrapper.ColumnInfo{
+			{Name: "id", Type: "bigint unsigned"},
+			{Name: "user_name", Type: "varchar(64)"},
+			{Name: "api_url", Type: "text", Nullable: true},
+			{Name: "active", Type: "tinyint(1)"},
+			{Name: "created_at", Type: "datetime", Nullable: true},
+		},
+		PrimaryKey: []string{"id"},
+	}
+	src, err := Source("model", "mysql", sqlwrapper.GetDialect("mysql"), info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"package model",
+		`import (
+	"time"
+)`,
+		"type UserAccounts struct {",
+		"ID        uint64     // bigint unsigned",
+		"UserName  string     // varchar(64)",
+		"APIURL    *string    `db:\"api_url\"` // text",
+		"Active    bool       // tinyint(1)",
+		"CreatedAt *time.Time // datetime",
+		`func (UserAccounts) TableName() string { return "user_accounts" }`,
+		`func (UserAccounts) PkColumn() string  { return "id" }`,
+	} {
+		if !strings.Contains(string(src), want) {
+			t.Errorf("generated source does not contain %q:\n%s", want, src)
+		}
+	}
+}
+
+func TestSourceOracle(t *testing.T) {
+	info := &sqlwrapper.TableInfo{
+		Name:    "EMP",
+		Exists:  true,
+		Columns: []sqlwrapper.ColumnInfo{{Name: "EMP_NO", Type: "NUMBER(10,0)"}, {Name: "SALARY", Type: "NUMBER"}},
+	}
+	src, err := Source("entity", "oracle", sqlwrapper.GetDialect("oracle"), info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"EmpNo  int64", "Salary float64", `PkColumn() string  { return "" }`} {
+		if !strings.Contains(string(src), want) {
+			t.Errorf("generated source does not contain %q:\n%s", want, src)
+		}
+	}
+	if strings.Contains(string(src), "db:") {
+		t.Errorf("unexpected db tag:\n%s", src)
+	}
+}
+
+func TestGoTypeInteger(t *testing.T) {
+	for _, c := range []struct{ driver, sqlType, want string }{
+		{"sqlite", "INTEGER", "int64"},
+		{"sqlite3", "int", "int64"},
+		{"pgx", "integer", "int32"},
+		{"mysql", "integer unsigned", "uint32"},
+		{"sqlserver", "int", "int32"},
+		{"oracle", "INTEGER", "int64"},
+	} {
+		if got, _ := goType(c.driver, c.sqlType); got != c.want {
+			t.Errorf("%s %s: got %s, want %s", c.driver, c.sqlType, got, c.want)
+		}
+	}
+}
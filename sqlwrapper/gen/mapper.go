@@ -0,0 +1,214 @@
+package gen
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// nullTypes 是 database/sql 中可以用 == 比较〇值的类型。
+var nullTypes = map[string]bool{
+	"NullBool": true, "NullByte": true, "NullFloat64": true, "NullInt16": true,
+	"NullInt32": true, "NullInt64": true, "NullString": true, "NullTime": true,
+}
+
+type mapperField struct {
+	index int
+	name  string
+	// zero 是判断字段为〇值的表达式，%s 为字段。
+	zero string
+}
+
+// Mappers 解析 dir 中的 Go 文件（不包括测试文件），为 types 中的结构体生成 sqlwrapper.Mapper 的实现。
+//
+// 生成的代码只依赖字段在结构体中的位置，列名仍由 sqlwrapper 根据 tag 和 dialect 计算。
+func Mappers(dir string, types ...string) ([]byte, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	fset := token.NewFileSet()
+	pkgName, files := "", []*ast.File{}
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, ".go") || strings.HasSuffix(name, "_test.go") {
+			continue
+		}
+		f, err := parser.ParseFile(fset, filepath.Join(dir, name), nil, parser.ParseComments)
+		if err != nil {
+			return nil, err
+		}
+		if len(pkgName) > 0 && f.Name.Name != pkgName {
+			return nil, fmt.Errorf("found packages %s and %s in %s", pkgName, f.Name.Name, dir)
+		}
+		pkgName, files = f.Name.Name, append(files, f)
+	}
+
+	specs := map[string]*ast.TypeSpec{}
+	specImports := map[string]map[string]string{}
+	for _, f := range files {
+		imports := fileImports(f)
+		ast.Inspect(f, func(n ast.Node) bool {
+			if ts, ok := n.(*ast.TypeSpec); ok {
+				specs[ts.Name.Name], specImports[ts.Name.Name] = ts, imports
+			}
+			return true
+		})
+	}
+
+	body := &bytes.Buffer{}
+	imports := map[string]bool{"github.com/FlyingOnion/pkg/sqlwrapper": true}
+	for _, name := range types {
+		ts, ok := specs[name]
+		if !ok {
+			return nil, fmt.Errorf("type %s not found in %s", name, dir)
+		}
+		st, ok := ts.Type.(*ast.StructType)
+		if !ok || ts.TypeParams != nil {
+			return nil, fmt.Errorf("type %s is not a non-generic struct", name)
+		}
+		writeMapper(body, name, mapperFields(st, specImports[name], imports))
+	}
+
+	src := &bytes.Buffer{}
+	src.WriteString("// Code generated by sqlwrapper-mapper. DO NOT EDIT.\n\n")
+	fmt.Fprintf(src, "package %s\n\n", pkgName)
+	paths := make([]string, 0, len(imports))
+	for p := range imports {
+		paths = append(paths, p)
+	}
+	sort.Slice(paths, func(i, j int) bool {
+		if si, sj := isStd(paths[i]), isStd(paths[j]); si != sj {
+			return si
+		}
+		return paths[i] < paths[j]
+	})
+	src.WriteString("import (\n")
+	std := true
+	for _, p := range paths {
+		if std && !isStd(p) {
+			std = false
+			src.WriteByte('\n')
+		}
+		fmt.Fprintf(src, "\t%q\n", p)
+	}
+	src.WriteString(")\n\n")
+	src.Write(body.Bytes())
+	return format.Source(src.Bytes())
+}
+
+// isStd 判断是否为标准库的包，标准库包路径的第一段不包含点。
+func isStd(path string) bool {
+	return !strings.Contains(strings.Split(path, "/")[0], ".")
+}
+
+// fileImports 返回文件中导入的包名到包路径的映射。
+func fileImports(f *ast.File) map[string]string {
+	imports := map[string]string{}
+	for _, imp := range f.Imports {
+		path, _ := strconv.Unquote(imp.Path.Value)
+		name := path[strings.LastIndex(path, "/")+1:]
+		if imp.Name != nil {
+			name = imp.Name.Name
+		}
+		imports[name] = path
+	}
+	return imports
+}
+
+// mapperFields 返回结构体中 sqlwrapper 会读写的字段：跳过匿名字段和 db:"-" 的字段，与 RegisterType 一致。
+func mapperFields(st *ast.StructType, fileImports map[string]string, imports map[string]bool) []mapperField {
+	fields := []mapperField{}
+	index := 0
+	for _, field := range st.Fields.List {
+		if len(field.Names) == 0 {
+			// anonymous field
+			index++
+			continue
+		}
+		skip := false
+		if field.Tag != nil {
+			tag, _ := strconv.Unquote(field.Tag.Value)
+			skip = reflect.StructTag(tag).Get("db") == "-"
+		}
+		zero := zeroExpr(field.Type, fileImports, imports)
+		for _, name := range field.Names {
+			if !skip && name.Name != "_" {
+				fields = append(fields, mapperField{index: index, name: name.Name, zero: zero})
+			}
+			index++
+		}
+	}
+	return fields
+}
+
+// zeroExpr 返回判断字段为〇值的表达式，与 reflect.Value.IsZero 的结果一致。
+// 无法从语法上确定类型时使用 reflect.Value.IsZero。
+func zeroExpr(typ ast.Expr, fileImports map[string]string, imports map[string]bool) string {
+	switch t := typ.(type) {
+	case *ast.Ident:
+		switch t.Name {
+		case "int", "int8", "int16", "int32", "int64",
+			"uint", "uint8", "uint16", "uint32", "uint64", "uintptr",
+			"float32", "float64", "complex64", "complex128", "byte", "rune":
+			return "%s == 0"
+		case "string":
+			return `%s == ""`
+		case "bool":
+			return "!%s"
+		case "error", "any":
+			return "%s == nil"
+		}
+	case *ast.StarExpr, *ast.MapType, *ast.ChanType, *ast.FuncType, *ast.InterfaceType:
+		return "%s == nil"
+	case *ast.ArrayType:
+		if t.Len == nil {
+			return "%s == nil"
+		}
+	case *ast.SelectorExpr:
+		if x, ok := t.X.(*ast.Ident); ok {
+			// only packages imported with their default names, so that the generated file can use the same names
+			path := fileImports[x.Name]
+			if path == "time" && x.Name == "time" && t.Sel.Name == "Time" ||
+				path == "database/sql" && x.Name == "sql" && nullTypes[t.Sel.Name] {
+				imports[path] = true
+				return "%s == (" + x.Name + "." + t.Sel.Name + "{})"
+			}
+		}
+	}
+	imports["reflect"] = true
+	return "reflect.ValueOf(%s).IsZero()"
+}
+
+func writeMapper(w *bytes.Buffer, name string, fields []mapperField) {
+	fmt.Fprintf(w, "// FieldPtr implements sqlwrapper.Mapper.\n")
+	fmt.Fprintf(w, "func (e *%s) FieldPtr(index int) interface{} {\n\tswitch index {\n", name)
+	for _, f := range fields {
+		fmt.Fprintf(w, "\tcase %d:\n\t\treturn &e.%s\n", f.index, f.name)
+	}
+	w.WriteString("\t}\n\treturn nil\n}\n\n")
+
+	fmt.Fprintf(w, "// FieldValue implements sqlwrapper.Mapper.\n")
+	fmt.Fprintf(w, "func (e *%s) FieldValue(index int) (interface{}, bool) {\n\tswitch index {\n", name)
+	for _, f := range fields {
+		fmt.Fprintf(w, "\tcase %d:\n\t\treturn e.%s, %s\n", f.index, f.name, fmt.Sprintf(f.zero, "e."+f.name))
+	}
+	w.WriteString("\t}\n\treturn nil, true\n}\n\n")
+
+	fmt.Fprintf(w, "// NewMapper implements sqlwrapper.Mapper.\n")
+	fmt.Fprintf(w, "func (*%s) NewMapper() sqlwrapper.Mapper { return new(%s) }\n\n", name, name)
+
+	fmt.Fprintf(w, "// AppendTo implements sqlwrapper.Mapper.\n")
+	fmt.Fprintf(w, "func (e *%s) AppendTo(slice interface{}) bool {\n", name)
+	fmt.Fprintf(w, "\tswitch s := slice.(type) {\n\tcase *[]%s:\n\t\t*s = append(*s, *e)\n\tcase *[]*%s:\n\t\t*s = append(*s, e)\n", name, name)
+	w.WriteString("\tdefault:\n\t\treturn false\n\t}\n\treturn true\n}\n\n")
+}
@@ -0,0 +1,30 @@
+package gen
+
+import (
+	"os"
+	"testing"
+)
+
+func TestMappers(t *testing.T) {
+	src, err := Mappers("testdata/entity", "Employee")
+	if err != nil {
+		t.Fatal(err)
+	}
+	golden := "testdata/entity/sqlwrapper_mapper.go.golden"
+	if os.Getenv("UPDATE_GOLDEN") == "1" {
+		if err = os.WriteFile(golden, src, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want, err := os.ReadFile(golden)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(src) != string(want) {
+		t.Errorf("generated source differs from %s:\n%s", golden, src)
+	}
+
+	if _, err = Mappers("testdata/entity", "Base", "Missing"); err == nil {
+		t.Error("expect error for missing type")
+	}
+}
@@ -0,0 +1,25 @@
+package entity
+
+import (
+	"database/sql"
+	"time"
+)
+
+type Base struct{ Version int }
+
+type Employee struct {
+	ID          int64
+	Name, Email string
+	Base
+	Manager   *Employee
+	Tags      []string
+	Salary    sql.NullFloat64
+	Birthday  time.Time
+	Active    bool `db:"is_active"`
+	Scores    [3]int
+	password  string
+	Temporary string `db:"-"`
+}
+
+func (Employee) TableName() string { return "emp" }
+func (Employee) PkColumn() string  { return "id" }
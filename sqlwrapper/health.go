@@ -0,0 +1,109 @@
+package sqlwrapper
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// pingTimeout 使用 ctx Ping 数据库，timeout 为 0 时不限制时间。
+func pingTimeout(ctx context.Context, db *sql.DB, timeout time.Duration) error {
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	return db.PingContext(ctx)
+}
+
+// pingRetry 在初始化时 Ping 数据库，失败时按 p 重试，所有错误都会重试。
+func pingRetry(db *sql.DB, p *RetryPolicy, timeout time.Duration) error {
+	for attempt := 1; ; attempt++ {
+		err := pingTimeout(context.Background(), db, timeout)
+		if err == nil {
+			return nil
+		}
+		if attempt >= p.MaxAttempts {
+			if attempt > 1 {
+				err = fmt.Errorf(fc, attempt, err)
+			}
+			return err
+		}
+		if p.OnRetry != nil {
+			p.OnRetry(attempt, err)
+		}
+		time.Sleep(p.backoff(attempt))
+	}
+}
+
+// Healthy 返回主库最近一次健康检查是否成功，没有配置 WithHealthCheck 时总是返回 true。
+func (db *Database) Healthy() bool { return !db.unhealthy.Load() }
+
+// checkHealth Ping 主库，健康状态改变时调用 onChange。
+func (db *Database) checkHealth(timeout time.Duration, onChange func(healthy bool, err error)) {
+	err := pingTimeout(db.ctx, db.origin, timeout)
+	if db.ctx.Err() != nil {
+		// db is closed
+		return
+	}
+	if db.unhealthy.Swap(err != nil) != (err != nil) && onChange != nil {
+		onChange(err == nil, err)
+	}
+}
+
+// watchHealth 每隔 interval 检查一次主库，直到 db 关闭。
+func (db *Database) watchHealth(interval time.Duration, onChange func(healthy bool, err error)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-db.ctx.Done():
+			return
+		case <-ticker.C:
+			db.checkHealth(interval, onChange)
+		}
+	}
+}
+
+// counters 是 sqlwrapper 层面的统计数据，见 Stats。
+type counters struct {
+	queries, execs, errors      atomic.Int64
+	commits, rollbacks, retries atomic.Int64
+}
+
+// Stats 是 Database 的统计数据，在 sql.DBStats（主库连接池）的基础上增加了 sqlwrapper 的计数。
+type Stats struct {
+	sql.DBStats
+
+	Queries int64 // 执行的查询数，包括 Query、QueryMultiple、RawQuery 和带有 returning 的语句
+	Execs   int64 // 执行的 RawExec 和 Insert、Update 等写入语句数
+	Errors  int64 // 执行语句、开始和提交事务时出错的次数
+
+	TxCommits   int64 // 提交的事务数
+	TxRollbacks int64 // 回滚的事务数，包括被重试的事务和提交失败的事务
+	TxRetries   int64 // 事务重试的次数，见 WithTxRetry
+
+	// Replicas 是各个从库连接池的统计数据，顺序与 WithReplicas 相同
+	Replicas []sql.DBStats
+}
+
+// Stats 返回 db 的统计数据。
+func (db *Database) Stats() Stats {
+	s := Stats{
+		DBStats:     db.origin.Stats(),
+		Queries:     db.stats.queries.Load(),
+		Execs:       db.stats.execs.Load(),
+		Errors:      db.stats.errors.Load(),
+		TxCommits:   db.stats.commits.Load(),
+		TxRollbacks: db.stats.rollbacks.Load(),
+		TxRetries:   db.stats.retries.Load(),
+	}
+	if db.replicas != nil {
+		for _, r := range db.replicas.replicas {
+			s.Replicas = append(s.Replicas, r.origin.Stats())
+		}
+	}
+	return s
+}
@@ -0,0 +1,70 @@
+package sqlwrapper
+
+import (
+	"database/sql/driver"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestPingRetry(t *testing.T) {
+	f := newFakeDB(t)
+	f.setDown("down", true)
+	var retries []int
+	_, err := NewDatabase("sqlwrapper-fake", f.dsn("down"), WithPingRetry(RetryPolicy{
+		MaxAttempts: 3,
+		Backoff:     time.Millisecond,
+		OnRetry:     func(attempt int, err error) { retries = append(retries, attempt) },
+	}), WithPingTimeout(time.Second))
+	if !errors.Is(err, driver.ErrBadConn) || len(retries) != 2 {
+		t.Errorf("got %v, %d retries", err, len(retries))
+	}
+
+	db := f.open(t, "down", NoPing(), WithMaxOpenConns(3), WithConnMaxLifetime(time.Minute))
+	if n := db.Stats().MaxOpenConnections; n != 3 {
+		t.Errorf("got %d max open connections, want 3", n)
+	}
+}
+
+func TestHealthCheck(t *testing.T) {
+	f := newFakeDB(t)
+	db := f.open(t, "health")
+	var changes []bool
+	onChange := func(healthy bool, err error) {
+		if healthy != (err == nil) {
+			t.Errorf("healthy %v with error %v", healthy, err)
+		}
+		changes = append(changes, healthy)
+	}
+	for _, down := range []bool{false, true, true, false} {
+		f.setDown("health", down)
+		db.checkHealth(time.Second, onChange)
+		if db.Healthy() == down {
+			t.Errorf("got healthy %v, want %v", db.Healthy(), !down)
+		}
+	}
+	if len(changes) != 2 || changes[0] || !changes[1] {
+		t.Errorf("got changes %v, want [false true]", changes)
+	}
+}
+
+func TestStats(t *testing.T) {
+	f := newFakeDB(t)
+	db := f.open(t, "")
+	db.Query(&scanUser{})
+	db.Insert(&writeUser{ID: 1, Name: "a"})
+	f.setExecError(errors.New("exec failed"))
+	db.Insert(&writeUser{ID: 1, Name: "a"})
+	f.setExecError(nil)
+	db.RunTx(func(tx *Tx) (bool, error) {
+		tx.QueryMultiple(&[]scanUser{})
+		return true, nil
+	})
+	db.RunTx(func(tx *Tx) (bool, error) { return false, nil })
+
+	s := db.Stats()
+	got := [...]int64{s.Queries, s.Execs, s.Errors, s.TxCommits, s.TxRollbacks, s.TxRetries}
+	if want := [...]int64{2, 2, 1, 1, 1, 0}; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,106 @@
+package sqlwrapper
+
+import (
+	"database/sql/driver"
+	"reflect"
+)
+
+// Mapper 是读写 entity 字段的方法，用于代替读写字段时的反射，通常由 sqlwrapper-mapper 生成：
+//
+//	//go:generate sqlwrapper-mapper -type Employee,Dept
+//
+// Query、QueryMultiple、Insert、Update 和 Save 检测到 entity 指针实现了 Mapper 时使用这些方法，
+// 否则使用反射。index 是字段在结构体中的位置（与 reflect.Type.Field 的参数相同），
+// 列名到字段的映射仍由 RegisterType 根据 tag 和 dialect 计算，因此生成的代码与数据库无关。
+// 值的转换（ValueConverter）和 Insert 回填主键等仍然使用反射。
+type Mapper interface {
+	// FieldPtr 返回第 index 个字段的指针，没有该字段时返回 nil。
+	FieldPtr(index int) interface{}
+
+	// FieldValue 返回第 index 个字段的值，以及该值是否为〇值。
+	FieldValue(index int) (value interface{}, zero bool)
+
+	// NewMapper 返回一个新的同类型 entity 的指针。
+	NewMapper() Mapper
+
+	// AppendTo 将 entity 追加到 slice 中，slice 必须是 *[]T 或 *[]*T，否则返回 false。
+	AppendTo(slice interface{}) bool
+}
+
+// reflectMapper 使用反射实现 Mapper，用于没有实现 Mapper 的 entity。
+type reflectMapper struct {
+	// ptr 是结构体的指针，entity 不是指针时为无效值。
+	ptr reflect.Value
+	v   reflect.Value
+}
+
+// mapperOf 返回 entity 的 Mapper，entity 没有实现 Mapper 时使用反射。
+func mapperOf(entity interface{}) Mapper {
+	if m, ok := entity.(Mapper); ok {
+		return m
+	}
+	v := reflect.ValueOf(entity)
+	if v.Kind() == reflect.Ptr {
+		return &reflectMapper{ptr: v, v: v.Elem()}
+	}
+	return &reflectMapper{v: v}
+}
+
+func (m *reflectMapper) FieldPtr(index int) interface{} {
+	if !m.ptr.IsValid() || index < 0 || index >= m.v.NumField() {
+		return nil
+	}
+	return m.v.Field(index).Addr().Interface()
+}
+
+func (m *reflectMapper) FieldValue(index int) (interface{}, bool) {
+	f := m.v.Field(index)
+	return f.Interface(), f.IsZero()
+}
+
+func (m *reflectMapper) NewMapper() Mapper {
+	ptr := reflect.New(m.v.Type())
+	return &reflectMapper{ptr: ptr, v: ptr.Elem()}
+}
+
+func (m *reflectMapper) AppendTo(slice interface{}) bool {
+	s := reflect.ValueOf(slice)
+	if s.Kind() != reflect.Ptr || s.Elem().Kind() != reflect.Slice {
+		return false
+	}
+	s = s.Elem()
+	switch elemType := s.Type().Elem(); {
+	case elemType == m.v.Type():
+		s.Set(reflect.Append(s, m.v))
+	case m.ptr.IsValid() && elemType == m.ptr.Type():
+		s.Set(reflect.Append(s, m.ptr))
+	default:
+		return false
+	}
+	return true
+}
+
+func zeroField(m Mapper, index int) bool {
+	_, zero := m.FieldValue(index)
+	return zero
+}
+
+// writeValue 返回第 index 个字段写入数据库的值，以及该值是否为〇值。nil 指针返回 nil。
+// 字段本身没有实现 driver.Valuer 而字段的指针实现了（Value 方法的接收者是指针）时，返回字段的指针，
+// 由 database/sql 调用 Value 方法。
+func writeValue(m Mapper, index int) (value interface{}, zero bool) {
+	value, zero = m.FieldValue(index)
+	if zero {
+		// nil pointers are written as NULL, without calling Value on them
+		if v := reflect.ValueOf(value); v.Kind() == reflect.Ptr && v.IsNil() {
+			return nil, true
+		}
+	}
+	if _, ok := value.(driver.Valuer); ok || value == nil {
+		return
+	}
+	if p, ok := m.FieldPtr(index).(driver.Valuer); ok {
+		value = p
+	}
+	return
+}
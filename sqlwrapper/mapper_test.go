@@ -0,0 +1,36 @@
+package sqlwrapper
+
+import "testing"
+
+type mapperUser struct {
+	ID   int64
+	Name string
+}
+
+func TestReflectMapper(t *testing.T) {
+	u := &mapperUser{Name: "foo"}
+	m := mapperOf(u)
+	if v, zero := m.FieldValue(0); v != int64(0) || !zero {
+		t.Errorf("FieldValue(0) = %v, %v", v, zero)
+	}
+	if v, zero := m.FieldValue(1); v != "foo" || zero {
+		t.Errorf("FieldValue(1) = %v, %v", v, zero)
+	}
+	*(m.FieldPtr(0).(*int64)) = 1
+	if u.ID != 1 {
+		t.Errorf("FieldPtr(0) does not point to the field")
+	}
+	if mapperOf(*u).FieldPtr(0) != nil {
+		t.Error("FieldPtr of a non-pointer entity should be nil")
+	}
+
+	n := m.NewMapper()
+	*(n.FieldPtr(1).(*string)) = "bar"
+	values, pointers := []mapperUser{}, []*mapperUser{}
+	if !n.AppendTo(&values) || !n.AppendTo(&pointers) || n.AppendTo(&[]string{}) {
+		t.Fatal("unexpected AppendTo result")
+	}
+	if values[0].Name != "bar" || pointers[0].Name != "bar" {
+		t.Errorf("got %+v and %+v", values, pointers[0])
+	}
+}
@@ -1,6 +1,7 @@
 package sqlwrapper
 
 import (
+	"fmt"
 	"reflect"
 )
 
@@ -13,6 +14,9 @@ type structMeta struct {
 	// 结构体字段数。
 	nFields int
 
+	// 结构体是 IEntity 时为 TableName 的结果，用于错误信息。
+	table string
+
 	// 主键对应的字段在结构体中的位置，从 0 开始。在调用 RegisterType 分析结构体时获取。
 	//
 	// 结构体字段（如果有 tag 的话）的 tag 名或（如果没有 tag 的话）经过转换的字段名如果与 entity 的 PkColumn 结果一致，就认定该字段为主键字段，并将该字段的 Index 赋值给 pkStructIndex。
@@ -37,6 +41,8 @@ type structMeta struct {
 type fieldMeta struct {
 	index  int
 	column string
+	typ    reflect.Type
+	tag    tagOptions
 }
 
 // 注册类型信息，entity 必须是结构体或结构体指针。
@@ -60,9 +66,9 @@ func (db *Database) RegisterType(entity interface{}) (*structMeta, error) {
 	cols := make([]string, 0, nFields)
 	cfmap := make(map[string]*fieldMeta, nFields)
 
-	pkColumn := ""
+	pkColumn, table := "", ""
 	if iEntity, ok := entity.(IEntity); ok {
-		pkColumn = iEntity.PkColumn()
+		pkColumn, table = iEntity.PkColumn(), iEntity.TableName()
 	}
 	pkStructIndex := -1
 	pkType := reflect.Type(nil)
@@ -74,11 +80,15 @@ func (db *Database) RegisterType(entity interface{}) (*structMeta, error) {
 		if field.Anonymous {
 			continue
 		}
-		colName := field.Tag.Get("db")
+		tag := field.Tag.Get("db")
 		// 跳过 db:"-" 的字段
-		if colName == "-" {
+		if tag == "-" {
 			continue
 		}
+		colName, opts, err := parseTag(tag)
+		if err != nil {
+			return nil, fmt.Errorf(fd, err, t.Name(), field.Name)
+		}
 		if len(colName) == 0 {
 			// 如果 tag db 是空字符串，则使用 dialect 的转换方法将字段名转换为数据库列名。
 			colName = db.dialect.Convert(t.Field(i).Name)
@@ -111,12 +121,15 @@ func (db *Database) RegisterType(entity interface{}) (*structMeta, error) {
 		fm := &fieldMeta{
 			index:  i,
 			column: colName,
+			typ:    field.Type,
+			tag:    opts,
 		}
 		cfmap[colName] = fm
 	}
 	// 构建结构体的元数据，添加到 metadata
 	sm := &structMeta{
 		nFields:        nFields,
+		table:          table,
 		pkStructIndex:  pkStructIndex,
 		pkType:         pkType,
 		columns:        cols,
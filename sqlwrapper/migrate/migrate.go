@@ -0,0 +1,517 @@
+// Package migrate runs ordered schema migrations with sqlwrapper.
+//
+// Applied versions are recorded in a table (schema_migrations by default).
+// Each migration runs in its own transaction together with the record, and a
+// lock table prevents concurrent runs from multiple processes.
+//
+//	m := migrate.New(db)
+//	ms, err := migrate.Files(migrations, "migrations")
+//	if err != nil {
+//	    return err
+//	}
+//	err = m.Add(ms...)
+//	if err != nil {
+//	    return err
+//	}
+//	err = m.Up()
+//
+// Note that MySQL and Oracle commit DDL statements implicitly, so a failed
+// migration may leave part of its statements applied on these databases.
+package migrate
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/FlyingOnion/pkg/sqlwrapper"
+)
+
+var (
+	ErrLocked = errors.New("migration is locked by another process")
+	// ErrLockLost 表示迁移期间锁续期失败，锁可能已经被删除或被其他进程接管，迁移会停止。
+	ErrLockLost = errors.New("migration lock is lost")
+)
+
+const (
+	fDuplicateVersion  = "duplicate migration version %d"
+	fNoUpMigration     = "migration %d has no up migration"
+	fNoDownMigration   = "migration %d has no down migration"
+	fMigrationNotFound = "applied migration %d is not found"
+	fChecksumMismatch  = "checksum of migration %d mismatches the applied one"
+	fMigrationFailed   = "migration %d (%s) failed: %w"
+	fInvalidFilename   = "invalid migration filename %s: %w"
+	fLocked            = "%w: %w"
+)
+
+const (
+	DefaultTable = "schema_migrations"
+	// DefaultLockLease 是迁移锁的默认租期，见 WithLockLease。
+	DefaultLockLease = time.Minute
+
+	lockRetryInterval = 500 * time.Millisecond
+)
+
+// Migration 是一个版本的迁移。Up 和 UpSQL 二选一，Down 和 DownSQL 二选一，优先使用 Go 函数。
+//
+// UpSQL 和 DownSQL 可以包含多条以分号结尾的语句，它们会在同一个事务中逐条执行。
+type Migration struct {
+	Version uint64
+	Name    string
+
+	Up   func(tx *sqlwrapper.Tx) error
+	Down func(tx *sqlwrapper.Tx) error
+
+	UpSQL   string
+	DownSQL string
+}
+
+type Migrator struct {
+	db          *sqlwrapper.Database
+	table       string
+	txOptions   *sql.TxOptions
+	lockTimeout time.Duration
+	lockLease   time.Duration
+	dryRun      io.Writer
+
+	// migrations are sorted by version
+	migrations []Migration
+}
+
+// record 是迁移版本表中的一行。
+type record struct {
+	VersionID   int64
+	Description sql.NullString
+	Checksum    sql.NullString
+	AppliedAt   sql.NullTime
+}
+
+func New(db *sqlwrapper.Database, options ...Option) *Migrator {
+	m := &Migrator{
+		db:          db,
+		table:       DefaultTable,
+		lockTimeout: time.Minute,
+		lockLease:   DefaultLockLease,
+	}
+	for _, opt := range options {
+		opt(m)
+	}
+	return m
+}
+
+// Add 添加迁移。版本号不能重复。
+func (m *Migrator) Add(migrations ...Migration) error {
+	for _, mg := range migrations {
+		if mg.Up == nil && len(mg.UpSQL) == 0 {
+			return fmt.Errorf(fNoUpMigration, mg.Version)
+		}
+		if m.find(mg.Version) != nil {
+			return fmt.Errorf(fDuplicateVersion, mg.Version)
+		}
+		m.migrations = append(m.migrations, mg)
+		sort.Slice(m.migrations, func(i, j int) bool {
+			return m.migrations[i].Version < m.migrations[j].Version
+		})
+	}
+	return nil
+}
+
+// Up 执行所有未执行的迁移。
+func (m *Migrator) Up() error { return m.UpTo(math.MaxUint64) }
+
+// UpTo 执行版本号不大于 version 的所有未执行的迁移。
+func (m *Migrator) UpTo(version uint64) error {
+	return m.withLock(func(ctx context.Context, applied map[uint64]record) error {
+		for i := range m.migrations {
+			mg := &m.migrations[i]
+			if mg.Version > version {
+				break
+			}
+			if _, ok := applied[mg.Version]; ok {
+				continue
+			}
+			if err := m.run(ctx, mg, true); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Down 回滚最后一个已执行的迁移。
+func (m *Migrator) Down() error { return m.down(0, 1) }
+
+// DownTo 回滚版本号大于 version 的所有已执行的迁移。
+func (m *Migrator) DownTo(version uint64) error { return m.down(version, -1) }
+
+func (m *Migrator) down(version uint64, steps int) error {
+	return m.withLock(func(ctx context.Context, applied map[uint64]record) error {
+		versions := make([]uint64, 0, len(applied))
+		for v := range applied {
+			if v > version {
+				versions = append(versions, v)
+			}
+		}
+		sort.Slice(versions, func(i, j int) bool { return versions[i] > versions[j] })
+		if steps >= 0 && len(versions) > steps {
+			versions = versions[:steps]
+		}
+		for _, v := range versions {
+			mg := m.find(v)
+			if mg == nil {
+				return fmt.Errorf(fMigrationNotFound, v)
+			}
+			if mg.Down == nil && len(mg.DownSQL) == 0 {
+				return fmt.Errorf(fNoDownMigration, v)
+			}
+			if err := m.run(ctx, mg, false); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Unlock 强制释放迁移锁，不论锁属于哪个进程。持有锁的进程异常退出后，锁在租期（见 WithLockLease）过后会被其他进程自动接管；
+// 没有租期时只能用 Unlock 释放。
+//
+// 锁被强制释放后，原来持有锁的进程在下次续期时发现锁已丢失，停止迁移并返回 ErrLockLost。
+func (m *Migrator) Unlock() error {
+	return m.db.DeleteWhere(m.lockTable(), sqlwrapper.Where("lock_id = ?", 1))
+}
+
+// unlock 释放 owner 持有的锁。锁已经不属于 owner 时不做任何事。
+func (m *Migrator) unlock(owner string) error {
+	return m.db.DeleteWhere(m.lockTable(), sqlwrapper.Where("lock_id = ? and owner = ?", 1, owner))
+}
+
+func (m *Migrator) find(version uint64) *Migration {
+	for i := range m.migrations {
+		if m.migrations[i].Version == version {
+			return &m.migrations[i]
+		}
+	}
+	return nil
+}
+
+func (m *Migrator) lockTable() string { return m.table + "_lock" }
+
+// withLock 获取迁移锁，读取并校验已执行的迁移后执行 fn。锁续期失败时 fn 的 ctx 被取消，原因为续期返回的错误。
+//
+// dry run 时不会建表和加锁；版本表不存在时视为没有已执行的迁移。
+func (m *Migrator) withLock(fn func(ctx context.Context, applied map[uint64]record) error) (err error) {
+	ctx := context.Background()
+	if m.dryRun == nil {
+		err = m.createTables()
+		if err != nil {
+			return
+		}
+		var owner string
+		owner, err = m.lock()
+		if err != nil {
+			return
+		}
+		var cancel context.CancelCauseFunc
+		ctx, cancel = context.WithCancelCause(ctx)
+		stop := m.keepLock(owner, cancel)
+		defer func() {
+			stop()
+			cancel(nil)
+			if err1 := m.unlock(owner); err == nil {
+				err = err1
+			}
+		}()
+	}
+	applied, err := m.applied()
+	if err != nil {
+		if m.dryRun == nil {
+			return
+		}
+		applied, err = map[uint64]record{}, nil
+	}
+	err = m.verify(applied)
+	if err != nil {
+		return
+	}
+	return fn(ctx, applied)
+}
+
+// lock 获取迁移锁，返回写入锁的 owner，之后只能用 owner 续期和释放。
+//
+// 锁已被其他进程持有时（插入违反主键约束，见 sqlwrapper.UniqueViolation）重试直到超时，其他错误立即返回。
+func (m *Migrator) lock() (string, error) {
+	owner, err := newOwner()
+	if err != nil {
+		return "", err
+	}
+	ctx := sqlwrapper.NewContext(m.db.Driver(), m.db.Dialect())
+	ctx.WriteString("insert into ").
+		WriteQuotedString(m.lockTable()).
+		WriteString(" (lock_id, owner, locked_at) values (").
+		NextPlaceholder(1).
+		WriteString(", ").
+		NextPlaceholder(owner).
+		WriteString(", ").
+		NextPlaceholder(time.Now()).
+		WriteByte(')')
+
+	deadline := time.Now().Add(m.lockTimeout)
+	for {
+		// the insert fails if the row exists, i.e. another process holds the lock
+		_, err = m.db.RawExec(ctx.QueryString(), ctx.Arguments()...)
+		if err == nil {
+			return owner, nil
+		}
+		if sqlwrapper.KindOf(err) != sqlwrapper.UniqueViolation {
+			return "", err
+		}
+		expired, err1 := m.expire()
+		if err1 != nil {
+			return "", err1
+		}
+		if expired {
+			// the lock of a crashed process is released, try again at once
+			continue
+		}
+		if !time.Now().Before(deadline) {
+			return "", fmt.Errorf(fLocked, ErrLocked, err)
+		}
+		time.Sleep(lockRetryInterval)
+	}
+}
+
+// newOwner 返回一个随机的 owner，用于区分持有锁的进程。
+func newOwner() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// expire 删除租期已过的锁，返回是否删除了锁。持有锁的进程会定期续期（见 keepLock），所以过期的锁属于异常退出的进程。
+func (m *Migrator) expire() (bool, error) {
+	if m.lockLease <= 0 {
+		return false, nil
+	}
+	ctx := sqlwrapper.NewContext(m.db.Driver(), m.db.Dialect())
+	ctx.WriteString("delete from ").
+		WriteQuotedString(m.lockTable()).
+		WriteString(" where lock_id = ").
+		NextPlaceholder(1).
+		WriteString(" and locked_at < ").
+		NextPlaceholder(time.Now().Add(-m.lockLease))
+	result, err := m.db.RawExec(ctx.QueryString(), ctx.Arguments()...)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+// keepLock 在持有锁期间每隔租期的 1/3 更新 owner 持有的锁的 locked_at，使锁不会过期。返回停止续期的函数。
+// 续期失败时停止续期，并以续期返回的错误调用 lost。
+func (m *Migrator) keepLock(owner string, lost context.CancelCauseFunc) (stop func()) {
+	if m.lockLease <= 0 {
+		return func() {}
+	}
+	done, stopped := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(m.lockLease / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				if err := m.renew(owner); err != nil {
+					lost(err)
+					return
+				}
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		<-stopped
+	}
+}
+
+// renew 更新 owner 持有的锁的 locked_at。没有更新任何行时说明锁已经被删除或被其他进程接管，返回 ErrLockLost。
+func (m *Migrator) renew(owner string) error {
+	ctx := sqlwrapper.NewContext(m.db.Driver(), m.db.Dialect())
+	ctx.WriteString("update ").
+		WriteQuotedString(m.lockTable()).
+		WriteString(" set locked_at = ").
+		NextPlaceholder(time.Now()).
+		WriteString(" where lock_id = ").
+		NextPlaceholder(1).
+		WriteString(" and owner = ").
+		NextPlaceholder(owner)
+	result, err := m.db.RawExec(ctx.QueryString(), ctx.Arguments()...)
+	if err != nil {
+		return fmt.Errorf(fLocked, ErrLockLost, err)
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf(fLocked, ErrLockLost, err)
+	}
+	if n == 0 {
+		return ErrLockLost
+	}
+	return nil
+}
+
+func (m *Migrator) applied() (map[uint64]record, error) {
+	records := []record{}
+	// read from the primary, a replica may lag behind the migrations just applied
+	err := m.db.Primary().QueryMultiple(&records, sqlwrapper.From(sqlwrapper.Table(m.table)))
+	if err != nil {
+		return nil, err
+	}
+	applied := make(map[uint64]record, len(records))
+	for _, r := range records {
+		applied[uint64(r.VersionID)] = r
+	}
+	return applied, nil
+}
+
+// verify 校验已执行的 SQL 迁移的校验值，防止迁移文件执行后被修改。
+func (m *Migrator) verify(applied map[uint64]record) error {
+	for i := range m.migrations {
+		mg := &m.migrations[i]
+		r, ok := applied[mg.Version]
+		if !ok || !r.Checksum.Valid {
+			continue
+		}
+		if sum := checksum(mg); len(sum) > 0 && sum != r.Checksum.String {
+			return fmt.Errorf(fChecksumMismatch, mg.Version)
+		}
+	}
+	return nil
+}
+
+// run 执行一个迁移。ctx 被取消（锁已丢失）时不再执行，正在执行的事务回滚。
+func (m *Migrator) run(ctx context.Context, mg *Migration, up bool) error {
+	fn, script, direction := mg.Up, mg.UpSQL, "up"
+	if !up {
+		fn, script, direction = mg.Down, mg.DownSQL, "down"
+	}
+
+	if m.dryRun != nil {
+		if fn != nil {
+			_, err := fmt.Fprintf(m.dryRun, "-- %d %s (%s, go function)\n", mg.Version, mg.Name, direction)
+			return err
+		}
+		_, err := fmt.Fprintf(m.dryRun, "-- %d %s (%s)\n", mg.Version, mg.Name, direction)
+		if err != nil {
+			return err
+		}
+		for _, stmt := range splitStatements(script) {
+			_, err = fmt.Fprintf(m.dryRun, "%s;\n", stmt)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if err := context.Cause(ctx); err != nil {
+		return err
+	}
+	_, err := m.db.RunTxContext(ctx, func(tx *sqlwrapper.Tx) (bool, error) {
+		var err error
+		if fn != nil {
+			err = fn(tx)
+		} else {
+			for _, stmt := range splitStatements(script) {
+				_, err = tx.RawExec(stmt)
+				if err != nil {
+					break
+				}
+			}
+		}
+		if err != nil {
+			return false, err
+		}
+		if up {
+			err = m.insertRecord(tx, mg)
+		} else {
+			err = tx.DeleteWhere(m.table, sqlwrapper.Where("version_id = ?", int64(mg.Version)))
+		}
+		return err == nil, err
+	}, m.txOptions)
+	if err != nil && ctx.Err() != nil {
+		err = context.Cause(ctx)
+	}
+	if err != nil {
+		return fmt.Errorf(fMigrationFailed, mg.Version, mg.Name, err)
+	}
+	return nil
+}
+
+func (m *Migrator) insertRecord(tx *sqlwrapper.Tx, mg *Migration) error {
+	ctx := sqlwrapper.NewContext(m.db.Driver(), m.db.Dialect())
+	ctx.WriteString("insert into ").
+		WriteQuotedString(m.table).
+		WriteString(" (version_id, description, checksum, applied_at) values (").
+		NextPlaceholder(int64(mg.Version)).
+		WriteString(", ").
+		NextPlaceholder(mg.Name).
+		WriteString(", ").
+		NextPlaceholder(checksum(mg)).
+		WriteString(", ").
+		NextPlaceholder(time.Now()).
+		WriteByte(')')
+	_, err := tx.RawExec(ctx.QueryString(), ctx.Arguments()...)
+	return err
+}
+
+// createTables 创建版本表和锁表（如果不存在）。
+func (m *Migrator) createTables() error {
+	bigint, varchar, timestamp := "bigint", "varchar", "timestamp"
+	switch m.db.Driver() {
+	case "mysql":
+		timestamp = "datetime"
+	case "mssql", "sqlserver":
+		varchar, timestamp = "nvarchar", "datetime2"
+	case "oci8", "oracle":
+		bigint, varchar = "number(19)", "varchar2"
+	}
+	stmts := []string{
+		m.createTable(m.table, "version_id "+bigint+" primary key, "+
+			"description "+varchar+"(255), "+
+			"checksum "+varchar+"(64), "+
+			"applied_at "+timestamp+" not null"),
+		m.createTable(m.lockTable(), "lock_id int primary key, "+
+			"owner "+varchar+"(64) not null, "+
+			"locked_at "+timestamp+" not null"),
+	}
+	for _, stmt := range stmts {
+		if _, err := m.db.RawExec(stmt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *Migrator) createTable(table, columns string) string {
+	quoted := m.db.Quote(table)
+	switch m.db.Driver() {
+	case "mssql", "sqlserver":
+		return "if object_id(N'" + table + "', N'U') is null create table " + quoted + " (" + columns + ")"
+	case "oci8", "oracle":
+		// ORA-00955: name is already used by an existing object
+		return "begin execute immediate 'create table " + quoted + " (" + columns + ")'; " +
+			"exception when others then if sqlcode != -955 then raise; end if; end;"
+	}
+	return "create table if not exists " + quoted + " (" + columns + ")"
+}
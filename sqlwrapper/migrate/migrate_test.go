@@ -0,0 +1,334 @@
+package migrate
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/FlyingOnion/pkg/sqlwrapper"
+	"github.com/FlyingOnion/pkg/sqlwrapper/sqlwrappertest"
+)
+
+var (
+	createA = Migration{Version: 1, Name: "create a", UpSQL: "create table a (id int);", DownSQL: "drop table a;"}
+	createB = Migration{Version: 2, Name: "create b", UpSQL: "create table b (id int);", DownSQL: "drop table b;"}
+)
+
+// replica 是 TestMigratorWithReplicas 中从库使用的 Mock。从库用 driver 名打开，所以以 replicaDriver 注册。
+var replica = sqlwrappertest.New("sqlite")
+
+const replicaDriver = "migrate-replica-fake"
+
+func init() { sql.Register(replicaDriver, replica.Driver()) }
+
+func newMigrator(t *testing.T, options ...Option) (*Migrator, *sqlwrappertest.Mock) {
+	t.Helper()
+	mock := sqlwrappertest.New("sqlite")
+	db, err := mock.NewDatabase()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return newMigratorWithDB(t, db, options...), mock
+}
+
+func newMigratorWithDB(t *testing.T, db *sqlwrapper.Database, options ...Option) *Migrator {
+	t.Helper()
+	t.Cleanup(func() { db.Close() })
+	m := New(db, options...)
+	if err := m.Add(createA, createB); err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+// expectLock 预期建表和加锁。
+func expectLock(mock *sqlwrappertest.Mock) {
+	mock.ExpectExec(sqlwrappertest.Regexp(`^create table if not exists schema_migrations \(`))
+	mock.ExpectExec(sqlwrappertest.Regexp(`^create table if not exists schema_migrations_lock \(`))
+	mock.ExpectExec(sqlwrappertest.SQL("insert into schema_migrations_lock (lock_id, owner, locked_at) values (?, ?, ?)")).
+		WithArgs(1, sqlwrappertest.AnyArg(), sqlwrappertest.AnyArg())
+}
+
+// expectApplied 预期查询已执行的迁移，返回 applied 中的迁移。
+func expectApplied(mock *sqlwrappertest.Mock, applied ...Migration) {
+	rows := sqlwrappertest.NewRows("version_id", "description", "checksum", "applied_at")
+	for _, mg := range applied {
+		rows.AddRow(int64(mg.Version), mg.Name, checksum(&mg), time.Now())
+	}
+	mock.ExpectQuery(sqlwrappertest.SQL("select version_id, description, checksum, applied_at from schema_migrations")).
+		WillReturnRows(rows)
+}
+
+func expectUp(mock *sqlwrappertest.Mock, mg Migration) {
+	mock.ExpectBegin()
+	mock.ExpectExec(sqlwrappertest.Exact(strings.TrimSuffix(mg.UpSQL, ";")))
+	mock.ExpectExec(sqlwrappertest.SQL("insert into schema_migrations (version_id, description, checksum, applied_at) values (?, ?, ?, ?)")).
+		WithArgs(int64(mg.Version), mg.Name, checksum(&mg), sqlwrappertest.AnyArg())
+	mock.ExpectCommit()
+}
+
+func expectDown(mock *sqlwrappertest.Mock, mg Migration) {
+	mock.ExpectBegin()
+	mock.ExpectExec(sqlwrappertest.Exact(strings.TrimSuffix(mg.DownSQL, ";")))
+	mock.ExpectExec(sqlwrappertest.SQL("delete from schema_migrations where version_id = ?")).WithArgs(int64(mg.Version))
+	mock.ExpectCommit()
+}
+
+func expectUnlock(mock *sqlwrappertest.Mock) {
+	mock.ExpectExec(sqlwrappertest.SQL("delete from schema_migrations_lock where lock_id = ? and owner = ?")).
+		WithArgs(1, sqlwrappertest.AnyArg())
+}
+
+func TestMigrator(t *testing.T) {
+	for _, c := range []struct {
+		name   string
+		run    func(m *Migrator) error
+		expect func(mock *sqlwrappertest.Mock)
+	}{
+		{"up", (*Migrator).Up, func(mock *sqlwrappertest.Mock) {
+			expectApplied(mock)
+			expectUp(mock, createA)
+			expectUp(mock, createB)
+		}},
+		{"up skips applied", (*Migrator).Up, func(mock *sqlwrappertest.Mock) {
+			expectApplied(mock, createA)
+			expectUp(mock, createB)
+		}},
+		{"up to", func(m *Migrator) error { return m.UpTo(1) }, func(mock *sqlwrappertest.Mock) {
+			expectApplied(mock)
+			expectUp(mock, createA)
+		}},
+		{"down", (*Migrator).Down, func(mock *sqlwrappertest.Mock) {
+			expectApplied(mock, createA, createB)
+			expectDown(mock, createB)
+		}},
+		{"down to", func(m *Migrator) error { return m.DownTo(0) }, func(mock *sqlwrappertest.Mock) {
+			expectApplied(mock, createA, createB)
+			expectDown(mock, createB)
+			expectDown(mock, createA)
+		}},
+	} {
+		m, mock := newMigrator(t)
+		expectLock(mock)
+		c.expect(mock)
+		expectUnlock(mock)
+		if err := c.run(m); err != nil {
+			t.Errorf("%s: %v", c.name, err)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("%s: %v", c.name, err)
+		}
+	}
+}
+
+func TestMigratorWithReplicas(t *testing.T) {
+	// the primary is the mock with the sqlite dialect and error classifier, the replica is opened with replicaDriver
+	mock := sqlwrappertest.New("sqlite")
+	db, err := sqlwrapper.NewDatabase(replicaDriver, "",
+		sqlwrapper.WithConnector(mock),
+		sqlwrapper.WithDialect(mock.Dialect()),
+		sqlwrapper.WithErrorClassifier(sqlwrapper.GetErrorClassifier("sqlite")),
+		sqlwrapper.WithReplicas("replica"),
+		sqlwrapper.WithReplicaHealthCheck(0),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := newMigratorWithDB(t, db)
+	replica.Reset()
+	expectLock(mock)
+	expectApplied(mock, createA)
+	expectUp(mock, createB)
+	expectUnlock(mock)
+	if err := m.Up(); err != nil {
+		t.Error(err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+	if s := replica.Statements(); len(s) != 0 {
+		t.Errorf("got statements %+v on the replica, want none", s)
+	}
+}
+
+func TestMigrationFailed(t *testing.T) {
+	m, mock := newMigrator(t)
+	errSyntax := errors.New("syntax error")
+	expectLock(mock)
+	expectApplied(mock)
+	mock.ExpectBegin()
+	mock.ExpectExec(sqlwrappertest.Exact("create table a (id int)")).WillReturnError(errSyntax)
+	mock.ExpectRollback()
+	expectUnlock(mock)
+	if err := m.Up(); !errors.Is(err, errSyntax) {
+		t.Errorf("got %v, want %v", err, errSyntax)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestChecksumMismatch(t *testing.T) {
+	modifiedUp, modifiedDown := createA, createA
+	modifiedUp.UpSQL = "create table a (id bigint);"
+	modifiedDown.DownSQL = "drop table if exists a;"
+	for _, modified := range []Migration{modifiedUp, modifiedDown} {
+		m, mock := newMigrator(t)
+		expectLock(mock)
+		expectApplied(mock, modified)
+		expectUnlock(mock)
+		if err := m.Up(); err == nil || !strings.Contains(err.Error(), "checksum of migration 1") {
+			t.Errorf("got %v, want checksum mismatch of migration 1", err)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+func expectLocked(mock *sqlwrappertest.Mock, expired bool) {
+	mock.ExpectExec(sqlwrappertest.SQL("insert into schema_migrations_lock (lock_id, owner, locked_at) values (?, ?, ?)")).
+		WillReturnError(errors.New("UNIQUE constraint failed"))
+	rowsAffected := int64(0)
+	if expired {
+		rowsAffected = 1
+	}
+	mock.ExpectExec(sqlwrappertest.SQL("delete from schema_migrations_lock where lock_id = ? and locked_at < ?")).
+		WithArgs(1, sqlwrappertest.AnyArg()).
+		WillReturnResult(0, rowsAffected)
+}
+
+func TestLock(t *testing.T) {
+	// held by another process
+	m, mock := newMigrator(t, WithLockTimeout(0))
+	mock.ExpectExec(sqlwrappertest.Regexp(`^create table`))
+	mock.ExpectExec(sqlwrappertest.Regexp(`^create table`))
+	expectLocked(mock, false)
+	if err := m.Up(); !errors.Is(err, ErrLocked) {
+		t.Errorf("got %v, want %v", err, ErrLocked)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+
+	// retried until the timeout
+	m, mock = newMigrator(t, WithLockTimeout(lockRetryInterval/5))
+	mock.ExpectExec(sqlwrappertest.Regexp(`^create table`))
+	mock.ExpectExec(sqlwrappertest.Regexp(`^create table`))
+	expectLocked(mock, false)
+	expectLocked(mock, false)
+	if err := m.Up(); !errors.Is(err, ErrLocked) {
+		t.Errorf("got %v, want %v", err, ErrLocked)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+
+	// the lock of a crashed process is taken over after the lease
+	m, mock = newMigrator(t, WithLockTimeout(0))
+	mock.ExpectExec(sqlwrappertest.Regexp(`^create table`))
+	mock.ExpectExec(sqlwrappertest.Regexp(`^create table`))
+	expectLocked(mock, true)
+	mock.ExpectExec(sqlwrappertest.SQL("insert into schema_migrations_lock (lock_id, owner, locked_at) values (?, ?, ?)"))
+	expectApplied(mock, createA, createB)
+	expectUnlock(mock)
+	if err := m.Up(); err != nil {
+		t.Error(err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+
+	// other errors are returned at once
+	m, mock = newMigrator(t)
+	errIO := errors.New("disk I/O error")
+	mock.ExpectExec(sqlwrappertest.Regexp(`^create table`))
+	mock.ExpectExec(sqlwrappertest.Regexp(`^create table`))
+	mock.ExpectExec(sqlwrappertest.SQL("insert into schema_migrations_lock (lock_id, owner, locked_at) values (?, ?, ?)")).
+		WillReturnError(errIO)
+	if err := m.Up(); !errors.Is(err, errIO) || errors.Is(err, ErrLocked) {
+		t.Errorf("got %v, want %v", err, errIO)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+const renewSQL = "update schema_migrations_lock set locked_at = ? where lock_id = ? and owner = ?"
+
+func TestKeepLock(t *testing.T) {
+	m, mock := newMigrator(t, WithLockLease(30*time.Millisecond))
+	ctx, cancel := context.WithCancelCause(context.Background())
+	stop := m.keepLock("owner", cancel)
+	time.Sleep(50 * time.Millisecond)
+	stop()
+	n := 0
+	for _, s := range mock.Statements() {
+		if s.Normalized != renewSQL || s.Args[2] != "owner" {
+			t.Errorf("unexpected statement %q %v", s.Normalized, s.Args)
+		}
+		n++
+	}
+	if n == 0 {
+		t.Error("the lock is not renewed")
+	}
+	if err := context.Cause(ctx); err != nil {
+		t.Errorf("got %v, want the lock kept", err)
+	}
+}
+
+func TestLockLost(t *testing.T) {
+	// the lock is released by Unlock or taken over by another process
+	m, mock := newMigrator(t, WithLockLease(30*time.Millisecond))
+	mock.ExpectExec(sqlwrappertest.SQL(renewSQL)).
+		WithArgs(sqlwrappertest.AnyArg(), 1, "owner").
+		WillReturnResult(0, 0)
+	ctx, cancel := context.WithCancelCause(context.Background())
+	stop := m.keepLock("owner", cancel)
+	select {
+	case <-ctx.Done():
+	case <-time.After(time.Second):
+		t.Fatal("the migration is not stopped")
+	}
+	stop()
+	if err := context.Cause(ctx); !errors.Is(err, ErrLockLost) {
+		t.Errorf("got %v, want %v", err, ErrLockLost)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+
+	// no more migration runs after the lock is lost
+	mock.Reset()
+	if err := m.run(ctx, &createA, true); !errors.Is(err, ErrLockLost) {
+		t.Errorf("got %v, want %v", err, ErrLockLost)
+	}
+	if s := mock.Statements(); len(s) != 0 {
+		t.Errorf("got statements %+v, want none", s)
+	}
+}
+
+func TestDryRun(t *testing.T) {
+	buf := &bytes.Buffer{}
+	m, mock := newMigrator(t, DryRun(buf))
+	if err := m.Add(Migration{Version: 3, Name: "go", Up: func(tx *sqlwrapper.Tx) error { return nil }}); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Up(); err != nil {
+		t.Fatal(err)
+	}
+	want := "-- 1 create a (up)\ncreate table a (id int);\n" +
+		"-- 2 create b (up)\ncreate table b (id int);\n" +
+		"-- 3 go (up, go function)\n"
+	if buf.String() != want {
+		t.Errorf("got\n%s\nwant\n%s", buf, want)
+	}
+	// only the applied migrations are read
+	if s := mock.Statements(); len(s) != 1 || s[0].Kind != sqlwrappertest.Query {
+		t.Errorf("got statements %+v, want a single query", s)
+	}
+}
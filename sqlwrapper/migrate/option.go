@@ -0,0 +1,44 @@
+package migrate
+
+import (
+	"database/sql"
+	"io"
+	"time"
+)
+
+type Option func(m *Migrator)
+
+// WithTable 指定记录迁移版本的表名，默认为 schema_migrations。
+//
+// 锁表的表名为该表名加上 _lock 后缀。
+func WithTable(table string) Option {
+	return func(m *Migrator) { m.table = table }
+}
+
+// WithTxOptions 指定每个迁移所在事务的 *sql.TxOptions。
+func WithTxOptions(opts *sql.TxOptions) Option {
+	return func(m *Migrator) { m.txOptions = opts }
+}
+
+// WithLockTimeout 指定等待其他进程释放迁移锁的最长时间，默认为 1 分钟。
+// 为 0 时只尝试一次。
+func WithLockTimeout(timeout time.Duration) Option {
+	return func(m *Migrator) { m.lockTimeout = timeout }
+}
+
+// WithLockLease 指定迁移锁的租期，默认为 DefaultLockLease。持有锁的进程每隔租期的 1/3 续期一次，
+// 租期过后仍未续期的锁视为持有锁的进程已经异常退出，会被其他进程接管。为 0 时锁不会过期，只能用 Unlock 释放。
+//
+// 续期失败（锁已被 Unlock 删除或被其他进程接管）时停止迁移，返回 ErrLockLost。
+//
+// 过期时间按各进程的本地时间判断，各个主机的时钟偏差应远小于租期。
+// MySQL 的 datetime 精确到秒，且只统计值发生变化的行，租期应不小于 3 秒，否则续期可能被误判为失败。
+func WithLockLease(lease time.Duration) Option {
+	return func(m *Migrator) { m.lockLease = lease }
+}
+
+// DryRun 指定时，迁移不会执行，而是将要执行的 SQL 写入 w。
+// Go 函数形式的迁移只会写入一行注释。
+func DryRun(w io.Writer) Option {
+	return func(m *Migrator) { m.dryRun = w }
+}
@@ -0,0 +1,155 @@
+package migrate
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// Files 从 fsys 的 dir 目录中读取 SQL 迁移文件，通常与 embed.FS 配合使用。
+//
+// 文件名格式为 <version>_<name>.up.sql 和 <version>_<name>.down.sql，down 文件可以省略。
+//
+//	//go:embed migrations/*.sql
+//	var migrations embed.FS
+//
+//	ms, err := migrate.Files(migrations, "migrations")
+//	// migrations/0001_create_user.up.sql
+//	// migrations/0001_create_user.down.sql
+//	// migrations/0002_add_user_email.up.sql
+func Files(fsys fs.FS, dir string) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, err
+	}
+	byVersion := map[uint64]*Migration{}
+	versions := []uint64{}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		filename := entry.Name()
+		var up bool
+		var base string
+		switch {
+		case strings.HasSuffix(filename, ".up.sql"):
+			up, base = true, strings.TrimSuffix(filename, ".up.sql")
+		case strings.HasSuffix(filename, ".down.sql"):
+			base = strings.TrimSuffix(filename, ".down.sql")
+		default:
+			continue
+		}
+		version, name, err := parseFilename(base)
+		if err != nil {
+			return nil, fmt.Errorf(fInvalidFilename, filename, err)
+		}
+		content, err := fs.ReadFile(fsys, path.Join(dir, filename))
+		if err != nil {
+			return nil, err
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: name}
+			byVersion[version] = m
+			versions = append(versions, version)
+		}
+		if up {
+			if len(m.UpSQL) > 0 {
+				return nil, fmt.Errorf(fDuplicateVersion, version)
+			}
+			m.UpSQL = string(content)
+			continue
+		}
+		if len(m.DownSQL) > 0 {
+			return nil, fmt.Errorf(fDuplicateVersion, version)
+		}
+		m.DownSQL = string(content)
+	}
+
+	ms := make([]Migration, 0, len(versions))
+	for _, version := range versions {
+		m := byVersion[version]
+		if len(m.UpSQL) == 0 {
+			return nil, fmt.Errorf(fNoUpMigration, version)
+		}
+		ms = append(ms, *m)
+	}
+	return ms, nil
+}
+
+// parseFilename 解析 0001_create_user 形式的文件名。
+func parseFilename(base string) (version uint64, name string, err error) {
+	v, name, _ := strings.Cut(base, "_")
+	version, err = strconv.ParseUint(v, 10, 64)
+	return
+}
+
+// checksum 计算 SQL 迁移的校验值，UpSQL 和 DownSQL 之间用 NUL 分隔，任意一个被修改都会改变校验值。
+// Go 函数形式的迁移没有校验值。
+func checksum(m *Migration) string {
+	if m.Up != nil {
+		return ""
+	}
+	h := sha256.New()
+	h.Write([]byte(m.UpSQL))
+	h.Write([]byte{0})
+	h.Write([]byte(m.DownSQL))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// splitStatements 将 SQL 脚本按分号拆分为多条语句，跳过字符串、引号标识符和注释中的分号。
+//
+// 由于大部分驱动不支持一次执行多条语句，SQL 迁移中的语句会逐条执行。
+// Oracle 的 PL/SQL 块（begin ... end;）无法正确拆分，请使用 Go 函数形式的迁移。
+func splitStatements(script string) []string {
+	stmts := []string{}
+	start := 0
+	for i := 0; i < len(script); i++ {
+		switch c := script[i]; c {
+		case '\'', '"', '`':
+			// skip to the closing quote; doubled quotes are escapes and work fine here
+			for i++; i < len(script) && script[i] != c; i++ {
+			}
+		case '-':
+			if i+1 < len(script) && script[i+1] == '-' {
+				for ; i < len(script) && script[i] != '\n'; i++ {
+				}
+			}
+		case '/':
+			if i+1 < len(script) && script[i+1] == '*' {
+				end := strings.Index(script[i+2:], "*/")
+				if end == -1 {
+					i = len(script)
+					break
+				}
+				i += end + 3
+			}
+		case ';':
+			if stmt := strings.TrimSpace(script[start:i]); len(stmt) > 0 {
+				stmts = append(stmts, stmt)
+			}
+			start = i + 1
+		}
+	}
+	if start < len(script) {
+		if stmt := strings.TrimSpace(script[start:]); len(stmt) > 0 && !onlyComments(stmt) {
+			stmts = append(stmts, stmt)
+		}
+	}
+	return stmts
+}
+
+func onlyComments(s string) bool {
+	for _, line := range strings.Split(s, "\n") {
+		line = strings.TrimSpace(line)
+		if len(line) > 0 && !strings.HasPrefix(line, "--") {
+			return false
+		}
+	}
+	return true
+}
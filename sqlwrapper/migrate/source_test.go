@@ -0,0 +1,65 @@
+package migrate
+
+import (
+	"testing"
+	"testing/fstest"
+)
+
+func TestSplitStatements(t *testing.T) {
+	script := `
+-- create tables; with comments
+create table foo (id int, name varchar(20) default 'a;b');
+/* block; comment */
+insert into foo values (1, 'it''s; fine');
+
+create table "bar;baz" (id int) -- trailing comment
+`
+	stmts := splitStatements(script)
+	want := []string{
+		"-- create tables; with comments\ncreate table foo (id int, name varchar(20) default 'a;b')",
+		"/* block; comment */\ninsert into foo values (1, 'it''s; fine')",
+		"create table \"bar;baz\" (id int) -- trailing comment",
+	}
+	if len(stmts) != len(want) {
+		t.Fatalf("got %d statements %q, want %d", len(stmts), stmts, len(want))
+	}
+	for i := range want {
+		if stmts[i] != want[i] {
+			t.Errorf("statement %d: got %q, want %q", i, stmts[i], want[i])
+		}
+	}
+}
+
+func TestFiles(t *testing.T) {
+	fsys := fstest.MapFS{
+		"migrations/0002_add_email.up.sql":      {Data: []byte("alter table users add email varchar(255);")},
+		"migrations/0001_create_users.up.sql":   {Data: []byte("create table users (id int);")},
+		"migrations/0001_create_users.down.sql": {Data: []byte("drop table users;")},
+		"migrations/README.md":                  {Data: []byte("ignored")},
+	}
+	ms, err := Files(fsys, "migrations")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ms) != 2 {
+		t.Fatalf("got %d migrations, want 2", len(ms))
+	}
+
+	m := New(nil)
+	if err = m.Add(ms...); err != nil {
+		t.Fatal(err)
+	}
+	if m.migrations[0].Version != 1 || m.migrations[0].Name != "create_users" || len(m.migrations[0].DownSQL) == 0 {
+		t.Errorf("unexpected migration %+v", m.migrations[0])
+	}
+	if m.migrations[1].Version != 2 || len(m.migrations[1].DownSQL) != 0 {
+		t.Errorf("unexpected migration %+v", m.migrations[1])
+	}
+	if err = m.Add(Migration{Version: 2, UpSQL: "select 1"}); err == nil {
+		t.Error("expect duplicate version error")
+	}
+
+	if _, err = Files(fstest.MapFS{"m/x_bad.up.sql": {}}, "m"); err == nil {
+		t.Error("expect invalid filename error")
+	}
+}
@@ -0,0 +1,64 @@
+package sqlwrapper
+
+import (
+	"database/sql/driver"
+	"reflect"
+)
+
+// Null 是可以为 NULL 的 T，用法与 sql.NullString 等类型相同，可以用于任何 ValueConverter 支持的 T。
+//
+//	type Employee struct {
+//	    ID      int64
+//	    Manager Null[int64]
+//	    Leave   Null[time.Time]
+//	}
+//
+// 作为 entity 的字段查询时 T 使用 Database 的 ValueConverter 转换；写入时 Valid 为 false 的值写入 NULL。
+// 直接把 *Null[T] 传给 rows.Scan（如在 RawQuery 的 ScanFn 中）时调用的是 Scan，只能使用内置的 Vcie，见 Scan。
+type Null[T any] struct {
+	V     T
+	Valid bool
+}
+
+// NewNull 返回有效的 Null。
+func NewNull[T any](v T) Null[T] { return Null[T]{V: v, Valid: true} }
+
+// nullWrapper 由 Null 实现，使 convertValue 使用 Database 的 ValueConverter 转换 V。
+type nullWrapper interface {
+	valuePtr() interface{}
+	setValid(valid bool)
+	setNull()
+}
+
+func (n *Null[T]) valuePtr() interface{} { return &n.V }
+func (n *Null[T]) setValid(valid bool)   { n.Valid = valid }
+
+func (n *Null[T]) setNull() {
+	var zero T
+	n.V, n.Valid = zero, false
+}
+
+// Scan 实现 sql.Scanner，以便在 RawQuery 中使用。
+//
+// sql.Scanner 无法得到 Database，所以 Scan 总是使用默认的 Vcie 转换：WithValueConverter、WithLocation、
+// WithTimeLayouts 和 WithUnixTime 对 Scan 无效。需要这些选项时，使用 entity 接收结果（Query、QueryMultiple），
+// 或者先扫描到 interface{} 再自行转换。
+func (n *Null[T]) Scan(src interface{}) error {
+	return convertValue(n, src, Vcie, DoNothing)
+}
+
+// Value 实现 driver.Valuer。
+func (n Null[T]) Value() (driver.Value, error) {
+	if !n.Valid {
+		return nil, nil
+	}
+	return driver.DefaultParameterConverter.ConvertValue(n.V)
+}
+
+// nullValueType 在 t 是 Null[T] 时返回 T 的类型。
+func nullValueType(t reflect.Type) (reflect.Type, bool) {
+	if t.Kind() != reflect.Struct || !reflect.PointerTo(t).Implements(nullWrapperType) {
+		return nil, false
+	}
+	return t.Field(0).Type, true
+}
@@ -29,7 +29,17 @@ type (
 
 	OptionWhere interface {
 		OptionQuery
-		OptionDelete
+		OptionUpdateAndDelete
+	}
+
+	OptionFrom interface {
+		OptionQuery
+		OptionUpdateAndDelete
+	}
+
+	OptionLimit interface {
+		OptionQueryMultiple
+		OptionUpdateAndDelete
 	}
 
 	OptionExec interface {
@@ -39,6 +49,15 @@ type (
 	OptionDelete interface {
 		applyToOptionDelete(opt *optDelete)
 	}
+
+	OptionUpdate interface {
+		applyToOptionUpdate(opt *optUpdate)
+	}
+
+	OptionUpdateAndDelete interface {
+		OptionUpdate
+		OptionDelete
+	}
 )
 
 type optQuery struct {
@@ -52,50 +71,107 @@ type optQuery struct {
 	orderByColumns []string
 	limit          uint64
 	offset         uint64
+	setOperations  []optSetOperation
+	lock           optLock
 	isSubQuery     bool
+	// primary 为 true 时在主库查询，见 UsePrimary
+	primary bool
+	// shardKey 是 ShardKey 指定的分片键的值，用于 ShardedDatabase
+	shardKey *optShardKey
 }
 
 func (o optQuery) applyToOptionTable(t *optTable) { t.table = o }
 func (o optQuery) applyToOptionJoin(j *optJoin)   { j.table = o }
 func (o optQuery) AppendToSqlCtx(ctx *SqlCtx) (err error) {
+	if len(o.lock.strength) > 0 && len(o.setOperations) > 0 {
+		return ErrLockWithSetOperation
+	}
 	if o.isSubQuery {
 		ctx.WriteByte('(')
 	}
-	err = ctx.selectFromTable(o.selectColumns, o.table)
-	if err != nil {
-		return
+	if o.oracleLockWithFetch(ctx) {
+		err = o.appendOracleLock(ctx)
+	} else {
+		err = o.appendQuery(ctx)
 	}
-	err = ctx.where(o.whereClause, o.whereArgs...)
 	if err != nil {
 		return
 	}
-	ctx.groupBy(o.groupByColumns...)
-	err = ctx.having(o.havingClause, o.havingArgs...)
+	if o.isSubQuery {
+		ctx.WriteByte(')')
+	}
+	return
+}
+
+func (o optQuery) appendQuery(ctx *SqlCtx) (err error) {
+	err = o.appendSelect(ctx)
 	if err != nil {
 		return
 	}
+	for _, so := range o.setOperations {
+		err = so.appendTo(ctx, o)
+		if err != nil {
+			return
+		}
+	}
+	ctx.orderByLimitOffset(o.orderByColumns, o.limit, o.offset)
+	return ctx.lockClause(o.lock)
+}
+
+// oracleLockWithFetch 判断是否是 oracle 中带有分页的行锁查询。
+// oracle 不允许 for update 和 fetch next 同时使用（ORA-02014），见 appendOracleLock。
+func (o optQuery) oracleLockWithFetch(ctx *SqlCtx) bool {
 	switch ctx.driver {
 	case "oci8", "oracle":
-		ctx.orderBy(o.orderByColumns...)
-		ctx.offsetFetchNextRows(o.offset, o.limit)
-	case "mssql", "sqlserver":
-		if len(o.orderByColumns) == 0 {
-			ctx.WriteString(" order by 1")
-		}
-		ctx.offsetFetchNextRows(o.offset, o.limit)
-	case "mysql":
-		if o.limit == 0 && o.offset > 0 {
-			o.limit = MySQLUnlimit
-		}
-		fallthrough
-	default:
-		ctx.orderBy(o.orderByColumns...)
-		ctx.limitOffset(o.limit, o.offset)
+		return len(o.lock.strength) > 0 && (o.limit > 0 || o.offset > 0)
 	}
-	if o.isSubQuery {
-		ctx.WriteByte(')')
+	return false
+}
+
+// appendOracleLock writes a locking query with pagination for oracle. The
+// rows are picked by rowid in a sub query, and locked by the outer query:
+//
+//	select ... from t where rowid in (select rowid from t where ... order by ... fetch next :1 rows only) order by ... for update
+//
+// Only a single table without group by is supported, as rowid must identify
+// the rows of that table.
+func (o optQuery) appendOracleLock(ctx *SqlCtx) (err error) {
+	if _, ok := o.table.table.(optSingleTable); !ok || len(o.table.joins) > 0 || len(o.groupByColumns) > 0 {
+		return ErrLockNotSupported
 	}
-	return
+	if o.lock.strength == "share" {
+		return ErrLockNotSupported
+	}
+	err = ctx.selectFromTable(o.selectColumns, o.table, o.lock)
+	if err != nil {
+		return
+	}
+	ctx.WriteString(" where rowid in (")
+	inner := o
+	inner.selectColumns = []string{"rowid"}
+	inner.lock = optLock{}
+	inner.isSubQuery = false
+	err = inner.appendQuery(ctx)
+	if err != nil {
+		return
+	}
+	ctx.WriteByte(')')
+	ctx.orderBy(o.orderByColumns...)
+	return ctx.lockClause(o.lock)
+}
+
+// appendSelect writes the select clause without order by, limit and offset.
+func (o optQuery) appendSelect(ctx *SqlCtx) (err error) {
+	err = ctx.selectFromTable(o.selectColumns, o.table, o.lock)
+	if err != nil {
+		return
+	}
+	err = ctx.where(o.whereClause, o.whereArgs...)
+	if err != nil {
+		return
+	}
+	ctx.groupBy(o.groupByColumns...)
+	return ctx.having(o.havingClause, o.havingArgs...)
 }
 
 type optQuerySingle struct {
@@ -110,11 +186,47 @@ type optQueryMultiple struct {
 type optExec struct {
 	columns        []string
 	includingZeros bool
+	// zeroColumns 是 IncludingZeros 指定的列
+	zeroColumns map[string]bool
+	// table 不为空时代替 TableName 作为表名，用于分片
+	table string
+}
+
+// writeMode 返回字段是否写入，以及是否写入 NULL。
+//
+// 非〇值总是写入；〇值按以下顺序判断：tag 中的 nullzero 写入 NULL，IncludingZeros 指定的列写入，
+// tag 中的 always 写入、omitempty 跳过，其他字段在 IncludingZeros 不带参数时写入。
+func (o *optExec) writeMode(fm *fieldMeta, zero bool) (write, null bool) {
+	switch {
+	case !zero:
+		return true, false
+	case fm.tag.write == writeNullZero:
+		return true, true
+	case o.zeroColumns[fm.column]:
+		return true, false
+	case fm.tag.write == writeAlways:
+		return true, false
+	case fm.tag.write == writeOmitEmpty:
+		return false, false
+	}
+	return o.includingZeros, false
 }
 
 type optDelete struct {
+	from        optTable
 	whereClause string
 	whereArgs   []interface{}
+	limit       uint64
+	returning   optReturning
+}
+
+type optUpdate struct {
+	sets        []optSet
+	from        optTable
+	whereClause string
+	whereArgs   []interface{}
+	limit       uint64
+	returning   optReturning
 }
 
 type (
@@ -164,8 +276,38 @@ type (
 	optOffset uint64
 	optUnused map[string]interface{}
 
-	optIncludingZeros struct{}
-	optColumns        struct {
+	optSetOperation struct {
+		// operator is one of "union", "union all", "intersect" and "except"
+		operator string
+		options  []OptionQueryMultiple
+	}
+
+	optLock struct {
+		// strength is one of "update" and "share"
+		strength string
+		// wait is one of "", "skip locked" and "nowait"
+		wait string
+	}
+	optLockStrength string
+	optLockWait     string
+	optPrimary      struct{}
+	optShardKey     struct{ key interface{} }
+	optExecTable    string
+
+	optSet struct {
+		clause string
+		args   []interface{}
+	}
+	optReturning struct {
+		// dest is either a RowsScanner or a pointer to a slice of structs
+		dest    interface{}
+		columns []string
+	}
+
+	optIncludingZeros struct {
+		columns []string
+	}
+	optColumns struct {
 		columns []string
 	}
 )
@@ -175,6 +317,8 @@ func (o optSelect) applyToOptionQueryMultiple(q *optQueryMultiple) { q.selectCol
 
 func (o optTable) applyToOptionQuerySingle(q *optQuerySingle)     { q.table = o }
 func (o optTable) applyToOptionQueryMultiple(q *optQueryMultiple) { q.table = o }
+func (o optTable) applyToOptionUpdate(u *optUpdate)               { u.from = o }
+func (o optTable) applyToOptionDelete(d *optDelete)               { d.from = o }
 
 func (o optSingleTable) applyToOptionTable(f *optTable) { f.table = o }
 func (o optSingleTable) applyToOptionJoin(j *optJoin)   { j.table = o }
@@ -195,6 +339,7 @@ func (o optWhere) applyToOptionQueryMultiple(q *optQueryMultiple) {
 	q.whereClause, q.whereArgs = o.clause, o.args
 }
 func (o optWhere) applyToOptionDelete(d *optDelete) { d.whereClause, d.whereArgs = o.clause, o.args }
+func (o optWhere) applyToOptionUpdate(u *optUpdate) { u.whereClause, u.whereArgs = o.clause, o.args }
 
 func (o optGroupBy) applyToOptionQuerySingle(q *optQuerySingle)     { q.groupByColumns = o.columns }
 func (o optGroupBy) applyToOptionQueryMultiple(q *optQueryMultiple) { q.groupByColumns = o.columns }
@@ -215,12 +360,99 @@ func (o optOffset) applyToOptionQueryMultiple(q *optQueryMultiple) { q.offset =
 func (o optUnused) applyToOptionQuerySingle(q *optQuerySingle) { q.unused = o }
 
 func (o optLimit) applyToOptionQueryMultiple(q *optQueryMultiple) { q.limit = uint64(o) }
+func (o optLimit) applyToOptionUpdate(u *optUpdate)               { u.limit = uint64(o) }
+func (o optLimit) applyToOptionDelete(d *optDelete)               { d.limit = uint64(o) }
+
+func (o optSet) applyToOptionUpdate(u *optUpdate) { u.sets = append(u.sets, o) }
+
+func (o optReturning) applyToOptionUpdate(u *optUpdate) { u.returning = o }
+func (o optReturning) applyToOptionDelete(d *optDelete) { d.returning = o }
+
+func (o optSetOperation) applyToOptionQuerySingle(q *optQuerySingle) {
+	q.setOperations = append(q.setOperations, o)
+}
+func (o optSetOperation) applyToOptionQueryMultiple(q *optQueryMultiple) {
+	q.setOperations = append(q.setOperations, o)
+}
+
+// appendTo writes the operator and the combined query. The combined query
+// inherits the select columns and the table of the first query, so that
+// Union(Where("age > ?", 30)) works against the same table.
+func (o optSetOperation) appendTo(ctx *SqlCtx, first optQuery) error {
+	q := optQueryMultiple{
+		optQuery: optQuery{
+			selectColumns: first.selectColumns,
+			table:         first.table,
+		},
+	}
+	for _, opt := range o.options {
+		opt.applyToOptionQueryMultiple(&q)
+	}
+
+	operator := o.operator
+	if operator == "except" {
+		switch ctx.driver {
+		case "oci8", "oracle":
+			operator = "minus"
+		}
+	}
+	if len(q.lock.strength) > 0 {
+		return ErrLockWithSetOperation
+	}
+	ctx.WriteByte(' ').WriteString(operator).WriteByte(' ')
+
+	if len(q.orderByColumns) > 0 || q.limit > 0 || q.offset > 0 || len(q.setOperations) > 0 {
+		// The combined query has its own ordering or pagination. Wrap it with
+		// parentheses (not supported by SQLite).
+		q.isSubQuery = true
+		return q.optQuery.AppendToSqlCtx(ctx)
+	}
+	return q.appendSelect(ctx)
+}
+
+func (o optLockStrength) applyToOptionQuerySingle(q *optQuerySingle) {
+	q.lock.strength = string(o)
+}
+func (o optLockStrength) applyToOptionQueryMultiple(q *optQueryMultiple) {
+	q.lock.strength = string(o)
+}
+
+func (o optLockWait) applyToOptionQuerySingle(q *optQuerySingle) { q.lock.setWait(string(o)) }
+func (o optLockWait) applyToOptionQueryMultiple(q *optQueryMultiple) {
+	q.lock.setWait(string(o))
+}
+
+func (optPrimary) applyToOptionQuerySingle(q *optQuerySingle)     { q.primary = true }
+func (optPrimary) applyToOptionQueryMultiple(q *optQueryMultiple) { q.primary = true }
+
+func (o optShardKey) applyToOptionQuerySingle(q *optQuerySingle)     { q.shardKey = &o }
+func (o optShardKey) applyToOptionQueryMultiple(q *optQueryMultiple) { q.shardKey = &o }
+
+func (o optExecTable) applyToOptionExec(e *optExec) { e.table = string(o) }
+
+func (l *optLock) setWait(wait string) {
+	l.wait = wait
+	if len(l.strength) == 0 {
+		l.strength = "update"
+	}
+}
 
 func (o optJoin) applyToOptionTable(t *optTable) { t.joins = append(t.joins, o) }
 
 func (o optColumns) applyToOptionExec(e *optExec) { e.columns = o.columns }
 
-func (o optIncludingZeros) applyToOptionExec(e *optExec) { e.includingZeros = true }
+func (o optIncludingZeros) applyToOptionExec(e *optExec) {
+	if len(o.columns) == 0 {
+		e.includingZeros = true
+		return
+	}
+	if e.zeroColumns == nil {
+		e.zeroColumns = make(map[string]bool, len(o.columns))
+	}
+	for _, column := range o.columns {
+		e.zeroColumns[column] = true
+	}
+}
 
 // Select 可以查询指定的列。
 //
@@ -238,7 +470,15 @@ func Select(columns ...string) OptionQuery {
 // From 可以指定表名。
 //
 //	From(Table("user"))
-func From(options ...OptionTable) OptionQuery {
+//
+// 在 UpdateWhere 和 DeleteWhere 中，From 指定的是参与条件判断的其他表（Postgresql 的 delete 中写作 using）。
+//
+//	db.UpdateWhere("emp",
+//	  Set("emp.level = dept.level"),
+//	  From(Table("dept")),
+//	  Where("emp.dept_id = dept.id"),
+//	)
+func From(options ...OptionTable) OptionFrom {
 	o := &optTable{}
 	for _, opt := range options {
 		opt.applyToOptionTable(o)
@@ -331,10 +571,130 @@ func Offset(offset uint64) OptionQuery {
 	return optOffset(offset)
 }
 
-func Limit(limit uint64) OptionQueryMultiple {
+// Limit 限制查询返回的行数。
+//
+// 在 UpdateWhere 和 DeleteWhere 中，Limit 限制受影响的行数，仅 MySQL 和 SQL Server 支持。
+func Limit(limit uint64) OptionLimit {
 	return optLimit(limit)
 }
 
+// Union combines the query with another one, removing duplicate rows.
+//
+// Options are the same as SubQuery. Select and From default to those of the
+// outer query when omitted. OrderBy, Limit and Offset of the outer query
+// apply to the combined result.
+//
+//	db.QueryMultiple(&es,
+//	  Where("age < ?", 20),
+//	  Union(Where("age > ?", 60)),
+//	  OrderBy("age"),
+//	  Limit(10),
+//	)
+//	// select ... from emp where age < ? union select ... from emp where age > ? order by age limit ?
+func Union(options ...OptionQueryMultiple) OptionQuery {
+	return optSetOperation{operator: "union", options: options}
+}
+
+// UnionAll is like Union but keeps duplicate rows.
+func UnionAll(options ...OptionQueryMultiple) OptionQuery {
+	return optSetOperation{operator: "union all", options: options}
+}
+
+// Intersect keeps rows returned by both queries. See Union for the usage.
+func Intersect(options ...OptionQueryMultiple) OptionQuery {
+	return optSetOperation{operator: "intersect", options: options}
+}
+
+// Except keeps rows of the outer query that are not returned by the other
+// one. It is written as "minus" in Oracle. See Union for the usage.
+func Except(options ...OptionQueryMultiple) OptionQuery {
+	return optSetOperation{operator: "except", options: options}
+}
+
+// ForUpdate locks the selected rows for update ("for update"). Locking
+// options can only be used in a transaction, i.e. Tx.Query and
+// Tx.QueryMultiple.
+//
+// SQL Server uses table hints instead, like "from jobs with (updlock, rowlock)".
+// SQLite does not support row locking.
+//
+//	tx.QueryMultiple(&jobs,
+//	  Where("status = ?", 0),
+//	  Limit(10),
+//	  ForUpdate(),
+//	  SkipLocked(),
+//	)
+//	// select ... from jobs where status = ? limit ? for update skip locked
+func ForUpdate() OptionQuery {
+	return optLockStrength("update")
+}
+
+// ForShare locks the selected rows in share mode ("for share"). It is not
+// supported by Oracle. See ForUpdate for more details.
+func ForShare() OptionQuery {
+	return optLockStrength("share")
+}
+
+// SkipLocked skips rows that are locked by other transactions instead of
+// waiting for them. It implies ForUpdate if no lock strength is specified.
+func SkipLocked() OptionQuery {
+	return optLockWait("skip locked")
+}
+
+// NoWait reports an error immediately instead of waiting for rows locked by
+// other transactions. It implies ForUpdate if no lock strength is specified.
+func NoWait() OptionQuery {
+	return optLockWait("nowait")
+}
+
+// UsePrimary 指定在主库执行查询，用于读取刚写入的数据（read-your-writes）。
+// 没有配置从库（见 WithReplicas）或者在事务中查询时不需要指定。
+//
+//	db.Insert(&order)
+//	db.Query(&order, Where("id = ?", order.ID), UsePrimary())
+func UsePrimary() OptionQuery {
+	return optPrimary{}
+}
+
+// ShardKey 指定 ShardedDatabase 查询时分片键的值，查询只在该值所在的分片执行。Database 和 Tx 忽略此选项。
+//
+//	sdb.Query(&order, ShardKey(userID), Where("id = ?", orderID))
+func ShardKey(key interface{}) OptionQuery {
+	return optShardKey{key}
+}
+
+// Set 指定 UpdateWhere 中 set 子句的一个表达式，可以使用 ? 占位符。
+//
+//	Set("name = ?", "foo")
+//	Set("count = count + ?", 1)
+//	Set("updated_at = now()")
+func Set(clause string, args ...interface{}) OptionUpdate {
+	return optSet{clause: clause, args: args}
+}
+
+// Returning 将 UpdateWhere 和 DeleteWhere 受影响的行的 columns 列写入 dest。
+// dest 可以是 RowsScanner，也可以是结构体切片的指针（与 QueryMultiple 相同）。
+//
+// columns 为空时，dest 为结构体切片指针则使用结构体的所有列，否则使用 *。
+//
+// 仅 Postgresql、SQLite（3.35 及以上）和 SQL Server（output 子句）支持。
+//
+//	var ids []int64
+//	db.DeleteWhere("job",
+//	  Where("status = ?", 2),
+//	  Returning(ScanFn(func(r *sql.Rows) error { ... }), "id"),
+//	)
+//
+//	var jobs []Job
+//	db.UpdateWhere("job",
+//	  Set("status = ?", 1),
+//	  Where("status = ?", 0),
+//	  Returning(&jobs),
+//	)
+func Returning(dest interface{}, columns ...string) OptionUpdateAndDelete {
+	return optReturning{dest: dest, columns: columns}
+}
+
 // 当 Query 的数据列数大于目标结构体有效的字段数时，可以使用该 Option 记录结构体字段以外的列。
 //
 //	type User struct {
@@ -358,6 +718,13 @@ func WithColumns(columns ...string) OptionExec {
 }
 
 // IncludingZeros 设置时，entity 中的〇值字段不会被忽略，将以其类型的〇值传入 db.ExecContext 的参数列表。
-func IncludingZeros() OptionExec {
-	return optIncludingZeros{}
+//
+// 指定 columns 时只包含这些列的〇值，可以用于将某些字段更新为〇值：
+//
+//	e := Employee{ID: 1, Active: false, Retries: 0}
+//	db.Update(&e, IncludingZeros("active", "retries"))
+//
+// tag 中指定了 omitempty 的字段只有在 columns 中指定时才会包含〇值，见 optExec.writeMode。
+func IncludingZeros(columns ...string) OptionExec {
+	return optIncludingZeros{columns}
 }
@@ -1,34 +1,130 @@
 package sqlwrapper
 
+import (
+	"database/sql"
+	"database/sql/driver"
+	"time"
+)
+
 type optionDB struct {
 	ping    bool
 	onNull  Strategy
 	dialect Dialect
 	vc      ValueConverter
+	codecs  map[string]Codec
+
+	classifier ErrorClassifier
+	retry      RetryPolicy
+
+	loc       *time.Location
+	layouts   []string
+	unix      time.Duration
+	precision time.Duration
+
+	replicas      []string
+	replicaPolicy ReplicaPolicy
+	replicaCheck  time.Duration
+
+	// pool 设置主库和从库的连接池
+	pool        []func(db *sql.DB)
+	pingRetry   RetryPolicy
+	pingTimeout time.Duration
+
+	healthCheck    time.Duration
+	onHealthChange func(healthy bool, err error)
+
+	connector driver.Connector
 }
 
 type OptionDB func(opt *optionDB)
 
-// NoPing 指定时，初始化以后不进行 Ping 操作。
+// NoPing 指定时，初始化以后不进行 Ping 操作，WithPingRetry 和 WithPingTimeout 被忽略。
 func NoPing() OptionDB {
 	return func(opt *optionDB) { opt.ping = false }
 }
 
-// WithStrategyOnNull 设置当数据库中的值为 NULL 时对目标变量的行为。
+// WithMaxOpenConns 设置连接池（包括从库）的最大连接数，见 (*sql.DB).SetMaxOpenConns。
+func WithMaxOpenConns(n int) OptionDB {
+	return func(opt *optionDB) {
+		opt.pool = append(opt.pool, func(db *sql.DB) { db.SetMaxOpenConns(n) })
+	}
+}
+
+// WithMaxIdleConns 设置连接池（包括从库）的最大空闲连接数，见 (*sql.DB).SetMaxIdleConns。
+func WithMaxIdleConns(n int) OptionDB {
+	return func(opt *optionDB) {
+		opt.pool = append(opt.pool, func(db *sql.DB) { db.SetMaxIdleConns(n) })
+	}
+}
+
+// WithConnMaxLifetime 设置连接（包括从库）可以复用的最长时间，见 (*sql.DB).SetConnMaxLifetime。
+func WithConnMaxLifetime(d time.Duration) OptionDB {
+	return func(opt *optionDB) {
+		opt.pool = append(opt.pool, func(db *sql.DB) { db.SetConnMaxLifetime(d) })
+	}
+}
+
+// WithConnMaxIdleTime 设置连接（包括从库）最长的空闲时间，见 (*sql.DB).SetConnMaxIdleTime。
+func WithConnMaxIdleTime(d time.Duration) OptionDB {
+	return func(opt *optionDB) {
+		opt.pool = append(opt.pool, func(db *sql.DB) { db.SetConnMaxIdleTime(d) })
+	}
+}
+
+// WithPingRetry 设置初始化时 Ping 失败后的重试策略，所有错误都会重试，RetryPolicy 的 Retryable 被忽略。
+// 适用于应用和数据库同时启动、数据库还没有准备好的情况。默认不重试。
+//
+//	db, err := NewDatabase("pgx", dsn, WithPingRetry(RetryPolicy{
+//	  MaxAttempts: 10,
+//	  Backoff:     100 * time.Millisecond,
+//	  MaxBackoff:  5 * time.Second,
+//	}))
+func WithPingRetry(p RetryPolicy) OptionDB {
+	return func(opt *optionDB) { opt.pingRetry = p }
+}
+
+// WithPingTimeout 设置初始化时每次 Ping 主库和从库的超时时间，默认不限制。
+func WithPingTimeout(d time.Duration) OptionDB {
+	return func(opt *optionDB) { opt.pingTimeout = d }
+}
+
+// WithHealthCheck 每隔 interval Ping 一次主库，健康状态改变时调用 onChange（可以为 nil），
+// err 是失败时的错误。onChange 在单独的 goroutine 中调用。当前状态可以用 db.Healthy() 获取。
+//
+//	WithHealthCheck(10*time.Second, func(healthy bool, err error) {
+//	  log.Printf("database healthy: %v (%v)", healthy, err)
+//	})
+func WithHealthCheck(interval time.Duration, onChange func(healthy bool, err error)) OptionDB {
+	return func(opt *optionDB) { opt.healthCheck, opt.onHealthChange = interval, onChange }
+}
+
+// WithConnector 使用 c 连接主库（sql.OpenDB），而不是用 driver 和 dsn 打开，dsn 被忽略。
+// driver 仍然用于选择 Dialect、ErrorClassifier 和生成的 SQL 语句，从库仍然用 driver 打开。
+//
+// 适用于驱动提供了 Connector 的情况，以及测试时使用 sqlwrappertest 包。
+func WithConnector(c driver.Connector) OptionDB {
+	return func(opt *optionDB) { opt.connector = c }
+}
+
+// WithStrategyOnNull 设置当数据库中的值为 NULL 时对目标变量的默认行为，字段 tag 中的 onnull 选项优先。
 //
 //	DoNothing // 保留目标变量的原值，跳过后续的解析，直接开始解析下一列。
 //	SetZero   // 将目标变量设为它类型的 0 值，如整型则设为 0，字符串设为 ""，interface{} 设为 nil
 //	Continue  // 交给 ValueConverter 处理
+//	Error     // 目标变量不能为 nil 时返回 ErrUnexpectedNull，能为 nil 时设为 nil
+//	SetNil    // 指针、切片、map、interface{} 设为 nil，其他类型保留原值
 func WithStrategyOnNull(s Strategy) OptionDB {
 	return func(opt *optionDB) {
-		if s == DoNothing || s == SetZero {
+		switch s {
+		case DoNothing, SetZero, Error, SetNil:
 			opt.onNull = s
-			return
+		default:
+			opt.onNull = Continue
 		}
-		opt.onNull = Continue
 	}
 }
 
+// WithDialect 指定 Dialect，默认根据 driver 选择内置的或 RegisterDialect 注册的。
 func WithDialect(d Dialect) OptionDB {
 	return func(opt *optionDB) { opt.dialect = d }
 }
@@ -36,3 +132,74 @@ func WithDialect(d Dialect) OptionDB {
 func WithValueConverter(converter ValueConverter) OptionDB {
 	return func(opt *optionDB) { opt.vc = converter }
 }
+
+// WithTxRetry 设置 RunTx、RunTxWithOptions 和 RunTxContext 的重试策略，默认不重试。
+//
+//	db, err := NewDatabase("pgx", dsn, WithTxRetry(DefaultRetryPolicy))
+func WithTxRetry(p RetryPolicy) OptionDB {
+	return func(opt *optionDB) { opt.retry = p }
+}
+
+// WithErrorClassifier 指定判断错误类型的 ErrorClassifier，默认根据 driver 选择内置的或 RegisterErrorClassifier 注册的。
+func WithErrorClassifier(c ErrorClassifier) OptionDB {
+	return func(opt *optionDB) { opt.classifier = c }
+}
+
+// WithCodec 注册名为 name 的 Codec，字段的 tag 中使用 codec:name 指定。
+// 注册 json 或 gob 会覆盖内置的 Codec。
+func WithCodec(name string, c Codec) OptionDB {
+	return func(opt *optionDB) {
+		if opt.codecs == nil {
+			opt.codecs = map[string]Codec{}
+		}
+		opt.codecs[name] = c
+	}
+}
+
+// WithLocation 设置数据库的时区。
+//
+// 内置的 ValueConverter 在此时区中解析没有时区的时间字符串（默认为 time.Local），
+// 写入数据库的 time.Time 参数也会先转换到此时区。适用于应用运行在 UTC 的容器中、而数据库使用本地时间的情况。
+func WithLocation(loc *time.Location) OptionDB {
+	return func(opt *optionDB) { opt.loc = loc }
+}
+
+// WithTimeLayouts 设置内置的 ValueConverter 解析时间字符串时可接受的格式，依次尝试，默认为 DefaultTimeLayouts。
+// 第一个格式同时用于将时间格式化为字符串。
+func WithTimeLayouts(layouts ...string) OptionDB {
+	return func(opt *optionDB) { opt.layouts = layouts }
+}
+
+// WithUnixTime 设置整数列与 time.Time 字段之间转换时 unix 时间戳的单位，如 time.Millisecond，默认为 time.Second。
+//
+// 读取时整数列可以直接扫描到 time.Time 字段中；写入时字段需要使用 unix tag 选项。
+func WithUnixTime(unit time.Duration) OptionDB {
+	return func(opt *optionDB) { opt.unix = unit }
+}
+
+// WithTimePrecision 设置写入数据库的 time.Time 参数的精度，参数会按此精度四舍五入，
+// 如 MySQL 的 DATETIME 使用 time.Second，DATETIME(3) 使用 time.Millisecond。
+// 这样写入的值与数据库保存的值一致，之后用同一个值作为查询条件时不会因为精度不同而查不到。
+func WithTimePrecision(d time.Duration) OptionDB {
+	return func(opt *optionDB) { opt.precision = d }
+}
+
+// WithReplicas 配置只读从库，dsns 使用与主库相同的 driver。
+//
+// 配置后 Query 和 QueryMultiple 在从库执行，写入、RawQuery 和事务（RunTx 及 Tx 的所有方法）在主库执行。
+// 需要读取刚写入的数据时，使用 UsePrimary 选项或者 db.Primary()；RawQuery 需要在从库执行时使用 db.Replica()。
+// 连接失败的从库被暂时移除，直到健康检查成功；全部从库都不可用时在主库查询。
+func WithReplicas(dsns ...string) OptionDB {
+	return func(opt *optionDB) { opt.replicas = append(opt.replicas, dsns...) }
+}
+
+// WithReplicaPolicy 设置选择从库的方法，默认为 RoundRobin。
+func WithReplicaPolicy(p ReplicaPolicy) OptionDB {
+	return func(opt *optionDB) { opt.replicaPolicy = p }
+}
+
+// WithReplicaHealthCheck 设置检查从库健康状态（Ping）的间隔，默认为 DefaultReplicaHealthCheck，0 表示不检查。
+// 不检查时被移除的从库不会恢复。
+func WithReplicaHealthCheck(interval time.Duration) OptionDB {
+	return func(opt *optionDB) { opt.replicaCheck = interval }
+}
@@ -0,0 +1,79 @@
+package sqlwrapper
+
+import (
+	"database/sql/driver"
+	"reflect"
+	"testing"
+)
+
+type writeUser struct {
+	ID      int64
+	Name    string
+	Active  bool   `db:",always"`
+	Retries int    `db:",omitempty"`
+	Note    string `db:",nullzero"`
+	Manager *int64
+}
+
+func (writeUser) TableName() string { return "user" }
+func (writeUser) PkColumn() string  { return "id" }
+
+func TestWriteMode(t *testing.T) {
+	f := newFakeDB(t)
+	db := f.open(t, "")
+	for _, c := range []struct {
+		name    string
+		options []OptionExec
+		query   string
+		args    []driver.Value
+	}{
+		{
+			"default",
+			nil,
+			"update user set active = ?, note = ? where id = ?",
+			[]driver.Value{false, nil, int64(1)},
+		},
+		{
+			"including zeros",
+			[]OptionExec{IncludingZeros()},
+			"update user set name = ?, active = ?, note = ?, manager = ? where id = ?",
+			[]driver.Value{"", false, nil, nil, int64(1)},
+		},
+		{
+			"selected zeros",
+			[]OptionExec{IncludingZeros("retries", "manager")},
+			"update user set active = ?, retries = ?, note = ?, manager = ? where id = ?",
+			[]driver.Value{false, int64(0), nil, nil, int64(1)},
+		},
+	} {
+		if err := db.Update(&writeUser{ID: 1}, c.options...); err != nil {
+			t.Fatal(err)
+		}
+		exec := f.lastExec(t)
+		if exec.query != c.query || !reflect.DeepEqual(exec.args, c.args) {
+			t.Errorf("%s: got %s %v, want %s %v", c.name, exec.query, exec.args, c.query, c.args)
+		}
+	}
+
+	// no statement when every column is skipped
+	for _, columns := range [][]string{{"name", "retries"}, {"id"}} {
+		if err := db.Update(&writeUser{ID: 1}, WithColumns(columns...)); err != nil {
+			t.Fatal(err)
+		}
+		if execs := f.takeExecs(); len(execs) != 0 {
+			t.Errorf("%q: got %q, want no statement", columns, execs[0].query)
+		}
+	}
+
+	manager := int64(2)
+	if err := db.Insert(&writeUser{ID: 1, Name: "foo", Manager: &manager}); err != nil {
+		t.Fatal(err)
+	}
+	exec := f.lastExec(t)
+	if want := "insert into user (id, name, active, note, manager) values (?, ?, ?, ?, ?)"; exec.query != want {
+		t.Errorf("got %s, want %s", exec.query, want)
+	}
+	if want := []driver.Value{int64(1), "foo", false, nil, int64(2)}; !reflect.DeepEqual(exec.args, want) {
+		t.Errorf("got %v, want %v", exec.args, want)
+	}
+}
@@ -0,0 +1,187 @@
+package sqlwrapper
+
+import (
+	"errors"
+	"testing"
+)
+
+// emp 是本文件的测试中查询、更新和删除的表。
+type emp struct {
+	ID   int64
+	Name string
+}
+
+func (emp) TableName() string { return "emp" }
+func (emp) PkColumn() string  { return "id" }
+
+// sqlTest 是在 driver 的 Database 中执行 op 时预期的语句和参数个数，或者预期的错误。
+type sqlTest struct {
+	driver string
+	op     func(x Executor) error
+	query  string
+	nArgs  int
+	err    error
+}
+
+func queryEmp(options ...OptionQueryMultiple) func(x Executor) error {
+	return func(x Executor) error {
+		return x.QueryMultiple(&[]emp{}, append([]OptionQueryMultiple{Select("id", "name")}, options...)...)
+	}
+}
+
+func updateEmp(options ...OptionUpdate) func(x Executor) error {
+	return func(x Executor) error { return x.UpdateWhere("emp", options...) }
+}
+
+func deleteEmp(options ...OptionDelete) func(x Executor) error {
+	return func(x Executor) error { return x.DeleteWhere("emp", options...) }
+}
+
+// testSQL 用 ToSQL 渲染 tests 中的每个 op，与预期比较。
+func testSQL(t *testing.T, tests []sqlTest) {
+	t.Helper()
+	f := newFakeDB(t)
+	for i, test := range tests {
+		s, err := f.openAs(t, test.driver).ToSQL(test.op)
+		if !errors.Is(err, test.err) {
+			t.Errorf("#%d (%s): got error %v, want %v", i, test.driver, err, test.err)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if s.Query != test.query {
+			t.Errorf("#%d (%s): got\n%s\nwant\n%s", i, test.driver, s.Query, test.query)
+		}
+		if len(s.Args) != test.nArgs {
+			t.Errorf("#%d (%s): got %d args, want %d", i, test.driver, len(s.Args), test.nArgs)
+		}
+	}
+}
+
+var setOperationTests = []sqlTest{
+	{
+		driver: "mysql",
+		op: queryEmp(
+			Where("age < ?", 20),
+			Union(Where("age > ?", 60)),
+			OrderBy("age"),
+			Limit(10),
+		),
+		query: "select id, name from `emp` where age < ? union select id, name from `emp` where age > ? order by `age` limit ?",
+		nArgs: 3,
+	},
+	{
+		driver: "pgx",
+		op: queryEmp(
+			Where("age < ?", 20),
+			UnionAll(Select("id", "name"), From(Table("emp_archive"))),
+			Intersect(Where("gender = ?", 1)),
+		),
+		query: `select id, name from "emp" where age < $1 union all select id, name from "emp_archive" intersect select id, name from "emp" where gender = $2`,
+		nArgs: 2,
+	},
+	{
+		driver: "oracle",
+		op: queryEmp(
+			Except(Where("age > ?", 60)),
+			Limit(5),
+		),
+		query: `select id, name from "emp" minus select id, name from "emp" where age > :1 fetch next :2 rows only`,
+		nArgs: 2,
+	},
+	{
+		driver: "sqlserver",
+		op: queryEmp(
+			Union(Where("age > ?", 60), OrderBy("age desc"), Limit(3)),
+			Limit(5),
+		),
+		query: "select id, name from [emp] union (select id, name from [emp] where age > @p1 order by [age] desc offset @p2 rows fetch next @p3 rows only) order by 1 offset @p4 rows fetch next @p5 rows only",
+		nArgs: 5,
+	},
+}
+
+func TestSetOperations(t *testing.T) { testSQL(t, setOperationTests) }
+
+var paginationTests = []sqlTest{
+	{driver: "mysql", op: queryEmp(Offset(5)), query: "select id, name from `emp` limit ? offset ?", nArgs: 2},
+	{driver: "sqlite", op: queryEmp(Limit(5), Offset(5)), query: "select id, name from `emp` limit ? offset ?", nArgs: 2},
+	{driver: "sqlserver", op: queryEmp(OrderBy("id")), query: "select id, name from [emp] order by [id]", nArgs: 0},
+	{driver: "sqlserver", op: queryEmp(OrderBy("id"), Limit(5)), query: "select id, name from [emp] order by [id] offset @p1 rows fetch next @p2 rows only", nArgs: 2},
+	{driver: "oracle", op: queryEmp(Limit(5), Offset(5)), query: `select id, name from "emp" offset :1 rows fetch next :2 rows only`, nArgs: 2},
+}
+
+func TestPagination(t *testing.T) { testSQL(t, paginationTests) }
+
+var lockingTests = []sqlTest{
+	{driver: "pgx", op: queryEmp(Limit(10), ForUpdate(), SkipLocked()), query: `select id, name from "emp" limit $1 for update skip locked`, nArgs: 1},
+	{driver: "mysql", op: queryEmp(ForShare()), query: "select id, name from `emp` for share", nArgs: 0},
+	{driver: "oracle", op: queryEmp(Where("id = ?", 1), NoWait()), query: `select id, name from "emp" where id = :1 for update nowait`, nArgs: 1},
+	{driver: "sqlserver", op: queryEmp(From(Table("emp"), As("e")), ForUpdate(), SkipLocked()), query: "select id, name from [emp] as [e] with (updlock, rowlock, readpast)", nArgs: 0},
+	{driver: "sqlite", op: queryEmp(ForShare()), err: ErrLockNotSupported},
+	{driver: "oracle", op: queryEmp(ForShare()), err: ErrLockNotSupported},
+
+	// oracle cannot lock rows with fetch directly, the rows are locked by rowid
+	{
+		driver: "oracle",
+		op:     queryEmp(Where("dept = ?", 1), OrderBy("id"), Limit(5), ForUpdate(), SkipLocked()),
+		query:  `select id, name from "emp" where rowid in (select rowid from "emp" where dept = :1 order by "id" fetch next :2 rows only) order by "id" for update skip locked`,
+		nArgs:  2,
+	},
+	{driver: "oracle", op: queryEmp(Limit(5), ForShare()), err: ErrLockNotSupported},
+	{driver: "oracle", op: queryEmp(From(Table("emp"), InnerJoin(Table("dept"), On("emp.dept_id = dept.id"))), Limit(5), ForUpdate()), err: ErrLockNotSupported},
+	{driver: "oracle", op: queryEmp(GroupBy("dept"), Offset(5), ForUpdate()), err: ErrLockNotSupported},
+
+	{driver: "pgx", op: queryEmp(Union(Where("id > ?", 1)), ForUpdate()), err: ErrLockWithSetOperation},
+	{driver: "pgx", op: queryEmp(UnionAll(Where("id > ?", 1), ForShare())), err: ErrLockWithSetOperation},
+}
+
+func TestLocking(t *testing.T) { testSQL(t, lockingTests) }
+
+var returningIDs = Returning(ScanFn(nil), "id")
+
+var updateWhereTests = []sqlTest{
+	{driver: "mysql", op: updateEmp(Set("count = count + ?", 1), Where("id = ?", 1), Limit(1)), query: "update `emp` set count = count + ? where id = ? limit ?", nArgs: 3},
+	{driver: "mysql", op: updateEmp(Set("emp.level = dept.level"), From(Table("dept")), Where("emp.dept_id = dept.id")), query: "update `emp`, `dept` set emp.level = dept.level where emp.dept_id = dept.id"},
+	{driver: "pgx", op: updateEmp(Set("level = dept.level"), From(Table("dept")), Where("emp.dept_id = dept.id"), returningIDs), query: `update "emp" set level = dept.level from "dept" where emp.dept_id = dept.id returning "id"`},
+	{driver: "sqlserver", op: updateEmp(Set("status = ?", 1), Limit(10), returningIDs), query: "update top (@p1) [emp] set status = @p2 output inserted.[id]", nArgs: 2},
+	{driver: "oracle", op: updateEmp(Set("status = ?", 1), From(Table("dept"))), err: ErrFromNotSupported},
+	{driver: "pgx", op: updateEmp(Set("status = ?", 1), Limit(10)), err: ErrLimitNotSupported},
+	{driver: "mysql", op: updateEmp(Set("emp.level = dept.level"), From(Table("dept")), Where("emp.dept_id = dept.id"), Limit(1)), err: ErrMultiTableLimit},
+	{driver: "mysql", op: updateEmp(Set("status = ?", 1), returningIDs), err: ErrReturningNotSupported},
+	{driver: "mysql", op: updateEmp(Where("id = ?", 1)), err: ErrEmptySet},
+}
+
+func TestUpdateWhere(t *testing.T) { testSQL(t, updateWhereTests) }
+
+var returningAll = Returning(ScanFn(nil), "*")
+
+var deleteWhereTests = []sqlTest{
+	{driver: "mysql", op: deleteEmp(Where("id = ?", 1)), query: "delete from `emp` where id = ?", nArgs: 1},
+	{driver: "mysql", op: deleteEmp(From(Table("dept")), Where("emp.dept_id = dept.id")), query: "delete `emp` from `emp`, `dept` where emp.dept_id = dept.id"},
+	{driver: "pgx", op: deleteEmp(From(Table("dept")), Where("emp.dept_id = dept.id"), returningAll), query: `delete from "emp" using "dept" where emp.dept_id = dept.id returning *`},
+	{driver: "sqlite", op: deleteEmp(Where("id = ?", 1), returningAll), query: "delete from `emp` where id = ? returning *", nArgs: 1},
+	{driver: "sqlserver", op: deleteEmp(Limit(5), Where("status = ?", 2), returningAll), query: "delete top (@p1) from [emp] output deleted.* where status = @p2", nArgs: 2},
+	{driver: "sqlite", op: deleteEmp(From(Table("dept"))), err: ErrFromNotSupported},
+	{driver: "mysql", op: deleteEmp(Where("id = ?", 1), Limit(1)), query: "delete from `emp` where id = ? limit ?", nArgs: 2},
+	{driver: "mysql", op: deleteEmp(From(Table("dept"), InnerJoin(Table("loc"), On("dept.loc_id = loc.id"))), Where("emp.dept_id = dept.id"), Limit(1)), err: ErrMultiTableLimit},
+}
+
+func TestDeleteWhere(t *testing.T) { testSQL(t, deleteWhereTests) }
+
+var insertSelectTests = []sqlTest{
+	{
+		driver: "pgx",
+		op: func(x Executor) error {
+			return x.InsertSelect("emp_archive", []string{"id", "fullname"},
+				Select("id", "fullname"),
+				From(SubQuery(From(Table("emp")), Where("age > ?", 60)), As("e")),
+				Where("e.gender = ?", 1),
+			)
+		},
+		query: `insert into "emp_archive" ("id", "fullname") select id, fullname from (select * from "emp" where age > $1) as "e" where e.gender = $2`,
+		nArgs: 2,
+	},
+}
+
+func TestInsertSelect(t *testing.T) { testSQL(t, insertSelectTests) }
@@ -0,0 +1,217 @@
+package sqlwrapper
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RenderedSQL 是 ToSQL 渲染出的语句和参数。String 返回把参数代入占位符后的语句，用于日志和调试。
+type RenderedSQL struct {
+	Query string
+	Args  []interface{}
+
+	dialect Dialect
+}
+
+func (s RenderedSQL) String() string {
+	if s.dialect == nil {
+		return s.Query
+	}
+	return Interpolate(s.dialect, s.Query, s.Args)
+}
+
+// errDryRun 在 dryRun 记录语句后中止操作。
+var errDryRun = errors.New("dry run")
+
+// ToSQL 渲染 op 中第一个操作的语句和参数，不会访问数据库。op 使用的 Executor 与 db 的 Dialect 和选项相同，
+// 所以渲染的结果与 op 中的操作实际执行的语句完全一致。
+//
+//	s, err := db.ToSQL(func(x Executor) error {
+//	    _, err := x.Query(&u, Where("id = ?", 1))
+//	    return err
+//	})
+//	// s.Query: select "id", "name" from "user" where id = $1 limit $2
+//	// s.Args:  [1 1]
+//	// s.String(): select "id", "name" from "user" where id = 1 limit 1
+//
+// 行锁选项视为在事务中使用。Insert 渲染的是主键赋值前的语句，例如 postgresql 中主键为〇值时带有 returning。
+// op 中的操作没有生成语句时（如 Update 没有需要更新的列）返回空的 RenderedSQL。
+func (db *Database) ToSQL(op func(x Executor) error) (RenderedSQL, error) {
+	d := &dryRun{db: db}
+	if err := op(d); err != nil && !errors.Is(err, errDryRun) {
+		return RenderedSQL{}, err
+	}
+	return d.stmt, nil
+}
+
+// dryRun 是只记录语句而不执行的 Executor，见 ToSQL。
+type dryRun struct {
+	db   *Database
+	stmt RenderedSQL
+}
+
+func (d *dryRun) record(query string, args []interface{}) error {
+	if len(d.stmt.Query) == 0 {
+		// args belongs to a pooled SqlCtx. time arguments are normalized as the executed ones, see WithLocation
+		d.stmt = RenderedSQL{query, append([]interface{}{}, d.db.normalizeArgs(args)...), d.db.dialect}
+	}
+	return errDryRun
+}
+
+func (d *dryRun) Insert(e IEntity, options ...OptionExec) error {
+	return insert(d.db, d, e, options)
+}
+
+func (d *dryRun) Update(e IEntity, options ...OptionExec) error {
+	return update(d.db, d, e, options)
+}
+
+func (d *dryRun) Save(e IEntity, options ...OptionExec) error {
+	return save(d.db, d, e, options)
+}
+
+func (d *dryRun) Query(entity interface{}, options ...OptionQuerySingle) (found bool, err error) {
+	return query(d.db, d, entity, options)
+}
+
+func (d *dryRun) QueryMultiple(es interface{}, options ...OptionQueryMultiple) error {
+	return queryMultiple(d.db, d, es, options)
+}
+
+func (d *dryRun) InsertSelect(table string, columns []string, options ...OptionQueryMultiple) error {
+	return insertSelect(d.db, d, table, columns, options)
+}
+
+func (d *dryRun) UpdateWhere(table string, options ...OptionUpdate) error {
+	return updateWhere(d.db, d, table, options)
+}
+
+func (d *dryRun) DeleteWhere(table string, options ...OptionDelete) error {
+	return deleteWhere(d.db, d, table, options)
+}
+
+func (d *dryRun) Delete(table string, options ...OptionDelete) error {
+	return deleteWhere(d.db, d, table, options)
+}
+
+func (d *dryRun) RawExec(query string, args ...interface{}) (sql.Result, error) {
+	return nil, d.record(query, args)
+}
+
+func (d *dryRun) RawQuery(query string, _ RowsScanner, args ...interface{}) error {
+	return d.record(query, args)
+}
+
+// inTx 判断 x 是否可以使用行锁选项。
+func inTx(x Executor) bool {
+	switch x.(type) {
+	case *Tx, *dryRun:
+		return true
+	}
+	return false
+}
+
+// Interpolated 返回把参数代入占位符后的语句，见 Interpolate。
+func (ctx *SqlCtx) Interpolated() string {
+	return Interpolate(ctx.dialect, ctx.QueryString(), ctx.args)
+}
+
+// Interpolate 把 args 代入 query 中 dialect 的占位符，返回可以直接阅读的语句，只用于日志和调试，不能用于执行。
+// 单引号中的字符串不变，没有对应参数的占位符保持原样。
+//
+//	Interpolate(GetDialect("pgx"), "select * from t where name = $1 and id = $2", []interface{}{"it's", 1})
+//	// select * from t where name = 'it''s' and id = 1
+func Interpolate(dialect Dialect, query string, args []interface{}) string {
+	p1 := dialect.HoldPlace(1)
+	sequential := p1 == dialect.HoldPlace(2)
+	prefix := strings.TrimSuffix(p1, "1")
+
+	b := make([]byte, 0, len(query)+8*len(args))
+	next := 0
+	for i := 0; i < len(query); i++ {
+		c := query[i]
+		if c == '\'' {
+			// copy the string literal, '' is an escaped quote
+			j := i + 1
+			for j < len(query) {
+				if query[j] == '\'' {
+					if j+1 < len(query) && query[j+1] == '\'' {
+						j += 2
+						continue
+					}
+					break
+				}
+				j++
+			}
+			j = min(j+1, len(query))
+			b = append(b, query[i:j]...)
+			i = j - 1
+			continue
+		}
+		if sequential {
+			if strings.HasPrefix(query[i:], p1) && next < len(args) {
+				b = append(b, literal(args[next])...)
+				next++
+				i += len(p1) - 1
+				continue
+			}
+		} else if len(prefix) > 0 && strings.HasPrefix(query[i:], prefix) {
+			j := i + len(prefix)
+			for j < len(query) && query[j] >= '0' && query[j] <= '9' {
+				j++
+			}
+			if n, err := strconv.Atoi(query[i+len(prefix) : j]); err == nil && n > 0 && n <= len(args) {
+				b = append(b, literal(args[n-1])...)
+				i = j - 1
+				continue
+			}
+		}
+		b = append(b, c)
+	}
+	return string(b)
+}
+
+// literal 返回 v 的 SQL 字面量形式。
+func literal(v interface{}) string {
+	if rv := reflect.ValueOf(v); rv.Kind() == reflect.Ptr {
+		if rv.IsNil() {
+			return "NULL"
+		}
+		if _, ok := v.(driver.Valuer); !ok {
+			return literal(rv.Elem().Interface())
+		}
+	}
+	if vr, ok := v.(driver.Valuer); ok {
+		dv, err := vr.Value()
+		if err != nil {
+			return fmt.Sprintf("<%v>", err)
+		}
+		v = dv
+	}
+	switch v := v.(type) {
+	case nil:
+		return "NULL"
+	case string:
+		return "'" + strings.ReplaceAll(v, "'", "''") + "'"
+	case []byte:
+		return "X'" + hex.EncodeToString(v) + "'"
+	case time.Time:
+		return "'" + v.Format("2006-01-02 15:04:05.999999999Z07:00") + "'"
+	case bool:
+		return strconv.FormatBool(v)
+	}
+	switch reflect.ValueOf(v).Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return fmt.Sprint(v)
+	}
+	return literal(fmt.Sprint(v))
+}
@@ -0,0 +1,127 @@
+package sqlwrapper
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+type toSQLCase struct {
+	name string
+	op   func(x Executor) error
+}
+
+func TestToSQL(t *testing.T) {
+	created := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	ops := []toSQLCase{
+		{"insert", func(x Executor) error {
+			return x.Insert(&writeUser{Name: "it's", Active: true})
+		}},
+		{"insert with pk", func(x Executor) error {
+			return x.Insert(&writeUser{ID: 1, Name: "foo", Retries: 2})
+		}},
+		{"update", func(x Executor) error {
+			return x.Update(&writeUser{ID: 1, Name: "foo"}, WithColumns("name"))
+		}},
+		{"save", func(x Executor) error {
+			return x.Save(&writeUser{ID: 2, Name: "foo"})
+		}},
+		{"query", func(x Executor) error {
+			_, err := x.Query(&scanUser{}, Where("id = ?", 1), ForUpdate())
+			return err
+		}},
+		{"query multiple", func(x Executor) error {
+			return x.QueryMultiple(&[]scanUser{},
+				Select("id", "name"),
+				Where("score > ? and name <> ?", 60.5, ""),
+				OrderBy("id desc"),
+				Offset(10),
+				Limit(5),
+			)
+		}},
+		{"insert select", func(x Executor) error {
+			return x.InsertSelect("user_archive", []string{"id", "name"},
+				Select("id", "name"),
+				From(Table("user")),
+				Where("created_at < ?", created),
+			)
+		}},
+		{"update where", func(x Executor) error {
+			return x.UpdateWhere("user", Set("name = ?", "bar"), Set("remark = ?", nil), Where("id = ?", 1))
+		}},
+		{"delete where", func(x Executor) error {
+			return x.DeleteWhere("user", Where("id in (?, ?)", 1, 2), Limit(1))
+		}},
+	}
+
+	f := newFakeDB(t)
+	var b strings.Builder
+	render := func(driver string, ops []toSQLCase, options ...OptionDB) {
+		db := f.openAs(t, driver, options...)
+		for _, c := range ops {
+			fmt.Fprintf(&b, "-- %s (%s)\n", c.name, driver)
+			s, err := db.ToSQL(c.op)
+			if err != nil {
+				fmt.Fprintf(&b, "error: %v\n\n", err)
+				continue
+			}
+			fmt.Fprintf(&b, "%s\n%v\n%s\n\n", s.Query, s.Args, s)
+		}
+	}
+	for _, driver := range []string{"mysql", "sqlite", "pgx", "sqlserver", "oracle"} {
+		render(driver, ops)
+	}
+	// time arguments are rendered as they are executed
+	render("mysql", []toSQLCase{
+		{"location and precision", func(x Executor) error {
+			return x.UpdateWhere("user",
+				Set("updated_at = ?", time.Date(2024, 1, 2, 3, 4, 5, 678900000, time.UTC)),
+				Where("created_at < ?", &created),
+			)
+		}},
+	}, WithLocation(time.FixedZone("UTC+8", 8*3600)), WithTimePrecision(time.Millisecond))
+	if n := len(f.takeExecs()); n != 0 {
+		t.Errorf("got %d executed statements, want none", n)
+	}
+
+	golden := "testdata/tosql.golden"
+	if os.Getenv("UPDATE_GOLDEN") == "1" {
+		if err := os.WriteFile(golden, []byte(b.String()), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want, err := os.ReadFile(golden)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b.String() != string(want) {
+		t.Errorf("rendered statements differ from %s:\n%s", golden, b.String())
+	}
+}
+
+func TestInterpolate(t *testing.T) {
+	for _, c := range []struct {
+		driver string
+		query  string
+		args   []interface{}
+		want   string
+	}{
+		{"mysql", "select * from t where a = ? and b = '?' and c = ?", []interface{}{"x", 2}, "select * from t where a = 'x' and b = '?' and c = 2"},
+		{"pgx", "select $2, $1, $3", []interface{}{nil, []byte("ab")}, "select X'6162', NULL, $3"},
+		{"sqlserver", "select @p1, @p10", []interface{}{1, 2, 3, 4, 5, 6, 7, 8, 9, 10.5}, "select 1, 10.5"},
+		{"oracle", "select ':1''s', :1 from dual", []interface{}{"it's"}, "select ':1''s', 'it''s' from dual"},
+	} {
+		if got := Interpolate(GetDialect(c.driver), c.query, c.args); got != c.want {
+			t.Errorf("%s: got %q, want %q", c.driver, got, c.want)
+		}
+	}
+
+	ctx := NewContext("pgx", GetDialect("pgx"))
+	n := int64(3)
+	ctx.WriteString("select ").NextPlaceholder(&n).WriteString(", ").NextPlaceholder((*int64)(nil))
+	if got, want := ctx.Interpolated(), "select 3, NULL"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,194 @@
+package sqlwrapper
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+// ReplicaPolicy 指定从多个从库中选择一个执行查询的方法，见 WithReplicaPolicy。
+type ReplicaPolicy int8
+
+const (
+	RoundRobin   ReplicaPolicy = iota // 依次使用健康的从库（默认）
+	LeastLatency                      // 使用平均延迟最低的健康的从库
+)
+
+// DefaultReplicaHealthCheck 是检查从库健康状态的默认间隔。
+const DefaultReplicaHealthCheck = 10 * time.Second
+
+// replica 是一个从库。
+type replica struct {
+	origin  *sql.DB
+	healthy atomic.Bool
+	// latency 是查询和 Ping 耗时的指数移动平均值（纳秒）
+	latency atomic.Int64
+}
+
+// observe 记录一次查询或 Ping 的耗时。连接错误时将从库标记为不健康，由健康检查恢复。
+func (r *replica) observe(d time.Duration, err error) {
+	if err != nil {
+		if connectionError(err) {
+			r.healthy.Store(false)
+		}
+		return
+	}
+	old := r.latency.Load()
+	r.latency.Store(old + (int64(d)-old)/8)
+}
+
+func (r *replica) query(ctx context.Context, query string, args []interface{}) (*sql.Rows, error) {
+	start := time.Now()
+	rows, err := r.origin.QueryContext(ctx, query, args...)
+	r.observe(time.Since(start), err)
+	return rows, err
+}
+
+// connectionError 判断 err 是否说明无法连接到数据库，而不是语句本身出错。
+func connectionError(err error) bool {
+	if errors.Is(err, driver.ErrBadConn) {
+		return true
+	}
+	var ne net.Error
+	return errors.As(err, &ne)
+}
+
+// replicaSet 是 Database 的全部从库。
+type replicaSet struct {
+	policy   ReplicaPolicy
+	replicas []*replica
+	next     atomic.Uint64
+}
+
+// openReplicas 打开 dsns 对应的从库。Ping 失败的从库先标记为不健康，不影响主库的使用。
+func openReplicas(driver string, o *optionDB) (*replicaSet, error) {
+	rs := &replicaSet{policy: o.replicaPolicy}
+	for _, dsn := range o.replicas {
+		origin, err := sql.Open(driver, dsn)
+		if err != nil {
+			rs.close()
+			return nil, err
+		}
+		for _, f := range o.pool {
+			f(origin)
+		}
+		r := &replica{origin: origin}
+		r.healthy.Store(true)
+		rs.replicas = append(rs.replicas, r)
+	}
+	if o.ping {
+		rs.check(context.Background(), o.pingTimeout)
+	}
+	return rs, nil
+}
+
+// pick 按 policy 选择一个健康的从库。没有配置从库或者全部不健康时返回 nil，由主库执行。
+func (rs *replicaSet) pick() *replica {
+	if rs == nil {
+		return nil
+	}
+	n := len(rs.replicas)
+	switch rs.policy {
+	case LeastLatency:
+		var best *replica
+		for _, r := range rs.replicas {
+			if r.healthy.Load() && (best == nil || r.latency.Load() < best.latency.Load()) {
+				best = r
+			}
+		}
+		return best
+	default:
+		start := rs.next.Add(1)
+		for i := 0; i < n; i++ {
+			if r := rs.replicas[(start+uint64(i))%uint64(n)]; r.healthy.Load() {
+				return r
+			}
+		}
+		return nil
+	}
+}
+
+// check Ping 所有从库并更新健康状态，timeout 为每次 Ping 的超时时间，0 表示不限制。
+func (rs *replicaSet) check(ctx context.Context, timeout time.Duration) {
+	for _, r := range rs.replicas {
+		start := time.Now()
+		err := pingTimeout(ctx, r.origin, timeout)
+		if ctx.Err() != nil {
+			// db is closed
+			return
+		}
+		r.healthy.Store(err == nil)
+		r.observe(time.Since(start), err)
+	}
+}
+
+// watch 每隔 interval 检查一次从库，直到 ctx 结束。
+func (rs *replicaSet) watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			rs.check(ctx, interval)
+		}
+	}
+}
+
+func (rs *replicaSet) close() {
+	if rs == nil {
+		return
+	}
+	for _, r := range rs.replicas {
+		r.origin.Close()
+	}
+}
+
+// Primary 返回在主库执行所有语句的 Executor，用于读取刚写入的数据（read-your-writes）。
+// 没有配置从库时返回 db 本身。
+//
+//	db.Primary().QueryMultiple(&orders, Where("user_id = ?", userID))
+func (db *Database) Primary() Executor {
+	if db.replicas == nil {
+		return db
+	}
+	return primary{db}
+}
+
+// primary 将 Query、QueryMultiple 和 RawQuery 固定在主库执行，其他方法本来就在主库执行。
+type primary struct{ *Database }
+
+func (p primary) Query(entity interface{}, options ...OptionQuerySingle) (found bool, err error) {
+	return query(p.Database, p, entity, options)
+}
+
+func (p primary) QueryMultiple(es interface{}, options ...OptionQueryMultiple) error {
+	return queryMultiple(p.Database, p, es, options)
+}
+
+func (p primary) RawQuery(query string, s RowsScanner, args ...interface{}) error {
+	return p.rawQuery(nil, query, s, args)
+}
+
+// Replica 返回 RawQuery 也在从库执行的 Executor，query 必须是只读的查询。其他方法与 db 相同：
+// Query 和 QueryMultiple 在从库执行，写入在主库执行。没有配置从库时返回 db 本身。
+//
+//	db.Replica().RawQuery("select count(*) from orders where user_id = ?", SingleRowScanner(&n), userID)
+func (db *Database) Replica() Executor {
+	if db.replicas == nil {
+		return db
+	}
+	return replicaReader{db}
+}
+
+// replicaReader 将 RawQuery 放到从库执行。
+type replicaReader struct{ *Database }
+
+func (r replicaReader) RawQuery(query string, s RowsScanner, args ...interface{}) error {
+	return r.rawQuery(r.replicas.pick(), query, s, args)
+}
@@ -0,0 +1,105 @@
+package sqlwrapper
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestReplicas(t *testing.T) {
+	f := newFakeDB(t)
+	db := f.open(t, "primary",
+		WithReplicas(f.dsn("replica1"), f.dsn("replica2")),
+		WithReplicaHealthCheck(0),
+	)
+
+	queried := func(name string, want ...string) {
+		t.Helper()
+		if got := f.takeQueried(); !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: got %q, want %q", name, got, want)
+		}
+	}
+	var u scanUser
+	f.takeQueried()
+	db.Query(&u)
+	db.QueryMultiple(&[]scanUser{})
+	db.Replica().RawQuery("select 1", SingleRowScanner(new(int)))
+	queried("round robin", "replica2", "replica1", "replica2")
+
+	db.Query(&u, UsePrimary())
+	db.RawQuery("select 1", SingleRowScanner(new(int)))
+	db.Primary().RawQuery("select 1", SingleRowScanner(new(int)))
+	db.Primary().QueryMultiple(&[]scanUser{})
+	db.RunTx(func(tx *Tx) (bool, error) {
+		_, err := tx.Query(&u)
+		return false, err
+	})
+	queried("primary", "primary", "primary", "primary", "primary", "primary")
+
+	// schema introspection reads from the primary
+	db.Tables()
+	db.TableInfo("user")
+	got := f.takeQueried()
+	for _, name := range got {
+		if name != "primary" {
+			t.Errorf("schema: got a query on %s, want only the primary", name)
+		}
+	}
+	if len(got) == 0 {
+		t.Error("schema: got no queries")
+	}
+
+	// a replica is ejected on connection errors and comes back after a successful health check
+	f.setDown("replica1", true)
+	db.Query(&u)
+	if _, err := db.Query(&u); err != nil {
+		t.Fatal(err)
+	}
+	f.takeQueried()
+	db.Query(&u)
+	db.Query(&u)
+	queried("ejected", "replica2", "replica2")
+	db.replicas.check(context.Background(), 0)
+	f.setDown("replica1", false)
+	db.Query(&u)
+	queried("still down", "replica2")
+	db.replicas.check(context.Background(), 0)
+	db.Query(&u)
+	db.Query(&u)
+	got = f.takeQueried()
+	sort.Strings(got)
+	if want := []string{"replica1", "replica2"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("recovered: got %q, want %q", got, want)
+	}
+
+	f.setDown("replica1", true)
+	f.setDown("replica2", true)
+	db.replicas.check(context.Background(), 0)
+	db.Query(&u)
+	queried("all down", "primary")
+}
+
+func TestLeastLatency(t *testing.T) {
+	rs := &replicaSet{policy: LeastLatency}
+	for _, latency := range []time.Duration{3, 1, 2} {
+		r := &replica{}
+		r.healthy.Store(true)
+		r.latency.Store(int64(latency * time.Millisecond))
+		rs.replicas = append(rs.replicas, r)
+	}
+	if got := rs.pick(); got != rs.replicas[1] {
+		t.Errorf("got %v, want the replica with the lowest latency", got.latency.Load())
+	}
+	rs.replicas[1].healthy.Store(false)
+	if got := rs.pick(); got != rs.replicas[2] {
+		t.Errorf("got %v, want the healthy replica with the lowest latency", got.latency.Load())
+	}
+	rs.replicas[1].healthy.Store(true)
+	rs.replicas[1].observe(10*time.Millisecond, nil)
+	rs.replicas[1].observe(20*time.Millisecond, nil)
+	if got := rs.pick(); got != rs.replicas[2] {
+		t.Errorf("got %v, want the replica with the lowest average latency", got.latency.Load())
+	}
+}
@@ -0,0 +1,66 @@
+package sqlwrapper
+
+import (
+	"math/rand"
+	"time"
+)
+
+// RetryPolicy 是 RunTx 在事务失败时重新执行的策略，用 WithTxRetry 设置。
+//
+// 事务的任何一步（BeginTx、run、Commit）返回的错误属于 Retryable 时，回滚当前事务，
+// 等待一段时间后用新的 Tx 再次调用 run，直到成功、错误不可重试或者达到 MaxAttempts。
+// 因此 run 可能被执行多次，不应该有事务以外的副作用。
+type RetryPolicy struct {
+	// MaxAttempts 是最多执行的次数（包括第一次），不大于 1 时不重试。
+	MaxAttempts int
+
+	// Backoff 是第一次重试前等待的时间，之后每次翻倍，不超过 MaxBackoff（为 0 时不限制）。
+	// 实际等待的时间在 [d/2, d] 之间随机，避免冲突的事务同时重试再次冲突。
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+
+	// Retryable 是可以重试的错误类型，为空时为 Deadlock 和 SerializationFailure。
+	// 错误类型由 Database 的 ErrorClassifier 判断。
+	Retryable []ErrorKind
+
+	// OnRetry 在每次重试前调用，attempt 是已经执行的次数，err 是最后一次的错误。可以用于记录日志和监控。
+	OnRetry func(attempt int, err error)
+}
+
+// DefaultRetryPolicy 最多执行 3 次，在死锁和串行化失败时重试。
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts: 3,
+	Backoff:     10 * time.Millisecond,
+	MaxBackoff:  time.Second,
+}
+
+func (p *RetryPolicy) retryable(kind ErrorKind) bool {
+	if len(p.Retryable) == 0 {
+		return kind == Deadlock || kind == SerializationFailure
+	}
+	for _, k := range p.Retryable {
+		if k == kind {
+			return true
+		}
+	}
+	return false
+}
+
+// backoff 返回第 attempt 次失败后等待的时间。
+func (p *RetryPolicy) backoff(attempt int) time.Duration {
+	d := p.Backoff
+	for i := 1; i < attempt && d > 0; i++ {
+		d *= 2
+		if p.MaxBackoff > 0 && d >= p.MaxBackoff {
+			break
+		}
+	}
+	if p.MaxBackoff > 0 && d > p.MaxBackoff {
+		d = p.MaxBackoff
+	}
+	if d <= 0 {
+		return 0
+	}
+	// equal jitter
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
@@ -0,0 +1,85 @@
+package sqlwrapper
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRetryBackoff(t *testing.T) {
+	p := RetryPolicy{Backoff: 10 * time.Millisecond, MaxBackoff: 50 * time.Millisecond}
+	for attempt, max := range map[int]time.Duration{1: 10, 2: 20, 3: 40, 4: 50, 10: 50} {
+		max *= time.Millisecond
+		for i := 0; i < 10; i++ {
+			if d := p.backoff(attempt); d < max/2 || d > max {
+				t.Errorf("attempt %d: %v not in [%v, %v]", attempt, d, max/2, max)
+			}
+		}
+	}
+	if d := (&RetryPolicy{}).backoff(3); d != 0 {
+		t.Errorf("got %v, want 0", d)
+	}
+}
+
+func TestRunTxRetry(t *testing.T) {
+	retries := 0
+	f := newFakeDB(t)
+	db := f.open(t, "",
+		WithErrorClassifier(mysqlClassifier),
+		WithTxRetry(RetryPolicy{
+			MaxAttempts: 3,
+			Backoff:     time.Millisecond,
+			OnRetry:     func(attempt int, err error) { retries++ },
+		}),
+	)
+	deadlock := &mysqlError{1213, "Deadlock found when trying to get lock"}
+
+	attempts := 0
+	step, err := db.RunTx(func(tx *Tx) (bool, error) {
+		attempts++
+		if tx.Attempt() != attempts {
+			t.Errorf("got attempt %d, want %d", tx.Attempt(), attempts)
+		}
+		if attempts < 3 {
+			return false, deadlock
+		}
+		return true, nil
+	})
+	if commits, _ := f.takeTxCounts(); step != StepEnd || err != nil || attempts != 3 || retries != 2 || commits != 1 {
+		t.Errorf("got %v %v, %d attempts, %d retries, %d commits", step, err, attempts, retries, commits)
+	}
+
+	attempts = 0
+	step, err = db.RunTx(func(tx *Tx) (bool, error) {
+		attempts++
+		return false, deadlock
+	})
+	var me *mysqlError
+	if step != StepRun || attempts != 3 || !errors.As(err, &me) || !strings.Contains(err.Error(), "after 3 attempts") {
+		t.Errorf("got %v %v, %d attempts", step, err, attempts)
+	}
+
+	attempts = 0
+	unique := &mysqlError{1062, "Duplicate entry"}
+	_, err = db.RunTx(func(tx *Tx) (bool, error) {
+		attempts++
+		return false, unique
+	})
+	if attempts != 1 || err != unique {
+		t.Errorf("non-retryable error: got %v, %d attempts", err, attempts)
+	}
+
+	attempts = 0
+	ctx, cancel := context.WithCancel(context.Background())
+	db.retry.OnRetry = func(int, error) { cancel() }
+	db.retry.Backoff = time.Hour
+	_, err = db.RunTxContext(ctx, func(tx *Tx) (bool, error) {
+		attempts++
+		return false, deadlock
+	}, nil)
+	if attempts != 1 || !errors.As(err, &me) {
+		t.Errorf("cancelled: got %v, %d attempts", err, attempts)
+	}
+}
@@ -2,6 +2,8 @@ package sqlwrapper
 
 import (
 	"database/sql"
+	"errors"
+	"fmt"
 	"reflect"
 )
 
@@ -68,6 +70,146 @@ func (fn ScanFn) ScanFrom(r *sql.Rows) error {
 	return fn(r)
 }
 
+// scanPlan 记录每一列的扫描方式，在第一次调用 rows.Columns 和 rows.ColumnTypes 后构建，之后每一行复用。
+//
+// 使用内置的 ValueConverter 时，可以直接扫描的列（字段实现了 sql.Scanner，或者驱动报告该列不为 NULL 且类型与字段一致，
+// 时间除外）直接扫描到字段中，其他列扫描到 *interface{} 后再用 ValueConverter 转换。
+type scanPlan struct {
+	cols []string
+	// fields 是每一列对应的字段位置，-1 代表结构体中没有该列。
+	fields []int
+	direct []bool
+	// codecs 是使用 Codec 解码的列的 Codec，其他列为 nil。
+	codecs []Codec
+	// onNull 是每一列的 NULL 值处理方式，字段 tag 中的 onnull 优先于 Database 的设置。
+	onNull []Strategy
+	dest   []interface{}
+	values []interface{}
+	meta   *structMeta
+}
+
+// scanOptions 是扫描时使用的转换设置，通常来自 Database。
+type scanOptions struct {
+	converter ValueConverter
+	onNull    Strategy
+	codec     func(name string) (Codec, error)
+}
+
+func (db *Database) scanOptions() scanOptions {
+	return scanOptions{converter: db.vc, onNull: db.onNull, codec: db.codec}
+}
+
+var (
+	scannerType     = reflect.TypeOf((*sql.Scanner)(nil)).Elem()
+	nullWrapperType = reflect.TypeOf((*nullWrapper)(nil)).Elem()
+	rawBytesType    = reflect.TypeOf(sql.RawBytes(nil))
+)
+
+func newScanPlan(rows *sql.Rows, meta *structMeta, o scanOptions) (*scanPlan, error) {
+	cols, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+	// 获取不到列类型时所有列都使用 ValueConverter
+	types, _ := rows.ColumnTypes()
+	n := len(cols)
+	p := &scanPlan{
+		cols:   cols,
+		fields: make([]int, n),
+		direct: make([]bool, n),
+		codecs: make([]Codec, n),
+		onNull: make([]Strategy, n),
+		dest:   make([]interface{}, n),
+		values: make([]interface{}, n),
+		meta:   meta,
+	}
+	// 自定义的 ValueConverter 可能改变任何值，所有列都使用 ValueConverter
+	_, builtin := o.converter.(vcie)
+	for i, col := range cols {
+		p.fields[i] = -1
+		if fm, ok := meta.columnFieldMap[col]; ok {
+			p.fields[i] = fm.index
+			p.onNull[i] = o.onNull
+			if fm.tag.hasOnNull {
+				p.onNull[i] = fm.tag.onNull
+			}
+			if len(fm.tag.codec) > 0 {
+				if p.codecs[i], err = o.codec(fm.tag.codec); err != nil {
+					return nil, err
+				}
+			} else {
+				p.direct[i] = builtin && len(types) == n && directScannable(fm.typ, types[i])
+			}
+		}
+		if !p.direct[i] {
+			p.dest[i] = &p.values[i]
+		}
+	}
+	return p, nil
+}
+
+// directScannable 判断 ct 列是否可以直接扫描到 t 类型的字段中，且结果与使用内置的 ValueConverter 相同。
+func directScannable(t reflect.Type, ct *sql.ColumnType) bool {
+	st := ct.ScanType()
+	if t == timeType || nullTypes[t] == timeType {
+		// 时间使用 ValueConverter 转换，以便按 TimeFormat、时区等规则处理
+		return false
+	}
+	if reflect.PointerTo(t).Implements(nullWrapperType) {
+		// Null[T] 使用 ValueConverter 转换 T
+		return false
+	}
+	if reflect.PointerTo(t).Implements(scannerType) {
+		// sql.Null* 与 convertValue 一致使用 ValueConverter，除非驱动报告的就是该类型
+		_, isNull := nullTypes[t]
+		return !isNull || st == t
+	}
+	// NULL 值需要按 Strategy 处理
+	if nullable, ok := ct.Nullable(); !ok || nullable {
+		return false
+	}
+	switch {
+	case st == nil:
+		return false
+	case st == t:
+		return true
+	case st == rawBytesType || st == bytesType || st.Kind() == reflect.String:
+		// database/sql copies the bytes when scanning into string or []byte
+		return t.Kind() == reflect.String || t == bytesType
+	}
+	return false
+}
+
+// scan 将当前行扫描到 m 中。
+func (p *scanPlan) scan(rows *sql.Rows, m Mapper, o scanOptions) error {
+	for i, index := range p.fields {
+		if p.direct[i] {
+			p.dest[i] = m.FieldPtr(index)
+		}
+	}
+	if err := rows.Scan(p.dest...); err != nil {
+		return err
+	}
+	for i, index := range p.fields {
+		if index == -1 || p.direct[i] {
+			continue
+		}
+		var err error
+		if p.codecs[i] != nil && p.values[i] != nil {
+			err = decodeValue(p.codecs[i], m.FieldPtr(index), p.values[i], p.cols[i])
+		} else {
+			err = convertValue(m.FieldPtr(index), p.values[i], o.converter, p.onNull[i])
+		}
+		if errors.Is(err, ErrUnexpectedNull) {
+			err = fmt.Errorf(f8, err, p.meta.columnFieldMap[p.cols[i]].typ)
+		}
+		if err != nil {
+			return &OpError{Op: "scan", Table: p.meta.table, Column: p.cols[i], Err: err}
+		}
+	}
+	return nil
+}
+
 func StructScanner(
 	entity interface{},
 	meta *structMeta,
@@ -75,10 +217,19 @@ func StructScanner(
 	onNull Strategy,
 	unused map[string]interface{},
 	found *bool,
+) RowsScanner {
+	return structScanner(entity, meta, scanOptions{converter, onNull, builtinCodec}, unused, found)
+}
+
+func structScanner(
+	entity interface{},
+	meta *structMeta,
+	o scanOptions,
+	unused map[string]interface{},
+	found *bool,
 ) RowsScanner {
 	return ScanFn(func(rows *sql.Rows) (err error) {
-		// get columns
-		cols, err := rows.Columns()
+		p, err := newScanPlan(rows, meta, o)
 		if err != nil {
 			return
 		}
@@ -87,37 +238,24 @@ func StructScanner(
 		if !*found {
 			return
 		}
-		v := reflect.ValueOf(entity).Elem()
-
-		nColumns := len(cols)
-		ifacePtrs := make([]interface{}, 0, nColumns)
-
-		for i := 0; i < nColumns; i++ {
-			ifacePtrs = append(ifacePtrs, new(interface{}))
+		err = p.scan(rows, mapperOf(entity), o)
+		if err != nil || unused == nil {
+			return
 		}
-		// we use pointers of empty interfaces to be the target of scan
-		_ = rows.Scan(ifacePtrs...)
-		// after scan all values are stored in ifaces respectively
-
-		for i := 0; i < nColumns; i++ {
-			src := *(ifacePtrs[i].(*interface{}))
-			fm, ok := meta.columnFieldMap[cols[i]]
-			if !ok {
-				if unused != nil {
-					unused[cols[i]] = src
-				}
-				continue
-			}
-			dptr := v.Field(fm.index).Addr().Interface()
-			err = convertValue(dptr, src, converter, onNull)
-			if err != nil {
-				return
+		for i, index := range p.fields {
+			if index == -1 {
+				unused[p.cols[i]] = p.values[i]
 			}
 		}
 		return
 	})
 }
 
+// SliceScanner 将每一行追加到 slice 中。slice 是 *[]T 或 *[]*T，elemType 是 T 的类型。
+// T 的指针实现了 Mapper 时通过 Mapper 读写字段。只能使用内置的 Codec。
+//
+// Deprecated: isPointer 已被忽略，元素是否为指针由 slice 的类型决定（见 Mapper.AppendTo）。
+// 查询请使用 QueryMultiple，写入语句返回的行请使用 Returning 并传入结构体切片的指针，它们使用 Database 的全部选项。
 func SliceScanner(
 	slice interface{},
 	meta *structMeta,
@@ -126,46 +264,27 @@ func SliceScanner(
 	elemType reflect.Type,
 	isPointer bool,
 ) RowsScanner {
+	return sliceScanner(slice, meta, scanOptions{converter, onNull, builtinCodec}, elemType)
+}
+
+func sliceScanner(slice interface{}, meta *structMeta, o scanOptions, elemType reflect.Type) RowsScanner {
 	return ScanFn(func(rows *sql.Rows) error {
-		cols, err := rows.Columns()
+		p, err := newScanPlan(rows, meta, o)
 		if err != nil {
 			// TODO: add a warning log
 			return err
 		}
-		nColumns := len(cols)
-
-		ifacePtrs := make([]interface{}, 0, nColumns)
-		for i := 0; i < nColumns; i++ {
-			ifacePtrs = append(ifacePtrs, new(interface{}))
-		}
 
-		newElems := []reflect.Value{}
+		proto := mapperOf(reflect.New(elemType).Interface())
 		for rows.Next() {
-			_ = rows.Scan(ifacePtrs...)
-			vPtr := reflect.New(elemType)
-			v := vPtr.Elem()
-			for i := 0; i < nColumns; i++ {
-				fm, ok := meta.columnFieldMap[cols[i]]
-				if !ok {
-					continue
-				}
-				err = convertValue(
-					v.Field(fm.index).Addr().Interface(),
-					*(ifacePtrs[i].(*interface{})),
-					converter, onNull,
-				)
-				if err != nil {
-					return err
-				}
+			m := proto.NewMapper()
+			if err = p.scan(rows, m, o); err != nil {
+				return err
 			}
-			if isPointer {
-				newElems = append(newElems, vPtr)
-				continue
+			if !m.AppendTo(slice) {
+				return ErrElemNotSlice
 			}
-			newElems = append(newElems, v)
 		}
-		vSlice := reflect.ValueOf(slice).Elem()
-		vSlice.Set(reflect.Append(vSlice, newElems...))
-		return nil
+		return rows.Err()
 	})
 }
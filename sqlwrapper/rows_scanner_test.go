@@ -0,0 +1,345 @@
+package sqlwrapper
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeDriver 查询时返回固定的 fakeRowCount 行数据，执行时记录语句和参数。
+// dsn 的格式为 "<fakeDB 的 id>/<name>"（见 fakeDB.dsn），记录保存在对应的 fakeDB 中。
+// name 为 "typed" 时报告列类型，否则不报告；name 为 "broken" 时读取一半的行后返回 errFakeBroken。
+type fakeDriver struct{}
+
+func init() { sql.Register("sqlwrapper-fake", fakeDriver{}) }
+
+const fakeRowCount = 100
+
+var (
+	fakeColumns = []string{"id", "name", "score", "created_at", "remark"}
+	fakeTypes   = []reflect.Type{
+		reflect.TypeOf(int64(0)), reflect.TypeOf(sql.RawBytes{}), reflect.TypeOf(float64(0)),
+		reflect.TypeOf(time.Time{}), reflect.TypeOf(sql.NullString{}),
+	}
+	fakeTime = time.Date(2022, 5, 1, 8, 0, 0, 0, time.UTC)
+)
+
+func (fakeDriver) Open(dsn string) (driver.Conn, error) {
+	id, name, _ := strings.Cut(dsn, "/")
+	n, _ := strconv.ParseInt(id, 10, 64)
+	f, ok := fakeDBs.Load(n)
+	if !ok {
+		return nil, fmt.Errorf("unknown fake database %q", dsn)
+	}
+	return fakeConn{f.(*fakeDB), name}, nil
+}
+
+// fakeDB 是一个测试中所有 fake 连接共享的状态：执行过的语句、提交和回滚的次数、查询过的 name 和不可用的 name。
+// 每个测试使用 newFakeDB 创建自己的 fakeDB，测试之间互不影响。
+// fakeDB 同时是 name 为空的 driver.Connector，用于通过 WithConnector 模拟任意 driver。
+type fakeDB struct {
+	id int64
+
+	mu        sync.Mutex
+	execs     []fakeExec
+	execError error
+	commits   int
+	rollbacks int
+	queried   []string
+	down      map[string]bool
+}
+
+var (
+	// fakeDBs 是 id 到 *fakeDB 的映射
+	fakeDBs   sync.Map
+	fakeDBSeq atomic.Int64
+)
+
+// newFakeDB 返回一个新的 fakeDB，测试结束时注销。
+func newFakeDB(tb testing.TB) *fakeDB {
+	f := &fakeDB{id: fakeDBSeq.Add(1), down: map[string]bool{}}
+	fakeDBs.Store(f.id, f)
+	tb.Cleanup(func() { fakeDBs.Delete(f.id) })
+	return f
+}
+
+// dsn 返回 f 中名为 name 的 dsn。
+func (f *fakeDB) dsn(name string) string { return strconv.FormatInt(f.id, 10) + "/" + name }
+
+// open 打开 f 中名为 name 的 Database，测试结束时关闭。
+func (f *fakeDB) open(tb testing.TB, name string, options ...OptionDB) *Database {
+	tb.Helper()
+	db, err := NewDatabase("sqlwrapper-fake", f.dsn(name), options...)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() { db.Close() })
+	return db
+}
+
+// openAs 打开以 f 为 Connector 的 driver 的 Database，用于使用各个 driver 的 Dialect 生成语句。测试结束时关闭。
+func (f *fakeDB) openAs(tb testing.TB, driver string, options ...OptionDB) *Database {
+	tb.Helper()
+	db, err := NewDatabase(driver, "", append([]OptionDB{WithConnector(f)}, options...)...)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() { db.Close() })
+	return db
+}
+
+func (f *fakeDB) Connect(context.Context) (driver.Conn, error) { return fakeConn{f, ""}, nil }
+func (f *fakeDB) Driver() driver.Driver                        { return fakeDriver{} }
+
+// setExecError 设置 Exec 返回的错误，nil 表示成功。
+func (f *fakeDB) setExecError(err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.execError = err
+}
+
+// setDown 设置 name 是否不可用，不可用时查询和 Ping 返回 driver.ErrBadConn。
+func (f *fakeDB) setDown(name string, down bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.down[name] = down
+}
+
+func (f *fakeDB) isDown(name string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.down[name]
+}
+
+// takeExecs 返回执行过的语句并清空记录。
+func (f *fakeDB) takeExecs() []fakeExec {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	execs := f.execs
+	f.execs = nil
+	return execs
+}
+
+// lastExec 返回最后执行的语句并清空记录。
+func (f *fakeDB) lastExec(t *testing.T) fakeExec {
+	t.Helper()
+	execs := f.takeExecs()
+	if len(execs) == 0 {
+		t.Fatal("nothing executed")
+	}
+	return execs[len(execs)-1]
+}
+
+// takeQueried 返回每次查询的 name 并清空记录。
+func (f *fakeDB) takeQueried() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	queried := f.queried
+	f.queried = nil
+	return queried
+}
+
+// takeTxCounts 返回提交和回滚的次数并清零。
+func (f *fakeDB) takeTxCounts() (commits, rollbacks int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	commits, rollbacks = f.commits, f.rollbacks
+	f.commits, f.rollbacks = 0, 0
+	return
+}
+
+type fakeConn struct {
+	f    *fakeDB
+	name string
+}
+
+func (c fakeConn) Prepare(query string) (driver.Stmt, error) {
+	return fakeStmt{c.f, c.name, query}, nil
+}
+func (fakeConn) Close() error                { return nil }
+func (c fakeConn) Begin() (driver.Tx, error) { return fakeTx{c.f}, nil }
+func (c fakeConn) Ping(ctx context.Context) error {
+	if c.f.isDown(c.name) {
+		return driver.ErrBadConn
+	}
+	return nil
+}
+
+// fakeTx 记录提交和回滚的次数。
+type fakeTx struct{ f *fakeDB }
+
+func (tx fakeTx) Commit() error {
+	tx.f.mu.Lock()
+	defer tx.f.mu.Unlock()
+	tx.f.commits++
+	return nil
+}
+
+func (tx fakeTx) Rollback() error {
+	tx.f.mu.Lock()
+	defer tx.f.mu.Unlock()
+	tx.f.rollbacks++
+	return nil
+}
+
+// fakeExec 是 fakeDriver 执行过的语句。
+type fakeExec struct {
+	query string
+	args  []driver.Value
+}
+
+type fakeStmt struct {
+	f     *fakeDB
+	name  string
+	query string
+}
+
+func (fakeStmt) Close() error  { return nil }
+func (fakeStmt) NumInput() int { return -1 }
+func (s fakeStmt) Exec(args []driver.Value) (driver.Result, error) {
+	s.f.mu.Lock()
+	defer s.f.mu.Unlock()
+	s.f.execs = append(s.f.execs, fakeExec{s.query, args})
+	if s.f.execError != nil {
+		return nil, s.f.execError
+	}
+	return driver.RowsAffected(1), nil
+}
+func (s fakeStmt) Query(args []driver.Value) (driver.Rows, error) {
+	s.f.mu.Lock()
+	s.f.queried = append(s.f.queried, s.name)
+	down := s.f.down[s.name]
+	s.f.mu.Unlock()
+	switch {
+	case down:
+		return nil, driver.ErrBadConn
+	case s.name == "typed":
+		return &typedFakeRows{}, nil
+	case s.name == "broken":
+		return &fakeRows{broken: true}, nil
+	}
+	return &fakeRows{}, nil
+}
+
+var errFakeBroken = errors.New("connection broken")
+
+type fakeRows struct {
+	i      int
+	broken bool
+}
+
+func (*fakeRows) Columns() []string { return fakeColumns }
+func (*fakeRows) Close() error      { return nil }
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if r.i == fakeRowCount {
+		return io.EOF
+	}
+	if r.broken && r.i == fakeRowCount/2 {
+		return errFakeBroken
+	}
+	r.i++
+	dest[0] = int64(r.i)
+	dest[1] = []byte(fmt.Sprintf("user%d", r.i))
+	dest[2] = float64(r.i) / 2
+	dest[3] = fakeTime
+	dest[4] = nil
+	if r.i%2 == 0 {
+		dest[4] = "even"
+	}
+	return nil
+}
+
+type typedFakeRows struct{ fakeRows }
+
+func (*typedFakeRows) ColumnTypeScanType(i int) reflect.Type { return fakeTypes[i] }
+func (*typedFakeRows) ColumnTypeNullable(i int) (bool, bool) { return i == 4, true }
+
+type scanUser struct {
+	ID        int64
+	Name      string
+	Score     float64
+	CreatedAt time.Time
+	Remark    sql.NullString
+}
+
+func (scanUser) TableName() string { return "user" }
+func (scanUser) PkColumn() string  { return "id" }
+
+// openFakeDatabase 打开一个新的 fakeDB 中名为 name 的 Database，用于不需要检查记录的测试。
+func openFakeDatabase(tb testing.TB, name string, options ...OptionDB) *Database {
+	tb.Helper()
+	return newFakeDB(tb).open(tb, name, options...)
+}
+
+func TestScanPlan(t *testing.T) {
+	var typed, untyped []scanUser
+	if err := openFakeDatabase(t, "typed").QueryMultiple(&typed); err != nil {
+		t.Fatal(err)
+	}
+	if err := openFakeDatabase(t, "").QueryMultiple(&untyped); err != nil {
+		t.Fatal(err)
+	}
+	if len(typed) != fakeRowCount || !reflect.DeepEqual(typed, untyped) {
+		t.Fatalf("typed and untyped results differ:\n%+v\n%+v", typed[:2], untyped[:2])
+	}
+	want := scanUser{2, "user2", 1, fakeTime, sql.NullString{String: "even", Valid: true}}
+	if typed[1] != want {
+		t.Errorf("got %+v, want %+v", typed[1], want)
+	}
+	if typed[0].Remark.Valid {
+		t.Errorf("NULL remark should be invalid, got %+v", typed[0].Remark)
+	}
+}
+
+// shiftConverter 把时间推后一小时，用于确认时间列经过 ValueConverter。
+type shiftConverter struct{ ValueConverter }
+
+func (c shiftConverter) ConvertTime(dptr interface{}, src time.Time, format string) error {
+	return c.ValueConverter.ConvertTime(dptr, src.Add(time.Hour), format)
+}
+
+func TestScanPlanConverter(t *testing.T) {
+	for _, name := range []string{"typed", ""} {
+		var users []scanUser
+		db := openFakeDatabase(t, name, WithValueConverter(shiftConverter{Vcie}))
+		if err := db.QueryMultiple(&users); err != nil {
+			t.Fatal(err)
+		}
+		if want := fakeTime.Add(time.Hour); !users[0].CreatedAt.Equal(want) {
+			t.Errorf("%q: got %v, want %v", name, users[0].CreatedAt, want)
+		}
+	}
+
+	var users []scanUser
+	if err := openFakeDatabase(t, "broken").QueryMultiple(&users); !errors.Is(err, errFakeBroken) {
+		t.Errorf("got %v, want %v", err, errFakeBroken)
+	}
+}
+
+func benchmarkQueryMultiple(b *testing.B, dsn string) {
+	db := openFakeDatabase(b, dsn)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		var users []scanUser
+		if err := db.QueryMultiple(&users); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkQueryMultiple 比较驱动报告列类型（直接扫描到字段）和不报告列类型（经过 interface{} 和 ValueConverter）时的开销。
+func BenchmarkQueryMultiple(b *testing.B) {
+	b.Run("typed", func(b *testing.B) { benchmarkQueryMultiple(b, "typed") })
+	b.Run("untyped", func(b *testing.B) { benchmarkQueryMultiple(b, "") })
+}
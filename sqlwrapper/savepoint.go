@@ -0,0 +1,112 @@
+package sqlwrapper
+
+import "strconv"
+
+// savepointSQL 返回创建保存点的语句。
+func (db *Database) savepointSQL(name string) string {
+	switch db.driver {
+	case "mssql", "sqlserver":
+		return "save transaction " + name
+	}
+	return "savepoint " + name
+}
+
+// rollbackToSQL 返回回滚到保存点的语句。
+func (db *Database) rollbackToSQL(name string) string {
+	switch db.driver {
+	case "mssql", "sqlserver":
+		return "rollback transaction " + name
+	}
+	return "rollback to savepoint " + name
+}
+
+// releaseSQL 返回释放保存点的语句，不支持释放保存点的数据库返回空字符串。
+func (db *Database) releaseSQL(name string) string {
+	switch db.driver {
+	case "mssql", "sqlserver", "oci8", "oracle":
+		// savepoints are released when the transaction ends
+		return ""
+	}
+	return "release savepoint " + name
+}
+
+// Savepoint 在事务中创建名为 name 的保存点。SQL Server 使用 save transaction。
+func (tx *Tx) Savepoint(name string) (err error) {
+	defer wrapError(&err, "savepoint", "")
+	_, err = tx.RawExec(tx.db.savepointSQL(name))
+	return
+}
+
+// RollbackTo 回滚到保存点 name，保存点之前的修改仍然保留，事务可以继续执行。
+func (tx *Tx) RollbackTo(name string) (err error) {
+	defer wrapError(&err, "rollback to savepoint", "")
+	_, err = tx.RawExec(tx.db.rollbackToSQL(name))
+	return
+}
+
+// Release 释放保存点 name，保存点之后的修改成为事务的一部分。SQL Server 和 Oracle 不支持释放保存点，什么都不做。
+func (tx *Tx) Release(name string) (err error) {
+	stmt := tx.db.releaseSQL(name)
+	if len(stmt) == 0 {
+		return nil
+	}
+	defer wrapError(&err, "release savepoint", "")
+	_, err = tx.RawExec(stmt)
+	return
+}
+
+// RunNested 在保存点中执行 run，用于在已有的事务中组合可以单独回滚的操作。
+// run 返回 true 且没有错误时释放保存点，否则回滚到保存点，外层事务不受影响，由外层决定是否提交。
+// run 中 panic 时同样回滚到保存点，然后继续 panic。
+// 回滚到保存点时，run 中用 OnCommit 注册的函数被丢弃，用 OnRollback 注册的函数立即调用。
+//
+//	func transfer(tx *Tx, from, to int64, amount int) error {
+//	    return tx.RunNested(func(tx *Tx) (bool, error) {
+//	        // ...
+//	        return true, nil
+//	    })
+//	}
+//
+//	db.RunTx(func(tx *Tx) (bool, error) {
+//	    if err := transfer(tx, 1, 2, 100); err != nil {
+//	        // only the transfer is rolled back
+//	        log.Println(err)
+//	    }
+//	    return true, tx.Insert(&record)
+//	})
+//
+// 保存点的名称为 sp_<层数>，可以多层嵌套。
+func (tx *Tx) RunNested(run func(tx *Tx) (commit bool, err error)) (err error) {
+	tx.depth++
+	defer func() { tx.depth-- }()
+	name := "sp_" + strconv.Itoa(tx.depth)
+	if err = tx.Savepoint(name); err != nil {
+		return
+	}
+	nCommit, nRollback := len(tx.onCommit), len(tx.onRollback)
+	done := false
+	defer func() {
+		if !done {
+			// run panicked
+			tx.RollbackTo(name)
+			tx.discardHooks(nCommit, nRollback)
+		}
+	}()
+	commit, err := run(tx)
+	done = true
+	if err != nil || !commit {
+		if rerr := tx.RollbackTo(name); rerr != nil && err == nil {
+			err = rerr
+		}
+		tx.discardHooks(nCommit, nRollback)
+		return
+	}
+	return tx.Release(name)
+}
+
+// discardHooks 在回滚到保存点后丢弃保存点之后注册的 OnCommit 函数，并调用之后注册的 OnRollback 函数。
+func (tx *Tx) discardHooks(nCommit, nRollback int) {
+	onRollback := append([]func(){}, tx.onRollback[nRollback:]...)
+	tx.onCommit, tx.onRollback = tx.onCommit[:nCommit], tx.onRollback[:nRollback]
+	runHooks(onRollback)
+}
@@ -0,0 +1,72 @@
+package sqlwrapper
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestSavepointSQL(t *testing.T) {
+	for _, c := range []struct {
+		driver                       string
+		savepoint, rollback, release string
+	}{
+		{"mysql", "savepoint sp_1", "rollback to savepoint sp_1", "release savepoint sp_1"},
+		{"pgx", "savepoint sp_1", "rollback to savepoint sp_1", "release savepoint sp_1"},
+		{"sqlite", "savepoint sp_1", "rollback to savepoint sp_1", "release savepoint sp_1"},
+		{"sqlserver", "save transaction sp_1", "rollback transaction sp_1", ""},
+		{"oracle", "savepoint sp_1", "rollback to savepoint sp_1", ""},
+	} {
+		db := testDatabase(c.driver)
+		if got := db.savepointSQL("sp_1"); got != c.savepoint {
+			t.Errorf("%s: got %q, want %q", c.driver, got, c.savepoint)
+		}
+		if got := db.rollbackToSQL("sp_1"); got != c.rollback {
+			t.Errorf("%s: got %q, want %q", c.driver, got, c.rollback)
+		}
+		if got := db.releaseSQL("sp_1"); got != c.release {
+			t.Errorf("%s: got %q, want %q", c.driver, got, c.release)
+		}
+	}
+}
+
+func TestRunNested(t *testing.T) {
+	f := newFakeDB(t)
+	db := f.open(t, "")
+	errInner := errors.New("inner failed")
+	step, err := db.RunTx(func(tx *Tx) (bool, error) {
+		if err := tx.RunNested(func(tx *Tx) (bool, error) {
+			if err := tx.RunNested(func(tx *Tx) (bool, error) { return false, errInner }); err != errInner {
+				t.Errorf("got %v, want %v", err, errInner)
+			}
+			return true, nil
+		}); err != nil {
+			return false, err
+		}
+		func() {
+			defer func() { recover() }()
+			tx.RunNested(func(tx *Tx) (bool, error) { panic("boom") })
+		}()
+		return true, tx.RunNested(func(tx *Tx) (bool, error) { return false, nil })
+	})
+	if step != StepEnd || err != nil {
+		t.Fatal(step, err)
+	}
+	got := []string{}
+	for _, e := range f.takeExecs() {
+		got = append(got, e.query)
+	}
+	want := []string{
+		"savepoint sp_1",
+		"savepoint sp_2",
+		"rollback to savepoint sp_2",
+		"release savepoint sp_1",
+		"savepoint sp_1",
+		"rollback to savepoint sp_1",
+		"savepoint sp_1",
+		"rollback to savepoint sp_1",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,307 @@
+package sqlwrapper
+
+import (
+	"database/sql"
+	"strings"
+)
+
+// ColumnInfo 是从数据库中读取的列信息。
+type ColumnInfo struct {
+	Name     string
+	Type     string
+	Nullable bool
+}
+
+// TableInfo 是从数据库中读取的表结构。表不存在时 Exists 为 false。
+type TableInfo struct {
+	Name       string
+	Exists     bool
+	Columns    []ColumnInfo
+	Indexes    []string
+	PrimaryKey []string
+}
+
+// SchemaDiff 是 entity 与数据库中的表之间的差异。
+type SchemaDiff struct {
+	Table string
+	// Statements 是不会破坏已有数据的语句（建表、加列、建索引），AutoMigrate 会执行这些语句。
+	Statements []string
+	// Manual 是可能丢失数据或在已有数据上失败的语句（删列、修改为 not null、添加无默认值的 not null 列等），
+	// AutoMigrate 不会执行这些语句，需要人工确认后执行。
+	Manual []string
+}
+
+// Empty 判断是否没有差异。
+func (d *SchemaDiff) Empty() bool { return len(d.Statements) == 0 && len(d.Manual) == 0 }
+
+// introspectQueries 返回读取列信息、索引名和主键列名的语句，参数都是表名。
+// 列信息查询返回列名、类型和是否可以为 NULL（以 Y 开头代表可以）。
+func (db *Database) introspectQueries() (columns, indexes, primaryKey string) {
+	ph := db.dialect.HoldPlace(1)
+	// works in mysql, postgresql and sqlserver
+	informationSchemaPrimaryKey := "select kcu.column_name from information_schema.table_constraints tc " +
+		"join information_schema.key_column_usage kcu " +
+		"on tc.constraint_name = kcu.constraint_name and tc.table_schema = kcu.table_schema and tc.table_name = kcu.table_name " +
+		"where tc.constraint_type = 'PRIMARY KEY' and tc.table_name = " + ph + " order by kcu.ordinal_position"
+	switch db.driver {
+	case "mysql":
+		return "select column_name, column_type, is_nullable from information_schema.columns " +
+				"where table_schema = database() and table_name = " + ph + " order by ordinal_position",
+			"select distinct index_name from information_schema.statistics " +
+				"where table_schema = database() and table_name = " + ph,
+			informationSchemaPrimaryKey
+	case "pgx", "postgres":
+		return "select column_name, data_type, is_nullable from information_schema.columns " +
+				"where table_schema = current_schema() and table_name = " + ph + " order by ordinal_position",
+			"select indexname from pg_indexes where schemaname = current_schema() and tablename = " + ph,
+			informationSchemaPrimaryKey
+	case "mssql", "sqlserver":
+		return "select column_name, data_type, is_nullable from information_schema.columns " +
+				"where table_schema = schema_name() and table_name = " + ph + " order by ordinal_position",
+			"select name from sys.indexes where object_id = object_id(" + ph + ") and name is not null",
+			informationSchemaPrimaryKey
+	case "sqlite", "sqlite3":
+		return `select name, type, case when "notnull" = 0 then 'YES' else 'NO' end from pragma_table_info(` + ph + ") order by cid",
+			"select name from pragma_index_list(" + ph + ")",
+			"select name from pragma_table_info(" + ph + ") where pk > 0 order by pk"
+	case "oci8", "oracle":
+		// integer columns are reported as NUMBER(p,0) so that they can be told from decimals
+		return "select column_name, case when data_type = 'NUMBER' and data_scale = 0 " +
+				"then 'NUMBER(' || nvl(data_precision, 38) || ',0)' else data_type end, nullable from all_tab_columns " +
+				"where owner = user and table_name = " + ph + " order by column_id",
+			"select index_name from all_indexes where owner = user and table_name = " + ph,
+			"select cols.column_name from all_constraints cons join all_cons_columns cols " +
+				"on cons.owner = cols.owner and cons.constraint_name = cols.constraint_name " +
+				"where cons.constraint_type = 'P' and cons.owner = user and cons.table_name = " + ph + " order by cols.position"
+	}
+	return "select column_name, data_type, is_nullable from information_schema.columns " +
+			"where table_name = " + ph + " order by ordinal_position",
+		"",
+		informationSchemaPrimaryKey
+}
+
+// Tables 返回当前 schema（SQLite 为当前数据库，Oracle 为当前用户）中所有表的表名。
+func (db *Database) Tables() ([]string, error) {
+	var query string
+	switch db.driver {
+	case "mysql":
+		query = "select table_name from information_schema.tables where table_schema = database() and table_type = 'BASE TABLE'"
+	case "pgx", "postgres":
+		query = "select table_name from information_schema.tables where table_schema = current_schema() and table_type = 'BASE TABLE'"
+	case "mssql", "sqlserver":
+		query = "select table_name from information_schema.tables where table_schema = schema_name() and table_type = 'BASE TABLE'"
+	case "sqlite", "sqlite3":
+		query = "select name from sqlite_master where type = 'table' and name not like 'sqlite_%'"
+	case "oci8", "oracle":
+		query = "select table_name from user_tables"
+	default:
+		query = "select table_name from information_schema.tables where table_type = 'BASE TABLE'"
+	}
+	tables := []string{}
+	err := db.RawQuery(query, ScanFn(func(r *sql.Rows) (err error) {
+		tables, err = scanStrings(r, tables)
+		return
+	}))
+	return tables, err
+}
+
+// scanStrings 将单列的查询结果追加到 dst。
+func scanStrings(r *sql.Rows, dst []string) ([]string, error) {
+	for r.Next() {
+		var s string
+		if err := r.Scan(&s); err != nil {
+			return dst, err
+		}
+		dst = append(dst, s)
+	}
+	return dst, r.Err()
+}
+
+// TableInfo 从数据库中读取 table 的列和索引（SQLite 使用 pragma，Oracle 使用 ALL_TAB_COLUMNS，其他使用 information_schema）。
+func (db *Database) TableInfo(table string) (*TableInfo, error) {
+	info := &TableInfo{Name: table}
+	columnsQuery, indexesQuery, primaryKeyQuery := db.introspectQueries()
+	err := db.RawQuery(columnsQuery, ScanFn(func(r *sql.Rows) error {
+		for r.Next() {
+			var c ColumnInfo
+			var nullable string
+			if err := r.Scan(&c.Name, &c.Type, &nullable); err != nil {
+				return err
+			}
+			c.Nullable = strings.HasPrefix(strings.ToUpper(nullable), "Y")
+			info.Columns = append(info.Columns, c)
+		}
+		return r.Err()
+	}), table)
+	if err != nil {
+		return nil, err
+	}
+	info.Exists = len(info.Columns) > 0
+	if !info.Exists {
+		return info, nil
+	}
+	if len(indexesQuery) > 0 {
+		err = db.RawQuery(indexesQuery, ScanFn(func(r *sql.Rows) (err error) {
+			info.Indexes, err = scanStrings(r, info.Indexes)
+			return
+		}), table)
+		if err != nil {
+			return nil, err
+		}
+	}
+	err = db.RawQuery(primaryKeyQuery, ScanFn(func(r *sql.Rows) (err error) {
+		info.PrimaryKey, err = scanStrings(r, info.PrimaryKey)
+		return
+	}), table)
+	if err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+// Diff 比较 entities 与数据库中对应的表，返回每个表的差异（没有差异的表不返回）。
+//
+// 只比较列是否存在、是否可以为 NULL 以及 tag 中声明的索引是否存在，不比较列类型。
+func (db *Database) Diff(entities ...IEntity) ([]SchemaDiff, error) {
+	diffs := []SchemaDiff{}
+	for _, e := range entities {
+		sm, err := db.RegisterType(e)
+		if err != nil {
+			return nil, err
+		}
+		info, err := db.TableInfo(e.TableName())
+		if err != nil {
+			return nil, err
+		}
+		d, err := db.diffTable(e, sm, info)
+		if err != nil {
+			return nil, err
+		}
+		if !d.Empty() {
+			diffs = append(diffs, d)
+		}
+	}
+	return diffs, nil
+}
+
+// AutoMigrate 执行 Diff 结果中不会破坏已有数据的语句，并返回所有差异。
+// 调用方应检查返回值中的 Manual 语句。
+//
+//	diffs, err := db.AutoMigrate(&Employee{}, &Dept{})
+//	for _, d := range diffs {
+//	    for _, stmt := range d.Manual {
+//	        log.Println("skipped:", stmt)
+//	    }
+//	}
+func (db *Database) AutoMigrate(entities ...IEntity) ([]SchemaDiff, error) {
+	diffs, err := db.Diff(entities...)
+	if err != nil {
+		return nil, err
+	}
+	for _, d := range diffs {
+		if err = db.execAll(d.Statements); err != nil {
+			return diffs, err
+		}
+	}
+	return diffs, nil
+}
+
+func (db *Database) diffTable(e IEntity, sm *structMeta, info *TableInfo) (SchemaDiff, error) {
+	table := e.TableName()
+	d := SchemaDiff{Table: table}
+	if !info.Exists {
+		stmts, err := db.CreateTableSQL(e)
+		if err != nil {
+			return d, err
+		}
+		d.Statements = stmts
+		return d, nil
+	}
+
+	existing := make(map[string]ColumnInfo, len(info.Columns))
+	for _, c := range info.Columns {
+		existing[strings.ToLower(c.Name)] = c
+	}
+	for _, column := range sm.columns {
+		fm := sm.columnFieldMap[column]
+		_, nullable := valueType(fm.typ)
+		nullable = fm.tag.nullable > 0 || fm.tag.nullable == 0 && nullable
+		c, ok := existing[strings.ToLower(column)]
+		if ok {
+			delete(existing, strings.ToLower(column))
+			if c.Nullable && !nullable && column != e.PkColumn() {
+				d.Manual = append(d.Manual, db.alterColumnSQL(table, fm))
+			}
+			continue
+		}
+		stmt, err := db.addColumnSQL(table, fm, column == e.PkColumn())
+		if err != nil {
+			return d, err
+		}
+		switch {
+		case column == e.PkColumn(),
+			!nullable && !fm.tag.hasDefault,
+			fm.tag.unique && (db.driver == "sqlite" || db.driver == "sqlite3"):
+			// adding primary keys, not null columns without default values and unique columns in SQLite
+			// fails on existing rows
+			d.Manual = append(d.Manual, stmt)
+		default:
+			d.Statements = append(d.Statements, stmt)
+		}
+	}
+	for _, c := range info.Columns {
+		if _, ok := existing[strings.ToLower(c.Name)]; ok {
+			d.Manual = append(d.Manual, "alter table "+db.Quote(table)+" drop column "+db.Quote(c.Name))
+		}
+	}
+
+	indexes := make(map[string]bool, len(info.Indexes))
+	for _, name := range info.Indexes {
+		indexes[strings.ToLower(name)] = true
+	}
+	for _, idx := range entityIndexes(table, sm) {
+		if !indexes[strings.ToLower(idx.name)] {
+			d.Statements = append(d.Statements, db.indexSQL(table, idx, false))
+		}
+	}
+	return d, nil
+}
+
+func (db *Database) addColumnSQL(table string, fm *fieldMeta, isPk bool) (string, error) {
+	ctx := db.newContext()
+	defer db.recycleContext(ctx)
+	ctx.WriteString("alter table ").WriteQuotedString(table)
+	switch db.driver {
+	case "mssql", "sqlserver":
+		ctx.WriteString(" add ")
+	case "oci8", "oracle":
+		ctx.WriteString(" add (")
+	default:
+		ctx.WriteString(" add column ")
+	}
+	err := db.columnDefinition(ctx, fm, isPk)
+	if err != nil {
+		return "", err
+	}
+	switch db.driver {
+	case "oci8", "oracle":
+		ctx.WriteByte(')')
+	}
+	return ctx.QueryString(), nil
+}
+
+// alterColumnSQL 返回将列修改为 not null 的语句。
+func (db *Database) alterColumnSQL(table string, fm *fieldMeta) string {
+	quotedTable, quotedColumn := db.Quote(table), db.Quote(fm.column)
+	switch db.driver {
+	case "mysql":
+		return "alter table " + quotedTable + " modify " + quotedColumn + " " + columnType(db.driver, fm) + " not null"
+	case "mssql", "sqlserver":
+		return "alter table " + quotedTable + " alter column " + quotedColumn + " " + columnType(db.driver, fm) + " not null"
+	case "oci8", "oracle":
+		return "alter table " + quotedTable + " modify (" + quotedColumn + " not null)"
+	case "sqlite", "sqlite3":
+		return "-- SQLite cannot alter column " + quotedColumn + " of " + quotedTable + " to not null, rebuild the table instead"
+	}
+	return "alter table " + quotedTable + " alter column " + quotedColumn + " set not null"
+}
@@ -0,0 +1,616 @@
+package sqlwrapper
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// IShardedEntity 是按分片键分散存储在多个数据库中的实体，见 ShardedDatabase。
+type IShardedEntity interface {
+	IEntity
+
+	// ShardKey 获取分片键的列名，如 "user_id"。实体中必须有对应的字段。
+	ShardKey() string
+
+	// ShardTable 获取表名模板，其中的 {shard} 替换为分片的序号，如 "orders_{shard}"。
+	// 各个分片中的表名相同时返回 TableName()。
+	ShardTable() string
+}
+
+// ShardFunc 根据分片键的值返回分片的序号，n 为分片数量。
+type ShardFunc func(key interface{}, n int) (int, error)
+
+// ModShard 是默认的 ShardFunc。整数分片键对 n 取模（负数取绝对值），字符串和 []byte 对 FNV-1a 哈希值取模。
+func ModShard(key interface{}, n int) (int, error) {
+	v := reflect.ValueOf(key)
+	for v.Kind() == reflect.Ptr && !v.IsNil() {
+		v = v.Elem()
+	}
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i := v.Int() % int64(n)
+		if i < 0 {
+			i = -i
+		}
+		return int(i), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return int(v.Uint() % uint64(n)), nil
+	case reflect.String:
+		h := fnv.New32a()
+		h.Write([]byte(v.String()))
+		return int(h.Sum32() % uint32(n)), nil
+	case reflect.Slice:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			h := fnv.New32a()
+			h.Write(v.Bytes())
+			return int(h.Sum32() % uint32(n)), nil
+		}
+	}
+	return 0, fmt.Errorf(f3, ErrUnsupportedShardKey, key)
+}
+
+type optionSharded struct {
+	shardOf ShardFunc
+}
+
+type OptionSharded func(opt *optionSharded)
+
+// WithShardFunc 指定计算分片序号的函数，默认为 ModShard。
+func WithShardFunc(f ShardFunc) OptionSharded {
+	return func(opt *optionSharded) { opt.shardOf = f }
+}
+
+// ShardedDatabase 将实体按分片键分散存储在多个 Database 中，第 i 个 Database 是第 i 个分片。
+//
+//	type Order struct {
+//	    ID     int64
+//	    UserID int64
+//	    Amount int
+//	}
+//
+//	func (Order) TableName() string  { return "orders" }
+//	func (Order) PkColumn() string   { return "id" }
+//	func (Order) ShardKey() string   { return "user_id" }
+//	func (Order) ShardTable() string { return "orders_{shard}" }
+//
+//	sdb, err := NewShardedDatabase([]*Database{db0, db1, db2, db3})
+//	sdb.Insert(&Order{UserID: 42, Amount: 100}) // insert into orders_2 on db2
+//
+// Insert、Update 和 Save 根据实体中分片键字段的值选择分片；Query 需要使用 ShardKey 选项。
+// QueryMultiple 使用 ShardKey 时只查询一个分片，否则并发查询所有分片，合并结果后按 OrderBy 排序，再按 Offset 和 Limit 截取。
+//
+// 事务只能在一个分片中执行，见 RunTx。
+type ShardedDatabase struct {
+	shards  []*Database
+	shardOf ShardFunc
+}
+
+func NewShardedDatabase(shards []*Database, options ...OptionSharded) (*ShardedDatabase, error) {
+	if len(shards) == 0 {
+		return nil, ErrNoShards
+	}
+	o := &optionSharded{shardOf: ModShard}
+	for _, opt := range options {
+		opt(o)
+	}
+	return &ShardedDatabase{shards: shards, shardOf: o.shardOf}, nil
+}
+
+// Shards 返回所有分片，用于执行 RawQuery 等不经过分片路由的操作。
+func (sdb *ShardedDatabase) Shards() []*Database { return sdb.shards }
+
+// ShardOf 返回分片键的值为 key 的分片的序号。
+func (sdb *ShardedDatabase) ShardOf(key interface{}) (int, error) {
+	i, err := sdb.shardOf(key, len(sdb.shards))
+	if err != nil {
+		return 0, err
+	}
+	if i < 0 || i >= len(sdb.shards) {
+		return 0, fmt.Errorf(fb, ErrShardOutOfRange, i)
+	}
+	return i, nil
+}
+
+// Close 关闭所有分片，返回遇到的错误。
+func (sdb *ShardedDatabase) Close() error {
+	var errs []error
+	for _, db := range sdb.shards {
+		errs = append(errs, db.Close())
+	}
+	return errors.Join(errs...)
+}
+
+// shardTable 返回实体在第 i 个分片中的表名。
+func shardTable(e IShardedEntity, i int) string {
+	return strings.ReplaceAll(e.ShardTable(), "{shard}", strconv.Itoa(i))
+}
+
+// route 根据实体中分片键字段的值返回分片的序号。
+func (sdb *ShardedDatabase) route(e IShardedEntity) (int, error) {
+	sm, err := sdb.shards[0].RegisterType(e)
+	if err != nil {
+		return 0, err
+	}
+	fm, ok := sm.columnFieldMap[e.ShardKey()]
+	if !ok {
+		return 0, fmt.Errorf(f5, ErrNoFieldForColumn, e.ShardKey())
+	}
+	key, _ := mapperOf(e).FieldValue(fm.index)
+	return sdb.ShardOf(key)
+}
+
+// execOptions 在 options 前加上第 i 个分片的表名。
+func execOptions(e IShardedEntity, i int, options []OptionExec) []OptionExec {
+	return append([]OptionExec{optExecTable(shardTable(e, i))}, options...)
+}
+
+// shardedEntity 返回 entity 或 entity 切片的元素对应的 IShardedEntity。
+func shardedEntity(v interface{}) (IShardedEntity, error) {
+	t := reflect.TypeOf(v)
+	for t.Kind() == reflect.Ptr || t.Kind() == reflect.Slice {
+		t = t.Elem()
+	}
+	e, ok := reflect.New(t).Interface().(IShardedEntity)
+	if !ok {
+		return nil, fmt.Errorf(f3, ErrNotShardedEntity, v)
+	}
+	return e, nil
+}
+
+// Insert 将 e 插入分片键所在的分片。
+func (sdb *ShardedDatabase) Insert(e IShardedEntity, options ...OptionExec) error {
+	i, err := sdb.route(e)
+	if err != nil {
+		return err
+	}
+	return sdb.shards[i].Insert(e, execOptions(e, i, options)...)
+}
+
+// Update 更新分片键所在的分片中 e 对应的记录。分片键不能修改。
+func (sdb *ShardedDatabase) Update(e IShardedEntity, options ...OptionExec) error {
+	i, err := sdb.route(e)
+	if err != nil {
+		return err
+	}
+	return sdb.shards[i].Update(e, execOptions(e, i, options)...)
+}
+
+// Save 将 e 保存到分片键所在的分片中。当 e 主键字段为空时插入，非空时更新。
+func (sdb *ShardedDatabase) Save(e IShardedEntity, options ...OptionExec) error {
+	i, err := sdb.route(e)
+	if err != nil {
+		return err
+	}
+	return sdb.shards[i].Save(e, execOptions(e, i, options)...)
+}
+
+// Query 在 ShardKey 指定的分片中查询一条记录。没有使用 ShardKey 时返回 ErrNoShardKey。
+func (sdb *ShardedDatabase) Query(entity interface{}, options ...OptionQuerySingle) (found bool, err error) {
+	e, err := shardedEntity(entity)
+	if err != nil {
+		return
+	}
+	q := &optQuerySingle{}
+	for _, opt := range options {
+		opt.applyToOptionQuerySingle(q)
+	}
+	if q.shardKey == nil {
+		return false, ErrNoShardKey
+	}
+	i, err := sdb.ShardOf(q.shardKey.key)
+	if err != nil {
+		return
+	}
+	return sdb.shards[i].Query(entity, querySingleOptions(e, i, options)...)
+}
+
+func querySingleOptions(e IShardedEntity, i int, options []OptionQuerySingle) []OptionQuerySingle {
+	return append([]OptionQuerySingle{From(Table(shardTable(e, i)))}, options...)
+}
+
+func queryMultipleOptions(e IShardedEntity, i int, options []OptionQueryMultiple) []OptionQueryMultiple {
+	return append([]OptionQueryMultiple{From(Table(shardTable(e, i)))}, options...)
+}
+
+// QueryMultiple 查询多条记录追加到 es 中。使用 ShardKey 时只查询一个分片；否则并发查询所有分片并合并结果：
+// 每个分片查询前 offset+limit 条记录，合并后按 OrderBy 排序（列必须是实体中的字段），再按 Offset 和 Limit 截取。
+// 没有 OrderBy 时结果按分片的顺序排列。
+//
+// 合并时的排序与数据库的排序一致，各分片的前 offset+limit 条记录才包含合并后的结果，所以使用 Limit 时 OrderBy 的列必须是
+// 不能为 NULL 的数字、时间或 bool 字段（字符串的顺序取决于数据库的排序规则，NULL 的位置也因数据库而异），否则返回 ErrCrossShardOrderBy。
+// 不使用 ShardKey 时不支持 GroupBy、Having、Union 等集合操作、distinct 和聚合函数，返回 ErrCrossShardQuery。
+//
+// 有分片查询失败时返回所有分片的错误，es 不变。
+func (sdb *ShardedDatabase) QueryMultiple(es interface{}, options ...OptionQueryMultiple) error {
+	t := reflect.TypeOf(es)
+	if t.Kind() != reflect.Ptr {
+		return ErrNotPointer
+	}
+	if t.Elem().Kind() != reflect.Slice {
+		return ErrElemNotSlice
+	}
+	e, err := shardedEntity(es)
+	if err != nil {
+		return err
+	}
+	q := &optQueryMultiple{}
+	for _, opt := range options {
+		opt.applyToOptionQueryMultiple(q)
+	}
+	if q.shardKey != nil {
+		i, err := sdb.ShardOf(q.shardKey.key)
+		if err != nil {
+			return err
+		}
+		return sdb.shards[i].QueryMultiple(es, queryMultipleOptions(e, i, options)...)
+	}
+
+	if len(q.groupByColumns) > 0 || len(q.havingClause) > 0 || len(q.setOperations) > 0 || aggregated(q.selectColumns) {
+		return ErrCrossShardQuery
+	}
+
+	// register the type before querying concurrently
+	sm, err := sdb.shards[0].RegisterType(e)
+	if err != nil {
+		return err
+	}
+	orders, err := orderByFields(sm, q.orderByColumns)
+	if err != nil {
+		return err
+	}
+	if q.limit > 0 {
+		// rows dropped by a shard must also be dropped after merging
+		for _, o := range orders {
+			if !sameOrder(o.typ) {
+				return fmt.Errorf(f5, ErrCrossShardOrderBy, q.orderByColumns[o.column])
+			}
+		}
+	}
+	if q.limit > 0 {
+		// each shard returns its first offset+limit rows, the offset is applied after merging
+		options = append(options[:len(options):len(options)], Offset(0), Limit(q.offset+q.limit))
+	} else if q.offset > 0 {
+		options = append(options[:len(options):len(options)], Offset(0))
+	}
+
+	sliceType := t.Elem()
+	parts := make([]reflect.Value, len(sdb.shards))
+	errs := make([]error, len(sdb.shards))
+	var wg sync.WaitGroup
+	for i, db := range sdb.shards {
+		wg.Add(1)
+		go func(i int, db *Database) {
+			defer wg.Done()
+			part := reflect.New(sliceType)
+			if err := db.QueryMultiple(part.Interface(), queryMultipleOptions(e, i, options)...); err != nil {
+				errs[i] = fmt.Errorf(fa, i, err)
+			}
+			parts[i] = part.Elem()
+		}(i, db)
+	}
+	wg.Wait()
+	if err = errors.Join(errs...); err != nil {
+		return err
+	}
+
+	merged := reflect.MakeSlice(sliceType, 0, 0)
+	for _, part := range parts {
+		merged = reflect.AppendSlice(merged, part)
+	}
+	if len(orders) > 0 {
+		less := orderByLess(orders)
+		sort.SliceStable(merged.Interface(), func(i, j int) bool {
+			return less(merged.Index(i), merged.Index(j))
+		})
+	}
+	n := merged.Len()
+	start, end := int(min(q.offset, uint64(n))), n
+	if q.limit > 0 && uint64(start)+q.limit < uint64(n) {
+		end = start + int(q.limit)
+	}
+	dst := reflect.ValueOf(es).Elem()
+	dst.Set(reflect.AppendSlice(dst, merged.Slice(start, end)))
+	return nil
+}
+
+// aggregated 判断 select 的列中是否有 distinct 或者函数（如 count(*)），这些结果无法在分片之间合并。
+func aggregated(columns []string) bool {
+	for _, c := range columns {
+		if strings.ContainsRune(c, '(') {
+			return true
+		}
+		if words := strings.Fields(c); len(words) > 0 && strings.EqualFold(words[0], "distinct") {
+			return true
+		}
+	}
+	return false
+}
+
+// orderByField 是 OrderBy 中的一列对应的字段。
+type orderByField struct {
+	// column 是该列在 OrderBy 中的位置
+	column int
+	index  int
+	typ    reflect.Type
+	desc   bool
+}
+
+// orderByFields 返回 OrderBy 的列对应的字段。列可以带有表名前缀、引号和 asc/desc。
+func orderByFields(sm *structMeta, columns []string) ([]orderByField, error) {
+	orders := make([]orderByField, 0, len(columns))
+	for pos, c := range columns {
+		words := strings.Fields(c)
+		if len(words) == 0 {
+			continue
+		}
+		column := words[0]
+		if i := strings.LastIndexByte(column, '.'); i >= 0 {
+			column = column[i+1:]
+		}
+		column = strings.Trim(column, "`\"[]")
+		fm, ok := sm.columnFieldMap[column]
+		if !ok {
+			return nil, fmt.Errorf(f5, ErrNoFieldForColumn, column)
+		}
+		orders = append(orders, orderByField{pos, fm.index, fm.typ, len(words) > 1 && strings.EqualFold(words[1], "desc")})
+	}
+	return orders, nil
+}
+
+// sameOrder 判断 t 类型字段在 orderByLess 中的顺序是否与所有数据库的排序一致。
+func sameOrder(t reflect.Type) bool {
+	if t, nullable := valueType(t); !nullable {
+		switch t.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+			reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+			reflect.Float32, reflect.Float64, reflect.Bool:
+			return true
+		}
+		return t == timeType
+	}
+	return false
+}
+
+// orderByLess 返回按 orders 比较两个实体（或实体指针）的函数，NULL 排在最前面。
+func orderByLess(orders []orderByField) func(a, b reflect.Value) bool {
+	return func(a, b reflect.Value) bool {
+		a, b = reflect.Indirect(a), reflect.Indirect(b)
+		for _, o := range orders {
+			c := compareValues(sortValue(a.Field(o.index)), sortValue(b.Field(o.index)))
+			if c == 0 {
+				continue
+			}
+			return (c < 0) != o.desc
+		}
+		return false
+	}
+}
+
+// sortValue 将字段的值转换为 int64、uint64、float64、string、bool、time.Time 或 nil 以便比较。
+// 实现了 driver.Valuer 的字段（如 sql.NullString）使用 Value 的结果。
+func sortValue(v reflect.Value) interface{} {
+	for v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return nil
+		}
+		if _, ok := v.Interface().(driver.Valuer); ok {
+			break
+		}
+		v = v.Elem()
+	}
+	if valuer, ok := v.Interface().(driver.Valuer); ok {
+		dv, err := valuer.Value()
+		if err != nil {
+			return nil
+		}
+		if b, ok := dv.([]byte); ok {
+			return string(b)
+		}
+		return dv
+	}
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int()
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return v.Uint()
+	case reflect.Float32, reflect.Float64:
+		return v.Float()
+	case reflect.String:
+		return v.String()
+	case reflect.Bool:
+		return v.Bool()
+	case reflect.Slice:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			return string(v.Bytes())
+		}
+	}
+	if t, ok := v.Interface().(time.Time); ok {
+		return t
+	}
+	return nil
+}
+
+// compareValues 比较 sortValue 的结果，nil 最小，类型不同或无法比较时视为相等。
+func compareValues(a, b interface{}) int {
+	switch {
+	case a == nil && b == nil:
+		return 0
+	case a == nil:
+		return -1
+	case b == nil:
+		return 1
+	}
+	switch x := a.(type) {
+	case int64:
+		if y, ok := b.(int64); ok {
+			return compareOrdered(x, y)
+		}
+	case uint64:
+		if y, ok := b.(uint64); ok {
+			return compareOrdered(x, y)
+		}
+	case float64:
+		if y, ok := b.(float64); ok {
+			return compareOrdered(x, y)
+		}
+	case string:
+		if y, ok := b.(string); ok {
+			return strings.Compare(x, y)
+		}
+	case bool:
+		if y, ok := b.(bool); ok && x != y {
+			if y {
+				return -1
+			}
+			return 1
+		}
+	case time.Time:
+		if y, ok := b.(time.Time); ok {
+			return x.Compare(y)
+		}
+	}
+	return 0
+}
+
+func compareOrdered[T int64 | uint64 | float64](x, y T) int {
+	switch {
+	case x < y:
+		return -1
+	case x > y:
+		return 1
+	}
+	return 0
+}
+
+// ShardedTx 是在一个分片中执行的事务，见 ShardedDatabase.RunTx。
+// 操作其他分片的实体时返回 ErrCrossShardTx，不会执行。
+type ShardedTx struct {
+	tx    *Tx
+	sdb   *ShardedDatabase
+	shard int
+}
+
+// Tx 返回分片中的事务，用于执行 RawQuery 等不经过分片路由的操作。
+func (tx *ShardedTx) Tx() *Tx { return tx.tx }
+
+// Shard 返回事务所在分片的序号。
+func (tx *ShardedTx) Shard() int { return tx.shard }
+
+// check 确认实体在事务所在的分片中。
+func (tx *ShardedTx) check(e IShardedEntity) error {
+	i, err := tx.sdb.route(e)
+	if err != nil {
+		return err
+	}
+	if i != tx.shard {
+		return fmt.Errorf(fb, ErrCrossShardTx, i)
+	}
+	return nil
+}
+
+// checkKey 确认 ShardKey 指定的分片是事务所在的分片，没有使用 ShardKey 时视为事务所在的分片。
+func (tx *ShardedTx) checkKey(key *optShardKey) error {
+	if key == nil {
+		return nil
+	}
+	i, err := tx.sdb.ShardOf(key.key)
+	if err != nil {
+		return err
+	}
+	if i != tx.shard {
+		return fmt.Errorf(fb, ErrCrossShardTx, i)
+	}
+	return nil
+}
+
+func (tx *ShardedTx) Insert(e IShardedEntity, options ...OptionExec) error {
+	if err := tx.check(e); err != nil {
+		return err
+	}
+	return tx.tx.Insert(e, execOptions(e, tx.shard, options)...)
+}
+
+func (tx *ShardedTx) Update(e IShardedEntity, options ...OptionExec) error {
+	if err := tx.check(e); err != nil {
+		return err
+	}
+	return tx.tx.Update(e, execOptions(e, tx.shard, options)...)
+}
+
+func (tx *ShardedTx) Save(e IShardedEntity, options ...OptionExec) error {
+	if err := tx.check(e); err != nil {
+		return err
+	}
+	return tx.tx.Save(e, execOptions(e, tx.shard, options)...)
+}
+
+// Query 在事务所在的分片中查询一条记录，可以省略 ShardKey。
+func (tx *ShardedTx) Query(entity interface{}, options ...OptionQuerySingle) (found bool, err error) {
+	e, err := shardedEntity(entity)
+	if err != nil {
+		return
+	}
+	q := &optQuerySingle{}
+	for _, opt := range options {
+		opt.applyToOptionQuerySingle(q)
+	}
+	if err = tx.checkKey(q.shardKey); err != nil {
+		return
+	}
+	return tx.tx.Query(entity, querySingleOptions(e, tx.shard, options)...)
+}
+
+// QueryMultiple 在事务所在的分片中查询多条记录，不会查询其他分片，可以省略 ShardKey。
+func (tx *ShardedTx) QueryMultiple(es interface{}, options ...OptionQueryMultiple) error {
+	e, err := shardedEntity(es)
+	if err != nil {
+		return err
+	}
+	q := &optQueryMultiple{}
+	for _, opt := range options {
+		opt.applyToOptionQueryMultiple(q)
+	}
+	if err = tx.checkKey(q.shardKey); err != nil {
+		return err
+	}
+	return tx.tx.QueryMultiple(es, queryMultipleOptions(e, tx.shard, options)...)
+}
+
+// RunTx 在分片键的值为 key 的分片中执行事务，使用方法与 db.RunTx 一致。
+// 不支持跨分片的事务：在事务中操作其他分片的实体时返回 ErrCrossShardTx。
+//
+//	sdb.RunTx(userID, func(tx *ShardedTx) (bool, error) {
+//	    if err := tx.Insert(&order); err != nil {
+//	        return false, err
+//	    }
+//	    return true, tx.Update(&account)
+//	})
+func (sdb *ShardedDatabase) RunTx(
+	key interface{},
+	run func(tx *ShardedTx) (commit bool, err error),
+) (TransactionStep, error) {
+	return sdb.RunTxWithOptions(key, run, nil)
+}
+
+func (sdb *ShardedDatabase) RunTxWithOptions(
+	key interface{},
+	run func(tx *ShardedTx) (commit bool, err error),
+	txOptions *sql.TxOptions,
+) (TransactionStep, error) {
+	i, err := sdb.ShardOf(key)
+	if err != nil {
+		return StepBegin, err
+	}
+	return sdb.shards[i].RunTxWithOptions(func(tx *Tx) (bool, error) {
+		return run(&ShardedTx{tx: tx, sdb: sdb, shard: i})
+	}, txOptions)
+}
@@ -0,0 +1,147 @@
+package sqlwrapper
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// shardUser 按 id 分片，第 i 个分片中的表名为 user_i。
+type shardUser scanUser
+
+func (shardUser) TableName() string  { return "user" }
+func (shardUser) PkColumn() string   { return "id" }
+func (shardUser) ShardKey() string   { return "id" }
+func (shardUser) ShardTable() string { return "user_{shard}" }
+
+func openShardedDatabase(t *testing.T) (*ShardedDatabase, *fakeDB) {
+	f := newFakeDB(t)
+	sdb, err := NewShardedDatabase([]*Database{
+		f.open(t, "shard0"),
+		f.open(t, "shard1"),
+		f.open(t, "shard2"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sdb, f
+}
+
+func TestModShard(t *testing.T) {
+	for _, c := range []struct {
+		key  interface{}
+		want int
+	}{
+		{int64(7), 1}, {-7, 1}, {uint8(9), 0}, {"user", 0}, {[]byte("user"), 0},
+	} {
+		if got, err := ModShard(c.key, 3); err != nil || got != c.want {
+			t.Errorf("%v: got %d %v, want %d", c.key, got, err, c.want)
+		}
+	}
+	if _, err := ModShard(1.5, 3); !errors.Is(err, ErrUnsupportedShardKey) {
+		t.Errorf("got %v, want %v", err, ErrUnsupportedShardKey)
+	}
+}
+
+func TestShardedDatabase(t *testing.T) {
+	sdb, f := openShardedDatabase(t)
+
+	if err := sdb.Insert(&shardUser{ID: 4, Name: "a"}); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := f.lastExec(t).query, "insert into user_1 (id, name) values (?, ?)"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if err := sdb.Update(&shardUser{ID: 5, Name: "b"}); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := f.lastExec(t).query, "update user_2 set name = ? where id = ?"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	var u shardUser
+	if _, err := sdb.Query(&u); err != ErrNoShardKey {
+		t.Errorf("got %v, want %v", err, ErrNoShardKey)
+	}
+	if found, err := sdb.Query(&u, ShardKey(3), Where("id = ?", 3)); !found || err != nil {
+		t.Fatal(found, err)
+	}
+	var us []shardUser
+	if err := sdb.QueryMultiple(&us, ShardKey(2)); err != nil || len(us) != fakeRowCount {
+		t.Fatal(len(us), err)
+	}
+	if got, want := f.takeQueried(), []string{"shard0", "shard2"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	// fan out: every shard returns the same fakeRowCount rows
+	for _, c := range []struct {
+		name    string
+		options []OptionQueryMultiple
+		want    []int64
+	}{
+		{"order by", []OptionQueryMultiple{OrderBy("score desc", "id"), Limit(5)}, []int64{100, 100, 100, 99, 99}},
+		{"offset", []OptionQueryMultiple{OrderBy("u.`score` DESC"), Offset(2), Limit(2)}, []int64{100, 99}},
+		{"no order", []OptionQueryMultiple{Limit(4)}, []int64{1, 2, 3, 4}},
+	} {
+		us = nil
+		if err := sdb.QueryMultiple(&us, c.options...); err != nil {
+			t.Fatal(c.name, err)
+		}
+		got := []int64{}
+		for _, u := range us {
+			got = append(got, u.ID)
+		}
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s: got %v, want %v", c.name, got, c.want)
+		}
+	}
+	if err := sdb.QueryMultiple(&us, OrderBy("unknown")); !errors.Is(err, ErrNoFieldForColumn) {
+		t.Errorf("got %v, want %v", err, ErrNoFieldForColumn)
+	}
+	for _, c := range []struct {
+		options []OptionQueryMultiple
+		err     error
+	}{
+		{[]OptionQueryMultiple{OrderBy("name"), Limit(5)}, ErrCrossShardOrderBy},
+		{[]OptionQueryMultiple{OrderBy("id", "remark desc"), Limit(5)}, ErrCrossShardOrderBy},
+		{[]OptionQueryMultiple{Select("name", "count(*)"), GroupBy("name")}, ErrCrossShardQuery},
+		{[]OptionQueryMultiple{Select("max(score)")}, ErrCrossShardQuery},
+		{[]OptionQueryMultiple{Select("distinct name")}, ErrCrossShardQuery},
+		{[]OptionQueryMultiple{Union(Where("id > ?", 1))}, ErrCrossShardQuery},
+	} {
+		if err := sdb.QueryMultiple(&us, c.options...); !errors.Is(err, c.err) {
+			t.Errorf("got %v, want %v", err, c.err)
+		}
+	}
+	// without limit every row is merged, any column can be used
+	us = nil
+	if err := sdb.QueryMultiple(&us, OrderBy("name desc")); err != nil || len(us) != 3*fakeRowCount || us[0].Name != "user99" {
+		t.Errorf("got %d rows, %v", len(us), err)
+	}
+	if err := sdb.QueryMultiple(&[]scanUser{}); !errors.Is(err, ErrNotShardedEntity) {
+		t.Errorf("got %v, want %v", err, ErrNotShardedEntity)
+	}
+}
+
+func TestShardedTx(t *testing.T) {
+	sdb, f := openShardedDatabase(t)
+	step, err := sdb.RunTx(4, func(tx *ShardedTx) (bool, error) {
+		if tx.Shard() != 1 {
+			t.Errorf("got shard %d, want 1", tx.Shard())
+		}
+		if err := tx.Insert(&shardUser{ID: 7}); err != nil {
+			return false, err
+		}
+		if _, err := tx.Query(&shardUser{}, ShardKey(10)); err != nil {
+			return false, err
+		}
+		return true, tx.Update(&shardUser{ID: 5, Name: "b"})
+	})
+	if step != StepRun || !errors.Is(err, ErrCrossShardTx) {
+		t.Errorf("got %v %v, want %v", step, err, ErrCrossShardTx)
+	}
+	if commits, rollbacks := f.takeTxCounts(); commits != 0 || rollbacks != 1 {
+		t.Errorf("got %d commits, %d rollbacks", commits, rollbacks)
+	}
+}
@@ -68,7 +68,7 @@ func (ctx *SqlCtx) Reset() {
 	ctx.phid = 0
 }
 
-func (ctx *SqlCtx) selectFromTable(columns []string, table optTable) (err error) {
+func (ctx *SqlCtx) selectFromTable(columns []string, table optTable, lock optLock) (err error) {
 	ctx.WriteString("select ")
 	if len(columns) == 0 {
 		ctx.WriteByte('*')
@@ -76,6 +76,11 @@ func (ctx *SqlCtx) selectFromTable(columns []string, table optTable) (err error)
 		ctx.WriteString(strings.Join(columns, ", "))
 	}
 	ctx.WriteString(" from ")
+	return ctx.tableReference(table, lock)
+}
+
+// tableReference writes the table, its alias and joins.
+func (ctx *SqlCtx) tableReference(table optTable, lock optLock) (err error) {
 	err = table.table.AppendToSqlCtx(ctx)
 	if err != nil {
 		return
@@ -83,6 +88,7 @@ func (ctx *SqlCtx) selectFromTable(columns []string, table optTable) (err error)
 	if len(table.alias) > 0 {
 		ctx.WriteString(" as ").WriteQuotedString(table.alias)
 	}
+	ctx.tableHint(lock)
 	for _, join := range table.joins {
 		ctx.WriteByte(' ').
 			WriteString(join.joinType).
@@ -199,6 +205,38 @@ func (ctx *SqlCtx) orderBy(columns ...string) {
 // Yeah. It's a "feature" of MySQL. See https://dev.mysql.com/doc/refman/8.0/en/select.html
 const MySQLUnlimit uint64 = 18446744073709551615
 
+// orderByLimitOffset writes order by and pagination clauses of the driver.
+func (ctx *SqlCtx) orderByLimitOffset(columns []string, limit, offset uint64) {
+	switch ctx.driver {
+	case "oci8", "oracle":
+		ctx.orderBy(columns...)
+		ctx.offsetFetchNextRows(offset, limit)
+	case "mssql", "sqlserver":
+		if limit == 0 && offset == 0 {
+			ctx.orderBy(columns...)
+			return
+		}
+		// SQL Server requires both order by and offset before fetch.
+		if len(columns) == 0 {
+			ctx.WriteString(" order by 1")
+		} else {
+			ctx.orderBy(columns...)
+		}
+		ctx.WriteString(" offset ").NextPlaceholder(offset).WriteString(" rows")
+		if limit > 0 {
+			ctx.WriteString(" fetch next ").NextPlaceholder(limit).WriteString(" rows only")
+		}
+	case "mysql":
+		if limit == 0 && offset > 0 {
+			limit = MySQLUnlimit
+		}
+		fallthrough
+	default:
+		ctx.orderBy(columns...)
+		ctx.limitOffset(limit, offset)
+	}
+}
+
 func (ctx *SqlCtx) limitOffset(limit, offset uint64) {
 	if limit > 0 {
 		ctx.WriteString(" limit ").NextPlaceholder(limit)
@@ -216,3 +254,247 @@ func (ctx *SqlCtx) offsetFetchNextRows(offset, limit uint64) {
 		ctx.WriteString(" fetch next ").NextPlaceholder(limit).WriteString(" rows only")
 	}
 }
+
+// tableHint writes the locking table hints of SQL Server, which should follow
+// the table name (and alias).
+func (ctx *SqlCtx) tableHint(lock optLock) {
+	if len(lock.strength) == 0 {
+		return
+	}
+	switch ctx.driver {
+	case "mssql", "sqlserver":
+	default:
+		return
+	}
+	switch lock.strength {
+	case "share":
+		ctx.WriteString(" with (holdlock, rowlock")
+	default:
+		ctx.WriteString(" with (updlock, rowlock")
+	}
+	switch lock.wait {
+	case "skip locked":
+		ctx.WriteString(", readpast")
+	case "nowait":
+		ctx.WriteString(", nowait")
+	}
+	ctx.WriteByte(')')
+}
+
+// lockClause writes the locking clause at the end of select.
+func (ctx *SqlCtx) lockClause(lock optLock) error {
+	if len(lock.strength) == 0 {
+		return nil
+	}
+	switch ctx.driver {
+	case "mssql", "sqlserver":
+		// see tableHint
+		return nil
+	case "sqlite", "sqlite3":
+		return ErrLockNotSupported
+	case "oci8", "oracle":
+		if lock.strength == "share" {
+			return ErrLockNotSupported
+		}
+	}
+	ctx.WriteString(" for ").WriteString(lock.strength)
+	if len(lock.wait) > 0 {
+		ctx.WriteByte(' ').WriteString(lock.wait)
+	}
+	return nil
+}
+
+func (ctx *SqlCtx) insertSelect(table string, columns []string, q optQuery) error {
+	ctx.WriteString("insert into ").WriteQuotedString(table)
+	if len(columns) > 0 {
+		ctx.WriteString(" (")
+		for i, col := range columns {
+			if i > 0 {
+				ctx.WriteString(", ")
+			}
+			ctx.WriteQuotedString(col)
+		}
+		ctx.WriteByte(')')
+	}
+	ctx.WriteByte(' ')
+	// placeholders of the select continue numbering in the same context
+	return q.AppendToSqlCtx(ctx)
+}
+
+func (ctx *SqlCtx) update(table string, o *optUpdate) (err error) {
+	if len(o.sets) == 0 {
+		return ErrEmptySet
+	}
+	hasFrom := o.from.table != nil
+	ctx.WriteString("update ")
+	switch ctx.driver {
+	case "mssql", "sqlserver":
+		if o.limit > 0 {
+			ctx.WriteString("top (").NextPlaceholder(o.limit).WriteString(") ")
+		}
+	}
+	ctx.WriteQuotedString(table)
+	if hasFrom && ctx.driver == "mysql" {
+		// update a, b set ... where ...
+		ctx.WriteString(", ")
+		err = ctx.tableReference(o.from, optLock{})
+		if err != nil {
+			return
+		}
+	}
+	ctx.WriteString(" set ")
+	for i, set := range o.sets {
+		if i > 0 {
+			ctx.WriteString(", ")
+		}
+		err = ctx.clauseWithArgs(set.clause, set.args...)
+		if err != nil {
+			return
+		}
+	}
+	err = ctx.output("inserted", o.returning.columns)
+	if err != nil {
+		return
+	}
+	if hasFrom {
+		switch ctx.driver {
+		case "mysql":
+			// written before set
+		case "oci8", "oracle":
+			return ErrFromNotSupported
+		default:
+			ctx.WriteString(" from ")
+			err = ctx.tableReference(o.from, optLock{})
+			if err != nil {
+				return
+			}
+		}
+	}
+	err = ctx.where(o.whereClause, o.whereArgs...)
+	if err != nil {
+		return
+	}
+	err = ctx.mutationLimit(o.limit, o.from.table != nil)
+	if err != nil {
+		return
+	}
+	return ctx.returning(o.returning.columns)
+}
+
+func (ctx *SqlCtx) delete(table string, o *optDelete) (err error) {
+	ctx.WriteString("delete ")
+	switch ctx.driver {
+	case "mssql", "sqlserver":
+		if o.limit > 0 {
+			ctx.WriteString("top (").NextPlaceholder(o.limit).WriteString(") ")
+		}
+	}
+	if o.from.table == nil {
+		ctx.WriteString("from ").WriteQuotedString(table)
+		err = ctx.output("deleted", o.returning.columns)
+		if err != nil {
+			return
+		}
+	} else {
+		switch ctx.driver {
+		case "sqlite", "sqlite3", "oci8", "oracle":
+			return ErrFromNotSupported
+		case "mysql", "mssql", "sqlserver":
+			// delete a from a, b where ...
+			ctx.WriteQuotedString(table)
+			err = ctx.output("deleted", o.returning.columns)
+			if err != nil {
+				return
+			}
+			ctx.WriteString(" from ").WriteQuotedString(table).WriteString(", ")
+		default:
+			// delete from a using b where ...
+			ctx.WriteString("from ").WriteQuotedString(table).WriteString(" using ")
+		}
+		err = ctx.tableReference(o.from, optLock{})
+		if err != nil {
+			return
+		}
+	}
+	err = ctx.where(o.whereClause, o.whereArgs...)
+	if err != nil {
+		return
+	}
+	err = ctx.mutationLimit(o.limit, o.from.table != nil)
+	if err != nil {
+		return
+	}
+	return ctx.returning(o.returning.columns)
+}
+
+// mutationLimit writes the limit clause of update and delete.
+// SQL Server uses "top (n)" instead, which is written in the beginning.
+// MySQL does not allow limit in multi-table update and delete.
+func (ctx *SqlCtx) mutationLimit(limit uint64, multiTable bool) error {
+	if limit == 0 {
+		return nil
+	}
+	switch ctx.driver {
+	case "mysql":
+		if multiTable {
+			return ErrMultiTableLimit
+		}
+		ctx.WriteString(" limit ").NextPlaceholder(limit)
+	case "mssql", "sqlserver":
+	default:
+		return ErrLimitNotSupported
+	}
+	return nil
+}
+
+// output writes the output clause of SQL Server. prefix is either "inserted"
+// or "deleted".
+func (ctx *SqlCtx) output(prefix string, columns []string) error {
+	if len(columns) == 0 {
+		return nil
+	}
+	switch ctx.driver {
+	case "mssql", "sqlserver":
+	default:
+		return nil
+	}
+	ctx.WriteString(" output ")
+	for i, col := range columns {
+		if i > 0 {
+			ctx.WriteString(", ")
+		}
+		ctx.WriteString(prefix).WriteByte('.')
+		if col == "*" {
+			ctx.WriteByte('*')
+			continue
+		}
+		ctx.WriteQuotedString(col)
+	}
+	return nil
+}
+
+// returning writes the returning clause of Postgresql and SQLite.
+func (ctx *SqlCtx) returning(columns []string) error {
+	if len(columns) == 0 {
+		return nil
+	}
+	switch ctx.driver {
+	case "mssql", "sqlserver":
+		// see output
+		return nil
+	case "mysql", "oci8", "oracle":
+		return ErrReturningNotSupported
+	}
+	ctx.WriteString(" returning ")
+	for i, col := range columns {
+		if i > 0 {
+			ctx.WriteString(", ")
+		}
+		if col == "*" {
+			ctx.WriteByte('*')
+			continue
+		}
+		ctx.WriteQuotedString(col)
+	}
+	return nil
+}
@@ -0,0 +1,53 @@
+package sqlwrappertest
+
+import (
+	"regexp"
+	"strconv"
+
+	"github.com/FlyingOnion/pkg/sqlwrapper"
+)
+
+// Matcher 判断语句是否符合预期。
+type Matcher interface {
+	Match(s Statement) bool
+	String() string
+}
+
+type sqlMatcher string
+
+func (m sqlMatcher) Match(s Statement) bool { return s.Normalized == string(m) }
+func (m sqlMatcher) String() string         { return strconv.Quote(string(m)) }
+
+// SQL 匹配 Normalize 之后与 query 相同的语句。query 使用 ? 作为占位符，不需要引号，连续的空白视为一个空格。
+//
+//	SQL("select id, name from user where id = ?")
+func SQL(query string) Matcher {
+	return sqlMatcher(Normalize(plain, query))
+}
+
+type exactMatcher string
+
+func (m exactMatcher) Match(s Statement) bool { return s.SQL == string(m) }
+func (m exactMatcher) String() string         { return "exact " + strconv.Quote(string(m)) }
+
+// Exact 匹配与 query 完全相同的语句，用于检查指定 Dialect 生成的引号和占位符。
+//
+//	Exact(`select "id", "name" from "user" where "id" = $1`)
+func Exact(query string) Matcher { return exactMatcher(query) }
+
+type regexpMatcher struct{ re *regexp.Regexp }
+
+func (m regexpMatcher) Match(s Statement) bool { return m.re.MatchString(s.Normalized) }
+func (m regexpMatcher) String() string         { return "regexp " + strconv.Quote(m.re.String()) }
+
+// Regexp 匹配 Normalize 之后符合正则表达式 expr 的语句，expr 不合法时 panic。
+//
+//	Regexp(`^update user set .* where id = \?$`)
+func Regexp(expr string) Matcher { return regexpMatcher{regexp.MustCompile(expr)} }
+
+// plain 只用于合并 SQL 中的空白。
+var plain = sqlwrapper.CustomDialect{
+	ColumnNameConverter: sqlwrapper.AsIs,
+	Placeholder:         sqlwrapper.QuestionMark,
+	Quoter:              sqlwrapper.NoQuotes,
+}
@@ -0,0 +1,488 @@
+// Package sqlwrappertest 提供一个内存中的 database/sql 驱动，不需要真实的数据库就能测试使用 sqlwrapper 的代码。
+//
+// Mock 记录收到的每一条语句。没有添加预期时接受所有语句（记录模式）：执行总是成功，查询返回空结果。
+// 添加预期后，语句（包括事务的开始、提交和回滚）必须按顺序符合预期，并返回预设的结果、行或错误。
+//
+//	m := sqlwrappertest.New("pgx")
+//	db, err := m.NewDatabase()
+//	if err != nil {
+//	    t.Fatal(err)
+//	}
+//	m.ExpectQuery(sqlwrappertest.SQL("insert into users (name) values (?) returning id")).
+//	    WithArgs("foo").
+//	    WillReturnRows(sqlwrappertest.NewRows("id").AddRow(1))
+//	err = db.Insert(&User{Name: "foo"})
+//	if err := m.ExpectationsWereMet(); err != nil {
+//	    t.Error(err)
+//	}
+//
+// 语句以 Normalize 之后的形式匹配，所以预期的 SQL 只需要用 ? 作为占位符、不加引号写一次，就可以用于所有 Dialect。
+package sqlwrappertest
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/FlyingOnion/pkg/sqlwrapper"
+)
+
+var (
+	ErrUnexpectedStatement = errors.New("unexpected statement")
+	ErrUnmetExpectation    = errors.New("expectation was not met")
+)
+
+const (
+	fUnexpected = "%w: %s %q %v"
+	fMismatch   = "%w: got %s %q %v, want %s"
+	fUnmet      = "%w: %s"
+)
+
+// Kind 是语句的类型。
+type Kind uint8
+
+const (
+	Exec     Kind = iota // 执行（Exec）
+	Query                // 查询（Query），sqlwrapper 使用 returning 的写入语句也是查询
+	Begin                // 开始事务
+	Commit               // 提交事务
+	Rollback             // 回滚事务
+)
+
+func (k Kind) String() string {
+	switch k {
+	case Exec:
+		return "exec"
+	case Query:
+		return "query"
+	case Begin:
+		return "begin"
+	case Commit:
+		return "commit"
+	case Rollback:
+		return "rollback"
+	}
+	return "kind(" + strconv.Itoa(int(k)) + ")"
+}
+
+// tx 判断 k 是否是事务的开始、提交或回滚。
+func (k Kind) tx() bool { return k >= Begin }
+
+// Statement 是 Mock 收到的一条语句。事务的开始、提交和回滚的 SQL 分别为 begin、commit 和 rollback。
+type Statement struct {
+	// SQL 是驱动收到的语句
+	SQL string
+	// Normalized 是去掉引号、占位符替换为 ? 后的语句，见 Normalize
+	Normalized string
+	Args       []driver.Value
+	Kind       Kind
+}
+
+// Mock 是记录语句并返回预设结果的 driver.Connector，可以同时在多个 goroutine 中使用。
+type Mock struct {
+	driver  string
+	dialect sqlwrapper.Dialect
+
+	mu           sync.Mutex
+	statements   []Statement
+	expectations []*Expectation
+	next         int
+	failures     []error
+}
+
+// New 返回模拟 driver 的 Mock。driver 用于选择 Dialect 和 sqlwrapper 生成的 SQL 语句，如 "pgx"、"mysql"。
+func New(driver string) *Mock {
+	return &Mock{driver: driver, dialect: sqlwrapper.GetDialect(driver)}
+}
+
+// NewDatabase 返回使用 m 的 Database。options 中不能使用 WithDialect 改变 Dialect，否则 Normalize 的结果不正确。
+func (m *Mock) NewDatabase(options ...sqlwrapper.OptionDB) (*sqlwrapper.Database, error) {
+	options = append([]sqlwrapper.OptionDB{sqlwrapper.WithConnector(m)}, options...)
+	return sqlwrapper.NewDatabase(m.driver, "", options...)
+}
+
+// Dialect 返回 m 使用的 Dialect。
+func (m *Mock) Dialect() sqlwrapper.Dialect { return m.dialect }
+
+// Statements 返回收到的所有语句。
+func (m *Mock) Statements() []Statement {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]Statement{}, m.statements...)
+}
+
+// Reset 清除收到的语句、预期和失败，回到记录模式。
+func (m *Mock) Reset() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.statements, m.expectations, m.next, m.failures = nil, nil, 0, nil
+}
+
+// ExpectExec 添加一个执行（Exec）的预期。
+func (m *Mock) ExpectExec(matcher Matcher) *Expectation {
+	return m.expect(Exec, matcher)
+}
+
+// ExpectQuery 添加一个查询（Query）的预期。sqlwrapper 使用 returning 的写入语句也是查询。
+func (m *Mock) ExpectQuery(matcher Matcher) *Expectation {
+	return m.expect(Query, matcher)
+}
+
+// ExpectBegin 添加一个开始事务的预期，可以用 WillReturnError 使开始事务失败。
+func (m *Mock) ExpectBegin() *Expectation { return m.expect(Begin, nil) }
+
+// ExpectCommit 添加一个提交事务的预期，可以用 WillReturnError 使提交失败，如测试 WithTxRetry。
+func (m *Mock) ExpectCommit() *Expectation { return m.expect(Commit, nil) }
+
+// ExpectRollback 添加一个回滚事务的预期，可以用 WillReturnError 使回滚失败。
+func (m *Mock) ExpectRollback() *Expectation { return m.expect(Rollback, nil) }
+
+func (m *Mock) expect(kind Kind, matcher Matcher) *Expectation {
+	e := &Expectation{kind: kind, matcher: matcher}
+	m.mu.Lock()
+	m.expectations = append(m.expectations, e)
+	m.mu.Unlock()
+	return e
+}
+
+// ExpectationsWereMet 返回不符合预期的语句和没有执行的预期，都符合时返回 nil。
+func (m *Mock) ExpectationsWereMet() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	errs := append([]error{}, m.failures...)
+	for _, e := range m.expectations[m.next:] {
+		errs = append(errs, fmt.Errorf(fUnmet, ErrUnmetExpectation, e))
+	}
+	return errors.Join(errs...)
+}
+
+// handle 记录语句并返回对应的预期，记录模式下返回 nil。
+func (m *Mock) handle(kind Kind, stmt string, args []driver.Value) (*Expectation, error) {
+	s := Statement{SQL: stmt, Normalized: stmt, Args: args, Kind: kind}
+	if !kind.tx() {
+		s.Normalized = Normalize(m.dialect, stmt)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.statements = append(m.statements, s)
+	if len(m.expectations) == 0 {
+		return nil, nil
+	}
+	if m.next == len(m.expectations) {
+		err := fmt.Errorf(fUnexpected, ErrUnexpectedStatement, s.Kind, s.Normalized, s.Args)
+		m.failures = append(m.failures, err)
+		return nil, err
+	}
+	e := m.expectations[m.next]
+	if !e.match(s) {
+		err := fmt.Errorf(fMismatch, ErrUnexpectedStatement, s.Kind, s.Normalized, s.Args, e)
+		m.failures = append(m.failures, err)
+		return nil, err
+	}
+	m.next++
+	return e, e.err
+}
+
+// Connect 实现了 driver.Connector。
+func (m *Mock) Connect(context.Context) (driver.Conn, error) { return &conn{m}, nil }
+
+// Driver 实现了 driver.Connector。
+func (m *Mock) Driver() driver.Driver { return mockDriver{m} }
+
+type mockDriver struct{ m *Mock }
+
+func (d mockDriver) Open(string) (driver.Conn, error) { return &conn{d.m}, nil }
+
+// Expectation 是对一条语句的预期和预设的结果。
+type Expectation struct {
+	kind    Kind
+	matcher Matcher
+	args    []interface{}
+	hasArgs bool
+
+	result driver.Result
+	rows   *Rows
+	err    error
+}
+
+// WithArgs 指定语句的参数，参数按 database/sql 的规则转换后比较（如 int 转换为 int64）。可以使用 AnyArg 匹配任意参数。
+func (e *Expectation) WithArgs(args ...interface{}) *Expectation {
+	e.args, e.hasArgs = args, true
+	return e
+}
+
+// WillReturnResult 指定执行的结果。没有指定时 RowsAffected 为 1，LastInsertId 返回错误。
+func (e *Expectation) WillReturnResult(lastInsertID, rowsAffected int64) *Expectation {
+	e.result = result{lastInsertID, rowsAffected}
+	return e
+}
+
+// WillReturnRows 指定查询的结果。没有指定时返回没有列的空结果。
+func (e *Expectation) WillReturnRows(rows *Rows) *Expectation {
+	e.rows = rows
+	return e
+}
+
+// WillReturnError 指定执行、查询或事务操作返回的错误。
+func (e *Expectation) WillReturnError(err error) *Expectation {
+	e.err = err
+	return e
+}
+
+func (e *Expectation) String() string {
+	switch {
+	case e.kind.tx():
+		return e.kind.String()
+	case e.hasArgs:
+		return fmt.Sprintf("%s %s %v", e.kind, e.matcher, e.args)
+	}
+	return e.kind.String() + " " + e.matcher.String()
+}
+
+func (e *Expectation) match(s Statement) bool {
+	if e.kind != s.Kind {
+		return false
+	}
+	if e.kind.tx() {
+		return true
+	}
+	if !e.matcher.Match(s) {
+		return false
+	}
+	if !e.hasArgs {
+		return true
+	}
+	if len(e.args) != len(s.Args) {
+		return false
+	}
+	for i, want := range e.args {
+		if !matchArg(want, s.Args[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// Argument 匹配语句的一个参数，见 AnyArg。
+type Argument interface {
+	Match(v driver.Value) bool
+}
+
+type anyArg struct{}
+
+func (anyArg) Match(driver.Value) bool { return true }
+func (anyArg) String() string          { return "<any>" }
+
+// AnyArg 匹配任意参数，用于 WithArgs 中无法预知的参数，如当前时间。
+func AnyArg() Argument { return anyArg{} }
+
+func matchArg(want interface{}, got driver.Value) bool {
+	if a, ok := want.(Argument); ok {
+		return a.Match(got)
+	}
+	want, err := driver.DefaultParameterConverter.ConvertValue(want)
+	if err != nil {
+		return false
+	}
+	if t, ok := want.(time.Time); ok {
+		g, ok := got.(time.Time)
+		return ok && t.Equal(g)
+	}
+	return reflect.DeepEqual(want, got)
+}
+
+type result struct{ lastInsertID, rowsAffected int64 }
+
+func (r result) LastInsertId() (int64, error) { return r.lastInsertID, nil }
+func (r result) RowsAffected() (int64, error) { return r.rowsAffected, nil }
+
+// Rows 是查询的预设结果。
+type Rows struct {
+	columns []string
+	values  [][]driver.Value
+}
+
+// NewRows 返回列名为 columns 的空结果。
+func NewRows(columns ...string) *Rows { return &Rows{columns: columns} }
+
+// AddRow 添加一行，values 按 database/sql 的规则转换（如 int 转换为 int64），无法转换时保持原样。
+func (r *Rows) AddRow(values ...interface{}) *Rows {
+	row := make([]driver.Value, len(values))
+	for i, v := range values {
+		if dv, err := driver.DefaultParameterConverter.ConvertValue(v); err == nil {
+			v = dv
+		}
+		row[i] = v
+	}
+	r.values = append(r.values, row)
+	return r
+}
+
+// rows 是 Rows 的迭代器，同一个 Rows 可以被多次返回。
+type rows struct {
+	*Rows
+	i int
+}
+
+func (r *rows) Columns() []string { return r.columns }
+func (r *rows) Close() error      { return nil }
+func (r *rows) Next(dest []driver.Value) error {
+	if r.i == len(r.values) {
+		return io.EOF
+	}
+	copy(dest, r.values[r.i])
+	r.i++
+	return nil
+}
+
+type conn struct{ m *Mock }
+
+func (c *conn) Prepare(query string) (driver.Stmt, error) { return &stmt{c, query}, nil }
+func (c *conn) Close() error                              { return nil }
+func (c *conn) Begin() (driver.Tx, error) {
+	if _, err := c.m.handle(Begin, "begin", nil); err != nil {
+		return nil, err
+	}
+	return tx{c.m}, nil
+}
+
+func (c *conn) ExecContext(_ context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	return c.exec(query, values(args))
+}
+
+func (c *conn) QueryContext(_ context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	return c.query(query, values(args))
+}
+
+func (c *conn) exec(query string, args []driver.Value) (driver.Result, error) {
+	e, err := c.m.handle(Exec, query, args)
+	if err != nil {
+		return nil, err
+	}
+	if e == nil || e.result == nil {
+		return driver.RowsAffected(1), nil
+	}
+	return e.result, nil
+}
+
+func (c *conn) query(query string, args []driver.Value) (driver.Rows, error) {
+	e, err := c.m.handle(Query, query, args)
+	if err != nil {
+		return nil, err
+	}
+	if e == nil || e.rows == nil {
+		return &rows{Rows: &Rows{}}, nil
+	}
+	return &rows{Rows: e.rows}, nil
+}
+
+func values(args []driver.NamedValue) []driver.Value {
+	vs := make([]driver.Value, len(args))
+	for i, a := range args {
+		vs[i] = a.Value
+	}
+	return vs
+}
+
+type stmt struct {
+	c     *conn
+	query string
+}
+
+func (s *stmt) Close() error  { return nil }
+func (s *stmt) NumInput() int { return -1 }
+func (s *stmt) Exec(args []driver.Value) (driver.Result, error) {
+	return s.c.exec(s.query, args)
+}
+func (s *stmt) Query(args []driver.Value) (driver.Rows, error) {
+	return s.c.query(s.query, args)
+}
+
+type tx struct{ m *Mock }
+
+func (t tx) Commit() error {
+	_, err := t.m.handle(Commit, "commit", nil)
+	return err
+}
+
+func (t tx) Rollback() error {
+	_, err := t.m.handle(Rollback, "rollback", nil)
+	return err
+}
+
+// Normalize 将 dialect 生成的语句转换为与数据库无关的形式：去掉标识符的引号，占位符替换为 ?，
+// 连续的空白合并为一个空格。单引号中的字符串保持原样。
+//
+//	Normalize(sqlwrapper.GetDialect("pgx"), `select "id" from "user" where "id" = $1`)
+//	// select id from user where id = ?
+func Normalize(dialect sqlwrapper.Dialect, query string) string {
+	var quotes string
+	if q := dialect.Quote("x"); len(q) > 1 {
+		quotes = strings.Replace(q, "x", "", 1)
+	}
+	p1 := dialect.HoldPlace(1)
+	prefix := ""
+	if p1 != "?" && p1 != dialect.HoldPlace(2) {
+		prefix = strings.TrimSuffix(p1, "1")
+	}
+
+	b := make([]byte, 0, len(query))
+	space := false
+	for i := 0; i < len(query); i++ {
+		c := query[i]
+		switch {
+		case c == '\'':
+			// copy the string literal, '' is an escaped quote
+			j := i + 1
+			for j < len(query) {
+				if query[j] == '\'' {
+					if j+1 < len(query) && query[j+1] == '\'' {
+						j += 2
+						continue
+					}
+					break
+				}
+				j++
+			}
+			if j < len(query) {
+				j++
+			}
+			if space && len(b) > 0 {
+				b = append(b, ' ')
+			}
+			space = false
+			b = append(b, query[i:j]...)
+			i = j - 1
+			continue
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			space = true
+			continue
+		case len(quotes) > 0 && strings.IndexByte(quotes, c) >= 0:
+			continue
+		}
+		if space && len(b) > 0 {
+			b = append(b, ' ')
+		}
+		space = false
+		if len(prefix) > 0 && strings.HasPrefix(query[i:], prefix) {
+			j := i + len(prefix)
+			for j < len(query) && query[j] >= '0' && query[j] <= '9' {
+				j++
+			}
+			if j > i+len(prefix) {
+				b = append(b, '?')
+				i = j - 1
+				continue
+			}
+		}
+		b = append(b, c)
+	}
+	return string(b)
+}
@@ -0,0 +1,186 @@
+package sqlwrappertest
+
+import (
+	"database/sql/driver"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/FlyingOnion/pkg/sqlwrapper"
+)
+
+type user struct {
+	ID   int64
+	Name string
+}
+
+func (user) TableName() string { return "user" }
+func (user) PkColumn() string  { return "id" }
+
+func TestNormalize(t *testing.T) {
+	for _, c := range []struct {
+		driver, query, want string
+	}{
+		{"mysql", "select `id`,\n\t`name` from `user` where `id` = ?", "select id, name from user where id = ?"},
+		{"pgx", `select "id" from "user" where "id" = $1 and "name" = $12`, "select id from user where id = ? and name = ?"},
+		{"sqlserver", "select [id] from [user] where [id] = @p1", "select id from user where id = ?"},
+		{"oracle", `select "ID" from "USER" where "ID" = :1`, "select ID from USER where ID = ?"},
+		{"pgx", `select 'a  "b"  $1', 'it''s' from "t"`, `select 'a  "b"  $1', 'it''s' from t`},
+	} {
+		if got := Normalize(sqlwrapper.GetDialect(c.driver), c.query); got != c.want {
+			t.Errorf("%s: got %q, want %q", c.driver, got, c.want)
+		}
+	}
+}
+
+func TestRecording(t *testing.T) {
+	m := New("pgx")
+	db, err := m.NewDatabase()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	if err = db.Update(&user{ID: 1, Name: "foo"}); err != nil {
+		t.Fatal(err)
+	}
+	var u user
+	found, err := db.Query(&u, sqlwrapper.Where("id = ?", 1))
+	if err != nil || found {
+		t.Fatalf("got %v, %v, want no rows", found, err)
+	}
+	got := m.Statements()
+	if len(got) != 2 {
+		t.Fatalf("got %d statements, want 2", len(got))
+	}
+	if want := `update "user" set "name" = $1 where "id" = $2`; got[0].SQL != want {
+		t.Errorf("got %q, want %q", got[0].SQL, want)
+	}
+	if want := "update user set name = ? where id = ?"; got[0].Normalized != want {
+		t.Errorf("got %q, want %q", got[0].Normalized, want)
+	}
+	if !reflect.DeepEqual(got[0].Args, []driver.Value{"foo", int64(1)}) || got[0].Kind != Exec || got[1].Kind != Query {
+		t.Errorf("got %+v", got)
+	}
+	if err = m.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestExpectations(t *testing.T) {
+	m := New("mysql")
+	db, err := m.NewDatabase()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	m.ExpectExec(SQL("insert into user (name) values (?)")).
+		WithArgs("foo").
+		WillReturnResult(7, 1)
+	m.ExpectQuery(Regexp(`^select .* from user where id = \? limit \?$`)).
+		WithArgs(AnyArg(), 1).
+		WillReturnRows(NewRows("id", "name").AddRow(7, "foo"))
+	errDeleted := errors.New("deleted")
+	m.ExpectExec(Exact("delete from `user` where id = ?")).WithArgs(7).WillReturnError(errDeleted)
+
+	u := user{Name: "foo"}
+	if err = db.Insert(&u); err != nil {
+		t.Fatal(err)
+	}
+	if u.ID != 7 {
+		t.Errorf("got id %d, want 7", u.ID)
+	}
+	var got user
+	if found, err := db.Query(&got, sqlwrapper.Where("id = ?", u.ID)); err != nil || !found || got != u {
+		t.Errorf("got %+v, %v, %v, want %+v", got, found, err, u)
+	}
+	if err = db.Delete("user", sqlwrapper.Where("id = ?", u.ID)); !errors.Is(err, errDeleted) {
+		t.Errorf("got %v, want %v", err, errDeleted)
+	}
+	if err = m.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+
+	// unexpected and unmet
+	m.ExpectExec(SQL("update user set name = ? where id = ?")).WithArgs("bar", 7)
+	m.ExpectExec(SQL("delete from user where id = ?"))
+	u.Name = "baz"
+	if err = db.Update(&u); !errors.Is(err, ErrUnexpectedStatement) {
+		t.Errorf("got %v, want %v", err, ErrUnexpectedStatement)
+	}
+	err = m.ExpectationsWereMet()
+	if !errors.Is(err, ErrUnexpectedStatement) || !errors.Is(err, ErrUnmetExpectation) {
+		t.Errorf("got %v, want both unexpected and unmet", err)
+	}
+
+	m.Reset()
+	if _, err = db.RunTx(func(tx *sqlwrapper.Tx) (bool, error) {
+		return true, tx.Update(&u)
+	}); err != nil {
+		t.Fatal(err)
+	}
+	var stmts []string
+	for _, s := range m.Statements() {
+		stmts = append(stmts, s.Normalized)
+	}
+	if want := []string{"begin", "update user set name = ? where id = ?", "commit"}; !reflect.DeepEqual(stmts, want) {
+		t.Errorf("got %q, want %q", stmts, want)
+	}
+}
+
+func TestExpectTx(t *testing.T) {
+	m := New("sqlite")
+	errSerialization := errors.New("serialization failure")
+	db, err := m.NewDatabase(
+		sqlwrapper.WithTxRetry(sqlwrapper.RetryPolicy{MaxAttempts: 2}),
+		sqlwrapper.WithErrorClassifier(sqlwrapper.ErrorClassifierFunc(func(err error) sqlwrapper.ErrorKind {
+			if errors.Is(err, errSerialization) {
+				return sqlwrapper.SerializationFailure
+			}
+			return sqlwrapper.Unclassified
+		})),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	update := func(tx *sqlwrapper.Tx) (bool, error) {
+		return true, tx.Update(&user{ID: 1, Name: "foo"})
+	}
+	stmt := SQL("update user set name = ? where id = ?")
+
+	// the first commit fails and the transaction is retried
+	m.ExpectBegin()
+	m.ExpectExec(stmt)
+	m.ExpectCommit().WillReturnError(errSerialization)
+	m.ExpectBegin()
+	m.ExpectExec(stmt)
+	m.ExpectCommit()
+	if _, err = db.RunTx(update); err != nil {
+		t.Fatal(err)
+	}
+	if err = m.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+
+	m.Reset()
+	errBegin := errors.New("begin")
+	m.ExpectBegin().WillReturnError(errBegin)
+	if _, err = db.RunTx(update); !errors.Is(err, errBegin) {
+		t.Errorf("got %v, want %v", err, errBegin)
+	}
+
+	m.Reset()
+	errUpdate := errors.New("update")
+	m.ExpectBegin()
+	m.ExpectExec(stmt).WillReturnError(errUpdate)
+	m.ExpectRollback()
+	if _, err = db.RunTx(update); !errors.Is(err, errUpdate) {
+		t.Errorf("got %v, want %v", err, errUpdate)
+	}
+	if err = m.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,140 @@
+package sqlwrapper
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// tagOptions 是 db tag 中列名之后以逗号分隔的选项，用于生成建表语句和编码字段。
+//
+//	type User struct {
+//	    ID    int64
+//	    Name  string  `db:"name,size:64,notnull,index"`
+//	    Email string  `db:",unique"`
+//	    Score float64 `db:"score,type:decimal(10,2),default:0"`
+//	    Group int     `db:",index:idx_group_name"`
+//	}
+//
+// 可用的选项：
+//
+//	type:xxx        // 直接指定列的 SQL 类型
+//	size:n          // 字符串列的长度，默认 255
+//	null / notnull  // 是否可以为 NULL，默认指针和 sql.Null* 类型可以为 NULL，其他类型不可以
+//	default:xxx     // 默认值，原样写入 SQL，如 default:0、default:'none'、default:current_timestamp
+//	unique          // 唯一约束
+//	index[:name]    // 普通索引，同名索引按字段顺序组成联合索引；不指定名称时为 idx_<table>_<column>
+//	uniqueindex[:name] // 唯一索引，规则同 index
+//	json / gob      // 使用内置的 Codec 编码字段，见 Codec
+//	codec:name      // 使用 WithCodec 注册的 Codec 编码字段
+//	onnull:xxx      // 该字段的 NULL 值处理方式：donothing、setzero、setnil、error 或 continue，见 Strategy
+//	always          // Insert 和 Update 总是写入该字段，包括〇值
+//	omitempty       // 〇值不写入，即使使用了不带参数的 IncludingZeros
+//	nullzero        // 〇值写入 NULL
+//	unix            // time.Time 字段以 unix 时间戳写入整数列，单位见 WithUnixTime
+//
+// size 不是正整数或 onnull 不是以上的名称时，RegisterType 返回 ErrInvalidTagOption。
+type tagOptions struct {
+	sqlType string
+	size    int
+	// nullable 为 0 时根据字段类型判断，1 代表 null，-1 代表 not null
+	nullable     int8
+	defaultValue string
+	hasDefault   bool
+	unique       bool
+	indexes      []indexTag
+	// codec 是编码字段使用的 Codec 名称
+	codec string
+	// onNull 是该字段的 NULL 值处理方式，hasOnNull 为 false 时使用 Database 的设置
+	onNull    Strategy
+	hasOnNull bool
+	// write 是 Insert 和 Update 写入〇值的方式
+	write writeMode
+	// unix 为 true 时 time.Time 字段以 unix 时间戳写入
+	unix bool
+}
+
+type writeMode uint8
+
+const (
+	// writeDefault 跳过〇值，除非使用了 IncludingZeros
+	writeDefault writeMode = iota
+	writeAlways
+	writeOmitEmpty
+	writeNullZero
+)
+
+type indexTag struct {
+	name   string
+	unique bool
+}
+
+// parseTag 解析 db tag，返回列名和选项。选项的值无效时返回 ErrInvalidTagOption，未知的选项被忽略。
+func parseTag(tag string) (column string, opts tagOptions, err error) {
+	parts := splitTag(tag)
+	column = parts[0]
+	for _, part := range parts[1:] {
+		key, value, _ := strings.Cut(part, ":")
+		switch strings.ToLower(strings.TrimSpace(key)) {
+		case "type":
+			opts.sqlType = value
+		case "size":
+			opts.size, err = strconv.Atoi(value)
+			if err != nil || opts.size <= 0 {
+				return "", tagOptions{}, fmt.Errorf(f6, ErrInvalidTagOption, part)
+			}
+		case "null":
+			opts.nullable = 1
+		case "notnull":
+			opts.nullable = -1
+		case "default":
+			opts.defaultValue, opts.hasDefault = value, true
+		case "unique":
+			opts.unique = true
+		case "index":
+			opts.indexes = append(opts.indexes, indexTag{name: value})
+		case "uniqueindex":
+			opts.indexes = append(opts.indexes, indexTag{name: value, unique: true})
+		case "json", "gob":
+			opts.codec = strings.ToLower(strings.TrimSpace(key))
+		case "codec":
+			opts.codec = value
+		case "always":
+			opts.write = writeAlways
+		case "omitempty":
+			opts.write = writeOmitEmpty
+		case "nullzero":
+			opts.write = writeNullZero
+		case "unix":
+			opts.unix = true
+		case "onnull":
+			opts.onNull, opts.hasOnNull = parseStrategy(value)
+			if !opts.hasOnNull {
+				return "", tagOptions{}, fmt.Errorf(f6, ErrInvalidTagOption, part)
+			}
+		}
+	}
+	return
+}
+
+// splitTag 以逗号分隔 tag，括号和引号中的逗号不作分隔，如 type:decimal(10,2)。
+func splitTag(tag string) []string {
+	parts := []string{}
+	depth, quoted, start := 0, false, 0
+	for i := 0; i < len(tag); i++ {
+		switch tag[i] {
+		case '\'':
+			quoted = !quoted
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case ',':
+			if depth == 0 && !quoted {
+				parts = append(parts, tag[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(parts, tag[start:])
+}
@@ -0,0 +1,110 @@
+package sqlwrapper
+
+import (
+	"database/sql"
+	"time"
+)
+
+// DefaultTimeLayouts 是内置的 ValueConverter 解析字符串时依次尝试的格式，第一个格式同时用于将时间格式化为字符串。
+//
+// 解析时秒后面的小数部分即使格式中没有也可以接受，所以这些格式也能解析 SQLite 等数据库中带毫秒、微秒的时间。
+// 没有时区的时间按 WithLocation 指定的时区解析，默认为 time.Local。
+var DefaultTimeLayouts = []string{
+	DefaultTimeFormat,           // 2006-01-02 15:04:05
+	"2006-01-02T15:04:05",       // ISO 8601 without zone
+	time.RFC3339,                // 2006-01-02T15:04:05Z07:00
+	"2006-01-02 15:04:05Z07:00", // sqlite
+	"2006-01-02 15:04:05Z07",    // postgres
+	"2006-01-02",                // date only
+}
+
+// parseTime 依次使用 layouts 在 loc 中解析 src，全部失败时返回第一个格式的错误。
+func parseTime(src string, layouts []string, loc *time.Location) (time.Time, error) {
+	var first error
+	for _, layout := range layouts {
+		t, err := time.ParseInLocation(layout, src, loc)
+		if err == nil {
+			return t, nil
+		}
+		if first == nil {
+			first = err
+		}
+	}
+	return time.Time{}, first
+}
+
+// unixTime 将 unit 为单位的 unix 时间戳转换为 time.Time。
+func unixTime(src int64, unit time.Duration, loc *time.Location) time.Time {
+	var t time.Time
+	switch unit {
+	case 0, time.Second:
+		t = time.Unix(src, 0)
+	case time.Millisecond:
+		t = time.UnixMilli(src)
+	case time.Microsecond:
+		t = time.UnixMicro(src)
+	default:
+		t = time.Unix(0, src*int64(unit))
+	}
+	return t.In(loc)
+}
+
+// unixValue 将 t 转换为 unit 为单位的 unix 时间戳，〇值时间返回 0。
+func unixValue(t time.Time, unit time.Duration) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	switch unit {
+	case 0, time.Second:
+		return t.Unix()
+	case time.Millisecond:
+		return t.UnixMilli()
+	case time.Microsecond:
+		return t.UnixMicro()
+	}
+	return t.UnixNano() / int64(unit)
+}
+
+// normalizeTime 将写入数据库的时间转换到数据库的时区，并按精度四舍五入；同时去掉单调时钟读数。
+func (db *Database) normalizeTime(t time.Time) time.Time {
+	if db.loc != nil {
+		t = t.In(db.loc)
+	}
+	return t.Round(db.precision)
+}
+
+// normalizeArgs 对参数中的 time.Time、*time.Time 和 sql.NullTime 调用 normalizeTime。
+// 没有设置 WithLocation 和 WithTimePrecision 时原样返回。
+func (db *Database) normalizeArgs(args []interface{}) []interface{} {
+	if db.loc == nil && db.precision <= 0 {
+		return args
+	}
+	var normalized []interface{}
+	for i, arg := range args {
+		switch a := arg.(type) {
+		case time.Time:
+			arg = db.normalizeTime(a)
+		case *time.Time:
+			if a == nil {
+				continue
+			}
+			arg = db.normalizeTime(*a)
+		case sql.NullTime:
+			if !a.Valid {
+				continue
+			}
+			arg = sql.NullTime{Time: db.normalizeTime(a.Time), Valid: true}
+		default:
+			continue
+		}
+		if normalized == nil {
+			// copy on write, the caller's slice is not modified
+			normalized = append([]interface{}{}, args...)
+		}
+		normalized[i] = arg
+	}
+	if normalized == nil {
+		return args
+	}
+	return normalized
+}
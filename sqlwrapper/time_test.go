@@ -0,0 +1,110 @@
+package sqlwrapper
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseTime(t *testing.T) {
+	shanghai := time.FixedZone("CST", 8*3600)
+	c := vcie{loc: shanghai}
+	for src, want := range map[string]time.Time{
+		"2022-05-01 08:00:00":                 time.Date(2022, 5, 1, 8, 0, 0, 0, shanghai),
+		"2022-05-01 08:00:00.123":             time.Date(2022, 5, 1, 8, 0, 0, 123e6, shanghai),
+		"2022-05-01T08:00:00.123456":          time.Date(2022, 5, 1, 8, 0, 0, 123456e3, shanghai),
+		"2022-05-01T00:00:00Z":                time.Date(2022, 5, 1, 0, 0, 0, 0, time.UTC),
+		"2022-05-01 08:00:00.5+08:00":         time.Date(2022, 5, 1, 8, 0, 0, 5e8, shanghai),
+		"2022-05-01 08:00:00+08":              time.Date(2022, 5, 1, 8, 0, 0, 0, shanghai),
+		"2022-05-01":                          time.Date(2022, 5, 1, 0, 0, 0, 0, shanghai),
+		"2022-05-01T08:00:00.999999999+08:00": time.Date(2022, 5, 1, 8, 0, 0, 999999999, shanghai),
+	} {
+		var got time.Time
+		if err := c.ConvertString(&got, src); err != nil {
+			t.Errorf("%s: %v", src, err)
+			continue
+		}
+		if !got.Equal(want) {
+			t.Errorf("%s: got %v, want %v", src, got, want)
+		}
+	}
+
+	var nt sql.NullTime
+	if err := convertValue(&nt, []byte("2022-05-01 08:00:00"), c, DoNothing); err != nil || !nt.Valid || nt.Time.Location() != shanghai {
+		t.Errorf("got %v %v", nt, err)
+	}
+	var tm time.Time
+	if err := (vcie{layouts: []string{"02/01/2006"}}).ConvertString(&tm, "2022-05-01"); err == nil {
+		t.Error("layouts not used")
+	}
+}
+
+func TestUnixTime(t *testing.T) {
+	want := time.Date(2022, 5, 1, 8, 0, 0, 0, time.UTC)
+	for unit, src := range map[time.Duration]int64{
+		0:                want.Unix(),
+		time.Second:      want.Unix(),
+		time.Millisecond: want.UnixMilli(),
+		time.Microsecond: want.UnixMicro(),
+		time.Nanosecond:  want.UnixNano(),
+	} {
+		var got *time.Time
+		if err := (vcie{unix: unit}).ConvertInt64(&got, src); err != nil {
+			t.Fatal(err)
+		}
+		if !got.Equal(want) {
+			t.Errorf("%v: got %v, want %v", unit, got, want)
+		}
+		if v := unixValue(want, unit); v != src {
+			t.Errorf("%v: got %d, want %d", unit, v, src)
+		}
+	}
+	if v := unixValue(time.Time{}, time.Second); v != 0 {
+		t.Errorf("zero time should be 0, got %d", v)
+	}
+}
+
+type eventEntity struct {
+	ID       int64
+	Start    time.Time
+	Deadline time.Time `db:",unix"`
+}
+
+func (eventEntity) TableName() string { return "event" }
+func (eventEntity) PkColumn() string  { return "id" }
+
+func TestNormalizeTime(t *testing.T) {
+	shanghai := time.FixedZone("CST", 8*3600)
+	f := newFakeDB(t)
+	db := f.open(t, "", WithLocation(shanghai), WithTimePrecision(time.Millisecond), WithUnixTime(time.Millisecond))
+
+	start := time.Date(2022, 5, 1, 0, 0, 0, 123456789, time.UTC)
+	if _, err := db.RawExec("delete from event where start = ? or start = ?", start, &start); err != nil {
+		t.Fatal(err)
+	}
+	want := time.Date(2022, 5, 1, 8, 0, 0, 123e6, shanghai)
+	for _, arg := range f.lastExec(t).args {
+		if got := arg.(time.Time); !got.Equal(want) || got.Location() != shanghai || got.Nanosecond() != 123e6 {
+			t.Errorf("got %v, want %v", got, want)
+		}
+	}
+
+	deadline := time.Date(2022, 5, 2, 0, 0, 0, 0, time.UTC)
+	if err := db.Insert(&eventEntity{ID: 1, Start: start, Deadline: deadline}); err != nil {
+		t.Fatal(err)
+	}
+	args := f.lastExec(t).args
+	if want := []driver.Value{int64(1), want, deadline.UnixMilli()}; !reflect.DeepEqual(args, want) {
+		t.Errorf("got %v, want %v", args, want)
+	}
+	stmts, err := db.CreateTableSQL(&eventEntity{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(stmts[0], "deadline bigint") {
+		t.Errorf("deadline should be an integer column: %s", stmts[0])
+	}
+}
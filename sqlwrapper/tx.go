@@ -4,14 +4,47 @@ import (
 	"context"
 	"database/sql"
 	"fmt"
-	"reflect"
+	"time"
 )
 
 type Tx struct {
 	origin *sql.Tx
 	db     *Database // still need db fields
+
+	// attempt 是 RunTx 第几次执行事务，从 1 开始
+	attempt int
+	// depth 是 RunNested 嵌套的层数，用于保存点的名称
+	depth int
+
+	onCommit, onRollback []func()
+
+	// ctx 携带了 tx 本身，见 Context
+	ctx context.Context
 }
 
+// OnCommit 注册事务提交后调用的函数，如发送消息、清除缓存。多个函数按注册的顺序调用。
+//
+// 事务回滚时不会调用；在 RunNested 中注册而保存点被回滚时也不会调用。
+// 配置了 WithTxRetry 时，失败后被重试的那次执行中注册的函数会被丢弃，由重新执行的 run 再次注册。
+func (tx *Tx) OnCommit(f func()) { tx.onCommit = append(tx.onCommit, f) }
+
+// OnRollback 注册事务最终回滚后调用的函数，包括 run 返回错误、返回 false、panic 和提交失败的情况。多个函数按注册的顺序调用。
+//
+// 在 RunNested 中注册的函数在回滚到保存点后立即调用。被重试的那次执行中注册的函数不会调用。
+func (tx *Tx) OnRollback(f func()) { tx.onRollback = append(tx.onRollback, f) }
+
+func runHooks(hooks []func()) {
+	for _, f := range hooks {
+		f()
+	}
+}
+
+// Context 返回携带 tx 的 context，派生自 RunTxContext 的 ctx。将它传给使用 db.Executor 的代码，这些代码就会加入 tx。
+func (tx *Tx) Context() context.Context { return tx.ctx }
+
+// Attempt 返回这是 RunTx 第几次执行事务，从 1 开始。配置了 WithTxRetry 时 run 可能被执行多次。
+func (tx *Tx) Attempt() int { return tx.attempt }
+
 // RawQuery 封装了 (*sql.Tx).Query 方法。使用方法与 db.RawQuery 基本一致。
 //
 // 对 sql.Rows 的操作封装到 RowsScanner。若结果只有一行，可以使用 SingleRowScanner。
@@ -19,323 +52,75 @@ type Tx struct {
 // 结果有多行时建议使用 ScanFn，详见 RowsScanner 注释。
 func (tx *Tx) RawQuery(query string, s RowsScanner, args ...interface{}) (err error) {
 	// There is already a ctx in origin. No need to create new ctx here.
-	rows, err := tx.origin.Query(query, args...)
+	tx.db.stats.queries.Add(1)
+	rows, err := tx.origin.Query(query, tx.db.normalizeArgs(args)...)
 	if err != nil {
-		return err
+		return tx.db.newError(opQuery, query, err)
 	}
 	err = s.ScanFrom(rows)
 	rows.Close()
+	if err != nil {
+		return tx.db.newError(opQuery, query, err)
+	}
 	return
 }
 
-// RawExec 封装了 (*sql.Tx).Exec 方法，直接返回了 sql.Result 和 error。
+// RawExec 封装了 (*sql.Tx).Exec 方法，直接返回了 sql.Result 和 error。出错时返回 *OpError。
 func (tx *Tx) RawExec(query string, args ...interface{}) (sql.Result, error) {
 	// There is already a ctx in origin. No need to create new ctx here.
-	return tx.origin.Exec(query, args...)
-}
-
-func (tx *Tx) Query(entity interface{}, options ...OptionQuerySingle) (found bool, err error) {
-	if reflect.TypeOf(entity).Kind() != reflect.Ptr {
-		err = ErrNotPointer
-		return
-	}
-	db := tx.db
-	sm, err := db.RegisterType(entity)
+	tx.db.stats.execs.Add(1)
+	result, err := tx.origin.Exec(query, tx.db.normalizeArgs(args)...)
 	if err != nil {
-		return
-	}
-
-	table := ""
-	if e, ok := entity.(IEntity); ok {
-		table = e.TableName()
-	}
-
-	q := &optQuerySingle{
-		optQuery: optQuery{
-			selectColumns: sm.columns,
-			table: optTable{
-				table: optSingleTable{table},
-			},
-			limit: 1,
-		},
+		return nil, tx.db.newError(opExec, query, err)
 	}
-	for _, opt := range options {
-		opt.applyToOptionQuerySingle(q)
-	}
-	ctx := db.newContext()
-	defer db.recycleContext(ctx)
-	err = q.optQuery.AppendToSqlCtx(ctx)
-	if err != nil {
-		return
-	}
-	err = tx.RawQuery(ctx.QueryString(),
-		StructScanner(entity, sm, db.vc, db.onNull, q.unused, &found),
-		ctx.args...)
-	return
+	return result, nil
 }
 
-func (tx *Tx) QueryMultiple(es interface{}, options ...OptionQueryMultiple) (err error) {
-	t := reflect.TypeOf(es)
-	if t.Kind() != reflect.Ptr {
-		return ErrNotPointer
-	}
-	t = t.Elem()
-	if t.Kind() != reflect.Slice {
-		return ErrElemNotSlice
-	}
-	t = t.Elem()
-
-	isPointer := t.Kind() == reflect.Ptr
-	if isPointer {
-		t = t.Elem()
-	}
-
-	if t.Kind() != reflect.Struct {
-		return ErrElemNotStruct
-	}
-
-	entity := reflect.New(t).Interface()
-	db := tx.db
-	sm, err := db.RegisterType(entity)
-	if err != nil {
-		return
-	}
-
-	table := ""
-	if e, ok := entity.(IEntity); ok {
-		table = e.TableName()
-	}
-
-	q := &optQueryMultiple{
-		optQuery: optQuery{
-			selectColumns: sm.columns,
-			table: optTable{
-				table: optSingleTable{table},
-			},
-		},
-	}
-	for _, opt := range options {
-		opt.applyToOptionQueryMultiple(q)
-	}
-
-	ctx := db.newContext()
-	defer db.recycleContext(ctx)
-	err = q.optQuery.AppendToSqlCtx(ctx)
-	if err != nil {
-		return
-	}
-	// fmt.Println(ctx.QueryString(), ctx.args)
-	err = tx.RawQuery(ctx.QueryString(),
-		SliceScanner(es, sm, db.vc, db.onNull, t, isPointer),
-		ctx.args...)
-	return
+// Query 查询一条记录赋值给 entity。使用方法与 db.Query 一致，可以使用 ForUpdate 和 ForShare 锁定记录。
+func (tx *Tx) Query(entity interface{}, options ...OptionQuerySingle) (found bool, err error) {
+	return query(tx.db, tx, entity, options)
 }
 
-func (tx *Tx) Insert(e IEntity, options ...OptionExec) (err error) {
-	db := tx.db
-	sm, err := db.RegisterType(e)
-	if err != nil {
-		return
-	}
-
-	o := &optExec{
-		columns: sm.columns,
-	}
-	for _, opt := range options {
-		opt.applyToOptionExec(o)
-	}
-
-	ctx := db.newContext()
-	defer db.recycleContext(ctx)
-	nColumns := len(o.columns)
-	args := make([]interface{}, 0, nColumns)
-	v := reflect.ValueOf(e)
-	if v.Kind() == reflect.Ptr {
-		v = v.Elem()
-	}
-
-	// 构建 sql 语句
-	ctx.WriteString("insert into ").
-		WriteQuotedString(e.TableName()).
-		WriteQuotedString(" (")
-	for _, column := range o.columns {
-		fm, ok := sm.columnFieldMap[column]
-		if !ok {
-			// 没找到该列，说明调用时传入的 WithColumns 中列名可能写错了。
-			return fmt.Errorf(f5, column)
-		}
-		field := v.Field(fm.index)
-		if field.IsZero() && !o.includingZeros {
-			// 没有指定 includingZeros 时跳过默认〇值的字段
-			continue
-		}
-		if len(args) > 0 {
-			ctx.WriteString(", ")
-		}
-		ctx.WriteQuotedString(column)
-		args = append(args, field.Interface())
-	}
-
-	ctx.WriteString(") values (")
-	for i, a := range args {
-		if i > 0 {
-			ctx.WriteString(", ")
-		}
-		ctx.NextPlaceholder(a)
-	}
-	ctx.WriteByte(')')
-
-	// 传入的是个结构体而非指针，返回了 id 也无法赋值。
-	// 直接执行后结束。
-	if reflect.TypeOf(e).Kind() != reflect.Ptr {
-		_, err = tx.RawExec(ctx.QueryString(), ctx.args...)
-		return
-	}
-
-	pkIndex := sm.pkStructIndex
-	if pkIndex == -1 || !v.Field(pkIndex).IsZero() {
-		// 没有主键字段，或者有主键字段但不为〇值，则不进行 ID 赋值，执行后直接返回。
-		_, err = tx.RawExec(ctx.QueryString(), ctx.args...)
-		return
-	}
-
-	var newId interface{}
-	// 使用 interface{} 类型的原因有二。
-	// 1. 使用 query returning 方式返回时 Scan 赋值不会报错；
-	// 2. 需要考虑主键是字符串的情况。
-	switch db.driver {
-	case "pgx", "postgres":
-		ctx.WriteString(" returning ").WriteQuotedString(e.PkColumn())
-		err = tx.RawQuery(ctx.QueryString(), SingleRowScanner(&newId), ctx.args...)
-		if err != nil {
-			return
-		}
-		// t := reflect.TypeOf(newId)
-		// log.LogInfo(t.Kind(), t.String())
-
-		// 不能确定 returning 的 newId 一定是 int 类型（有可能是 string），需要判断。
-		// 除了 string/uuid -> int 不行以外其他都可以转。
-		if !reflect.TypeOf(newId).ConvertibleTo(sm.pkType) {
-			return
-		}
-	case "mssql", "sqlserver":
-		if sm.pkType.Kind() == reflect.String {
-			// sqlserver 没有办法返回字符串类型主键，直接执行后返回。
-			_, err = tx.RawExec(ctx.QueryString(), ctx.args...)
-			return
-		}
-		ctx.WriteString("; select last_id = convert(bigint, SCOPE_IDENTITY())")
-		err = tx.RawQuery(ctx.QueryString(), SingleRowScanner(&newId), ctx.args...)
-		if err != nil {
-			return
-		}
-	default:
-		var result sql.Result
-		result, err = tx.RawExec(ctx.QueryString(), ctx.args...)
-		if err != nil {
-			return
-		}
-		var err1 error
-		// 获取新记录的 ID。
-		newId, err1 = result.LastInsertId()
-		if err1 != nil {
-			// 不支持 LastInsertId 方法，直接返回。
-			// TODO: write a warning log
-			return
-		}
-	}
+// QueryMultiple 查询多条记录赋值给 es。使用方法与 db.QueryMultiple 一致，可以使用 ForUpdate 和 ForShare 锁定记录。
+func (tx *Tx) QueryMultiple(es interface{}, options ...OptionQueryMultiple) error {
+	return queryMultiple(tx.db, tx, es, options)
+}
 
-	pkField := v.Field(pkIndex)
-	pkField.Set(reflect.ValueOf(newId).Convert(sm.pkType))
-	return
+// Insert 将 e 插入数据库。使用方法与 db.Insert 一致。
+func (tx *Tx) Insert(e IEntity, options ...OptionExec) error {
+	return insert(tx.db, tx, e, options)
 }
 
-// Update 更新 e 对应的数据库中的记录。e 的主键字段必须非空。
+// Update 更新 e 对应的数据库中的记录。e 的主键字段必须非空。没有需要更新的列（例如全部是被跳过的〇值字段）时不执行语句。
 //
 // 只想保存，不想管是插入还是更新的话，可以使用通用方法 Save。
-func (tx *Tx) Update(e IEntity, options ...OptionExec) (err error) {
-	db := tx.db
-	sm, err := db.RegisterType(e)
-	if err != nil {
-		return
-	}
-
-	v := reflect.ValueOf(e)
-	if v.Type().Kind() == reflect.Ptr {
-		v = v.Elem()
-	}
-
-	pkField := v.Field(sm.pkStructIndex)
-	if pkField.IsZero() {
-		err = fmt.Errorf(f4, e.PkColumn())
-		return
-	}
-
-	o := &optExec{columns: sm.columns}
-	for _, opt := range options {
-		opt.applyToOptionExec(o)
-	}
-
-	nColumns := len(o.columns)
-	if nColumns == 0 {
-		// do nothing and return
-		return
-	}
-	ctx := db.newContext()
-	defer db.recycleContext(ctx)
-
-	ctx.WriteString("update ").
-		WriteQuotedString(e.TableName()).
-		WriteString(" set ")
+func (tx *Tx) Update(e IEntity, options ...OptionExec) error {
+	return update(tx.db, tx, e, options)
+}
 
-	for _, column := range o.columns {
-		if column == e.PkColumn() {
-			// 主键字段放 where 子句里面，set 里面不用填
-			continue
-		}
-		fm, ok := sm.columnFieldMap[column]
-		if !ok {
-			// 没找到该列，说明调用时传入的 WithColumns 中列名可能写错了。
-			return fmt.Errorf(f5, column)
-		}
-		field := v.Field(fm.index)
-		if field.IsZero() && !o.includingZeros {
-			// 没有指定 includingZeros 时跳过默认〇值的字段
-			continue
-		}
-		if len(ctx.args) > 0 {
-			ctx.WriteString(", ")
-		}
-		ctx.WriteQuotedString(column).
-			WriteString(" = ").
-			NextPlaceholder(field.Interface())
-	}
+// Save 将 e 保存到数据库中。当 e 主键字段为空时插入，非空时更新。
+func (tx *Tx) Save(e IEntity, options ...OptionExec) error {
+	return save(tx.db, tx, e, options)
+}
 
-	ctx.WriteString(" where ").
-		WriteQuotedString(e.PkColumn()).
-		WriteString(" = ").
-		NextPlaceholder(pkField.Interface())
+// InsertSelect 将查询结果插入 table 的 columns 列中。使用方法与 db.InsertSelect 一致。
+func (tx *Tx) InsertSelect(table string, columns []string, options ...OptionQueryMultiple) error {
+	return insertSelect(tx.db, tx, table, columns, options)
+}
 
-	_, err = tx.RawExec(ctx.QueryString(), ctx.args...)
-	return
+// Delete 删除 table 中满足条件的记录。与 DeleteWhere 相同。
+func (tx *Tx) Delete(table string, options ...OptionDelete) error {
+	return tx.DeleteWhere(table, options...)
 }
 
-// Save 将 e 保存到数据库中。当 e 主键字段为空时插入，非空时更新。
-func (tx *Tx) Save(e IEntity, options ...OptionExec) error {
-	sm, err := tx.db.RegisterType(e)
-	if err != nil {
-		return err
-	}
-	v := reflect.ValueOf(e)
-	if v.Type().Kind() == reflect.Ptr {
-		v = v.Elem()
-	}
+// UpdateWhere 更新 table 中满足条件的记录。使用方法与 db.UpdateWhere 一致。
+func (tx *Tx) UpdateWhere(table string, options ...OptionUpdate) error {
+	return updateWhere(tx.db, tx, table, options)
+}
 
-	pkField := v.Field(sm.pkStructIndex)
-	if pkField.IsZero() {
-		return tx.Insert(e, options...)
-	}
-	return tx.Update(e, options...)
+// DeleteWhere 删除 table 中满足条件的记录。使用方法与 db.DeleteWhere 一致。
+func (tx *Tx) DeleteWhere(table string, options ...OptionDelete) error {
+	return deleteWhere(tx.db, tx, table, options)
 }
 
 type TransactionStep int8
@@ -349,7 +134,9 @@ const (
 
 // RunTx can run a transaction. To pass *sql.TxOptions as a parameter, use RunTxWithOptions.
 //
-// TransactionStep represents the last step of transaction before exit. The corresponding error is also returned. Transaction is successfully commited if and only if TransactionStep is StepEnd.
+// TransactionStep represents the last step of transaction before exit. The corresponding error is also returned. Transaction is successfully commited if and only if TransactionStep is StepEnd and commit is true.
+//
+// A panic in run rolls back the transaction and is returned as an error wrapping ErrPanicInTx. Use tx.OnCommit and tx.OnRollback to run functions after the transaction ends.
 //
 //	db.RunTx(func(tx *Tx) (commit bool, err error) {
 //	    err := tx.Insert(&foo)
@@ -372,22 +159,104 @@ func (db *Database) RunTxWithOptions(
 	run func(tx *Tx) (commit bool, err error),
 	txOptions *sql.TxOptions,
 ) (TransactionStep, error) {
-	ctx, cancel := context.WithCancel(db.ctx)
+	return db.RunTxContext(db.ctx, run, txOptions)
+}
+
+// RunTxContext runs a transaction with ctx. The transaction is rolled back when ctx is done or db is closed.
+//
+// If a retry policy is set with WithTxRetry, run is called again with a new Tx when the transaction fails with a retryable error.
+// Waiting between attempts stops when ctx is done. When more than one attempt is made, the returned error reports the number of attempts and wraps the last error.
+//
+// If ctx already carries a Tx of db (see Tx.Context), run joins that transaction in a savepoint with tx.RunNested instead of beginning a new one.
+// txOptions and the retry policy are ignored in this case; the outer transaction decides whether to commit or retry.
+func (db *Database) RunTxContext(
+	ctx context.Context,
+	run func(tx *Tx) (commit bool, err error),
+	txOptions *sql.TxOptions,
+) (TransactionStep, error) {
+	if tx, ok := TxFromContext(ctx); ok && tx.db == db {
+		if err := tx.RunNested(run); err != nil {
+			return StepRun, err
+		}
+		return StepEnd, nil
+	}
+	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
-	tx, err := db.origin.BeginTx(ctx, txOptions)
-	if err != nil {
-		return StepBegin, fmt.Errorf(fx1, err)
+	stop := context.AfterFunc(db.ctx, cancel)
+	defer stop()
+
+	p := &db.retry
+	for attempt := 1; ; attempt++ {
+		step, onRollback, err := db.runTx(ctx, run, txOptions, attempt)
+		if err == nil || attempt >= p.MaxAttempts || !p.retryable(db.kindOf(err)) {
+			runHooks(onRollback)
+			if err != nil && attempt > 1 {
+				err = fmt.Errorf(f9, attempt, err)
+			}
+			return step, err
+		}
+		db.stats.retries.Add(1)
+		if p.OnRetry != nil {
+			p.OnRetry(attempt, err)
+		}
+		timer := time.NewTimer(p.backoff(attempt))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			runHooks(onRollback)
+			return step, fmt.Errorf(f9, attempt, err)
+		case <-timer.C:
+		}
 	}
-	defer tx.Rollback()
-	commit, err := run(&Tx{tx, db})
+}
+
+// runTx 执行一次事务。提交成功时调用 OnCommit 注册的函数；否则回滚，并返回 OnRollback 注册的函数，
+// 由调用者在确定不再重试时调用。
+func (db *Database) runTx(
+	ctx context.Context,
+	run func(tx *Tx) (commit bool, err error),
+	txOptions *sql.TxOptions,
+	attempt int,
+) (TransactionStep, []func(), error) {
+	origin, err := db.origin.BeginTx(ctx, txOptions)
 	if err != nil {
-		return StepRun, err
-	}
-	if commit {
-		err = tx.Commit()
+		return StepBegin, nil, db.newError("begin", "", err)
+	}
+	tx := &Tx{origin: origin, db: db, attempt: attempt}
+	tx.ctx = ContextWithTx(ctx, tx)
+	commit, err := tx.run(run)
+	if err != nil || !commit {
+		origin.Rollback()
+		db.stats.rollbacks.Add(1)
 		if err != nil {
-			return StepCommit, err
+			return StepRun, tx.onRollback, err
+		}
+		return StepEnd, tx.onRollback, nil
+	}
+	if err = origin.Commit(); err != nil {
+		// the transaction is aborted by database/sql when commit fails
+		db.stats.rollbacks.Add(1)
+		return StepCommit, tx.onRollback, db.newError("commit", "", err)
+	}
+	db.stats.commits.Add(1)
+	runHooks(tx.onCommit)
+	return StepEnd, nil, nil
+}
+
+// run 调用 run，将 run 中的 panic 转换为错误。
+func (tx *Tx) run(run func(tx *Tx) (commit bool, err error)) (commit bool, err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			commit, err = false, panicError(p)
 		}
+	}()
+	return run(tx)
+}
+
+// panicError 将 recover 的结果转换为包装了 ErrPanicInTx 的错误，p 为 error 时同时包装 p。
+func panicError(p interface{}) error {
+	if err, ok := p.(error); ok {
+		return fmt.Errorf(fx2, ErrPanicInTx, err)
 	}
-	return StepEnd, nil
+	return fmt.Errorf(fx1, ErrPanicInTx, p)
 }
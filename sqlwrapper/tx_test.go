@@ -0,0 +1,90 @@
+package sqlwrapper
+
+import (
+	"errors"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTxHooks(t *testing.T) {
+	f := newFakeDB(t)
+	db := f.open(t, "",
+		WithErrorClassifier(mysqlClassifier),
+		WithTxRetry(RetryPolicy{MaxAttempts: 2, Backoff: time.Millisecond}),
+	)
+
+	var calls []string
+	hook := func(name string) func() { return func() { calls = append(calls, name) } }
+	check := func(name string, want ...string) {
+		t.Helper()
+		if !reflect.DeepEqual(calls, want) {
+			t.Errorf("%s: got %q, want %q", name, calls, want)
+		}
+		calls = nil
+	}
+
+	db.RunTx(func(tx *Tx) (bool, error) {
+		tx.OnCommit(hook("commit 1"))
+		tx.OnRollback(hook("rollback"))
+		tx.OnCommit(hook("commit 2"))
+		return true, nil
+	})
+	check("commit", "commit 1", "commit 2")
+
+	errRun := errors.New("run failed")
+	_, err := db.RunTx(func(tx *Tx) (bool, error) {
+		tx.OnCommit(hook("commit"))
+		tx.OnRollback(hook("rollback"))
+		return true, errRun
+	})
+	check("error", "rollback")
+	if err != errRun {
+		t.Errorf("got %v, want %v", err, errRun)
+	}
+
+	db.RunTx(func(tx *Tx) (bool, error) {
+		tx.OnRollback(hook("rollback"))
+		return false, nil
+	})
+	check("no commit", "rollback")
+
+	f.takeTxCounts()
+	step, err := db.RunTx(func(tx *Tx) (bool, error) {
+		tx.OnRollback(hook("rollback"))
+		panic("boom")
+	})
+	check("panic", "rollback")
+	if _, rollbacks := f.takeTxCounts(); step != StepRun || !errors.Is(err, ErrPanicInTx) || !strings.Contains(err.Error(), "boom") || rollbacks != 1 {
+		t.Errorf("panic: got %v %v, %d rollbacks", step, err, rollbacks)
+	}
+	errPanic := errors.New("panic error")
+	if _, err = db.RunTx(func(tx *Tx) (bool, error) { panic(errPanic) }); !errors.Is(err, errPanic) {
+		t.Errorf("got %v, want wrapped %v", err, errPanic)
+	}
+
+	db.RunTx(func(tx *Tx) (bool, error) {
+		tx.OnCommit(hook("outer commit"))
+		tx.RunNested(func(tx *Tx) (bool, error) {
+			tx.OnCommit(hook("nested commit"))
+			tx.OnRollback(hook("nested rollback"))
+			return false, nil
+		})
+		tx.RunNested(func(tx *Tx) (bool, error) {
+			tx.OnCommit(hook("released commit"))
+			return true, nil
+		})
+		return true, nil
+	})
+	check("nested", "nested rollback", "outer commit", "released commit")
+
+	deadlock := &mysqlError{1213, "Deadlock found"}
+	db.RunTx(func(tx *Tx) (bool, error) {
+		tx.OnRollback(hook("rollback " + strconv.Itoa(tx.Attempt())))
+		tx.OnCommit(hook("commit " + strconv.Itoa(tx.Attempt())))
+		return true, deadlock
+	})
+	check("retry", "rollback 2")
+}